@@ -2,27 +2,106 @@ package install
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"go/build"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 )
 
 type packageInfo struct {
 	ImportPath  string
 	Name        string
 	Imports     []string
+	Deps        []string
 	GoFiles     []string
 	Standard    bool
 	Stale       bool
 	StaleReason string
+	// Error is set if go list -e fails to load the package.
+	Error *packageError
+	// Module is set only in module mode.
+	Module *moduleInfo
+}
+
+// packageError represents an error to load a package reported by go list -e.
+type packageError struct {
+	Err string
+}
+
+// moduleInfo represents the module of a package reported by go list in module mode.
+type moduleInfo struct {
+	Path    string
+	Version string
+	Main    bool
+	Replace *moduleInfo
+}
+
+// moduleKey returns the identity of the module version which provides pkg (e.g. "golang.org/x/text@v0.3.0").
+// If the module is replaced, the replacement is appended after "=>".
+// moduleKey returns an empty string in GOPATH mode.
+func (pkg *packageInfo) moduleKey() string {
+	m := pkg.Module
+	if m == nil {
+		return ""
+	}
+	key := m.Path
+	if m.Version != "" {
+		key += "@" + m.Version
+	}
+	if r := m.Replace; r != nil {
+		key += "=>" + r.Path
+		if r.Version != "" {
+			key += "@" + r.Version
+		}
+	}
+	return key
+}
+
+// GoCommand returns exec.Cmd to run the go command with args.
+// If modDir is not empty, the command runs in module mode in modDir.
+func GoCommand(ctx context.Context, modDir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "go", args...)
+	if modDir != "" {
+		cmd.Dir = modDir
+		cmd.Env = append(os.Environ(), "GO111MODULE=on")
+	}
+	return cmd
+}
+
+var (
+	stdPkgsOnce sync.Once
+	stdPkgs     map[string]bool
+)
+
+// loadStdPkgs returns the set of packages in std library listed by go list std.
+// The list is cached because IsStdPkg is called for every import in cells.
+// It returns nil if the go command fails.
+func loadStdPkgs() map[string]bool {
+	stdPkgsOnce.Do(func() {
+		out, err := GoCommand(context.Background(), "", "list", "std").Output()
+		if err != nil {
+			return
+		}
+		stdPkgs = make(map[string]bool)
+		for _, pkg := range strings.Fields(string(out)) {
+			stdPkgs[pkg] = true
+		}
+	})
+	return stdPkgs
 }
 
 // IsStdPkg returns whether the package of path is in std library.
+// path can be a pattern with "..." (e.g. "net/...").
 func IsStdPkg(path string) bool {
 	// cf. https://golang.org/src/cmd/go/internal/load/pkg.go
 	i := strings.Index(path, "/")
@@ -30,7 +109,32 @@ func IsStdPkg(path string) bool {
 		i = len(path)
 	}
 	elem := path[:i]
-	return !strings.Contains(elem, ".")
+	if strings.Contains(elem, ".") {
+		return false
+	}
+	// Paths without dots in the first element are reserved for std. But GOPATH and replaced modules
+	// may have packages with such paths (e.g. packages defined with %%package in lgo).
+	std := loadStdPkgs()
+	if std == nil {
+		// Fall back to GOROOT if the go command is not available.
+		dir := path
+		if i := strings.Index(dir, "..."); i >= 0 {
+			dir = dir[:i]
+		}
+		_, err := os.Stat(filepath.Join(build.Default.GOROOT, "src", filepath.FromSlash(dir)))
+		return err == nil
+	}
+	i = strings.Index(path, "...")
+	if i < 0 {
+		return std[path]
+	}
+	prefix := path[:i]
+	for pkg := range std {
+		if strings.HasPrefix(pkg, prefix) || pkg+"/" == prefix {
+			return true
+		}
+	}
+	return false
 }
 
 func soFileName(path string) string {
@@ -41,6 +145,21 @@ func pkgDir(lgopath string) string {
 	return path.Join(lgopath, "pkg")
 }
 
+// modLibDir is the directory in $LGOPATH/pkg where .so files are linked with their module versions.
+const modLibDir = "mod"
+
+// modLibPath returns the path to the .so file of pkg linked with the module version of pkg
+// (e.g. $LGOPATH/pkg/mod/golang.org%2Fx%2Ftext@v0.3.0/libgolang.org-x-text-width.so).
+// modLibPath returns an empty string in GOPATH mode.
+func modLibPath(pkgDir string, pkg *packageInfo) string {
+	key := pkg.moduleKey()
+	if key == "" {
+		return ""
+	}
+	// The key is escaped because paths of replacements may contain "..".
+	return path.Join(pkgDir, modLibDir, url.PathEscape(key), soFileName(pkg.ImportPath))
+}
+
 func IsSOInstalled(lgopath string, pkg string) bool {
 	_, err := os.Stat(path.Join(pkgDir(lgopath), soFileName(pkg)))
 	os.IsNotExist(err)
@@ -48,8 +167,14 @@ func IsSOInstalled(lgopath string, pkg string) bool {
 }
 
 type SOInstaller struct {
+	// Parallelism is the max number of packages built concurrently. If it is not positive, runtime.NumCPU() is used.
+	Parallelism int
+
 	cache  map[string]*packageInfo
 	pkgDir string
+	// modDir is the root directory of the module whose build list is used to resolve packages.
+	// modDir is empty in GOPATH mode.
+	modDir string
 }
 
 func NewSOInstaller(lgopath string) *SOInstaller {
@@ -59,6 +184,88 @@ func NewSOInstaller(lgopath string) *SOInstaller {
 	}
 }
 
+// NewModuleSOInstaller returns a new SOInstaller which resolves packages in module mode
+// with go.mod in modDir. .so files are linked with their module versions in $LGOPATH/pkg/mod.
+func NewModuleSOInstaller(lgopath, modDir string) *SOInstaller {
+	si := NewSOInstaller(lgopath)
+	si.modDir = modDir
+	return si
+}
+
+func (si *SOInstaller) goCommand(args ...string) *exec.Cmd {
+	return GoCommand(context.Background(), si.modDir, args...)
+}
+
+// isModLibInstalled returns whether the .so file of pkg in pkgDir is built from the module version of pkg.
+// .so files in pkgDir are named only with import paths because go install -buildmode=shared names them so.
+// Thus, .so files are linked to modLibPath to record their module versions.
+func (si *SOInstaller) isModLibInstalled(pkg *packageInfo) bool {
+	mod := modLibPath(si.pkgDir, pkg)
+	if mod == "" {
+		return true
+	}
+	modInfo, err := os.Stat(mod)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path.Join(si.pkgDir, soFileName(pkg.ImportPath)))
+	if err != nil {
+		return false
+	}
+	// The .so file is replaced when the package is reinstalled from another module version.
+	return os.SameFile(modInfo, info)
+}
+
+// linkModLib links the .so file of pkg to modLibPath to record the module version which it is built from.
+func (si *SOInstaller) linkModLib(pkg *packageInfo) error {
+	mod := modLibPath(si.pkgDir, pkg)
+	if mod == "" {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(mod), 0766); err != nil {
+		return err
+	}
+	if err := os.Remove(mod); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(path.Join(si.pkgDir, soFileName(pkg.ImportPath)), mod)
+}
+
+// isInstalled returns whether the .so file of pkg is installed.
+// In module mode, the .so file must be built from the module version in the current build list.
+func (si *SOInstaller) isInstalled(pkg string) (bool, error) {
+	if _, err := os.Stat(path.Join(si.pkgDir, soFileName(pkg))); err != nil {
+		return false, nil
+	}
+	if si.modDir == "" {
+		return true, nil
+	}
+	info, err := si.getPackage(pkg)
+	if err != nil {
+		return false, err
+	}
+	return si.isModLibInstalled(info), nil
+}
+
+// NotInstalled returns packages in pkgs whose .so files are not installed yet.
+// In module mode, packages installed from other module versions are also returned.
+func (si *SOInstaller) NotInstalled(pkgs ...string) ([]string, error) {
+	var need []string
+	for _, pkg := range pkgs {
+		if pkg == "C" || IsStdPkg(pkg) {
+			continue
+		}
+		ok, err := si.isInstalled(pkg)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			need = append(need, pkg)
+		}
+	}
+	return need, nil
+}
+
 // TODO: File bugs to explain reasons.
 var knownIncompatiblePkgs = map[string]bool{
 	"golang.org/x/sys/plan9":                    true,
@@ -69,34 +276,212 @@ var knownIncompatiblePkgs = map[string]bool{
 	"k8s.io/apimachinery/pkg/util/sets": true,
 }
 
-// Install
+// Install installs .so files of packages matched with patterns and their dependencies into $LGOPATH/pkg.
 func (si *SOInstaller) Install(patterns ...string) error {
+	return si.InstallContext(context.Background(), patterns...)
+}
+
+// InstallContext is Install with a context. Builds are stopped when ctx is cancelled.
+// Independent packages are built concurrently with at most si.Parallelism go commands.
+// InstallContext stops building packages on the first failure.
+func (si *SOInstaller) InstallContext(ctx context.Context, patterns ...string) error {
 	pkgs, err := si.getPackageList(patterns...)
 	if err != nil {
 		return err
 	}
-	dry := walker{si: si, dryRun: true}
+	w := walker{si: si}
 	for _, pkg := range pkgs {
-		dry.walk(pkg.ImportPath)
+		if err := w.walk(pkg.ImportPath); err != nil {
+			return err
+		}
 	}
-	w := walker{
-		si:          si,
-		progressAll: len(dry.visited),
+	b := &builder{
+		parallelism: si.Parallelism,
+		build:       si.build,
 		logger: func(s string) {
 			fmt.Fprintln(os.Stderr, s)
 		},
 		stdout: os.Stdout,
 		stderr: os.Stderr,
 	}
-	ok := true
+	if err := b.run(ctx, w.pkgs); err != nil {
+		return fmt.Errorf("failed to install .so files: %v", err)
+	}
+	return nil
+}
+
+// build installs the .so file of pkg.
+func (si *SOInstaller) build(ctx context.Context, pkg *packageInfo, stdout, stderr io.Writer) error {
+	cmd := GoCommand(ctx, si.modDir, "install", "-buildmode=shared", "-linkshared", "-pkgdir", si.pkgDir, pkg.ImportPath)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	if err := si.linkModLib(pkg); err != nil {
+		return fmt.Errorf("failed to record the module version: %v", err)
+	}
+	return nil
+}
+
+// walker lists packages to install in the order of dependencies.
+type walker struct {
+	si      *SOInstaller
+	visited map[string]bool
+	// pkgs are packages to install. Dependencies of a package precede the package.
+	pkgs []*packageInfo
+}
+
+func (w *walker) walk(path string) error {
+	if path == "C" || IsStdPkg(path) {
+		// Ignore "C" import and std packages.
+		return nil
+	}
+	if w.visited[path] {
+		return nil
+	}
+	pkg, err := w.si.getPackage(path)
+	if err != nil {
+		return fmt.Errorf("failed to get package info for %q: %v", path, err)
+	}
+	if pkg.Name == "main" {
+		return nil
+	}
+	if w.visited == nil {
+		w.visited = make(map[string]bool)
+	}
+	w.visited[path] = true
+	for _, im := range pkg.Imports {
+		if err := w.walk(im); err != nil {
+			return err
+		}
+	}
+	w.pkgs = append(w.pkgs, pkg)
+	return nil
+}
+
+// builder builds packages concurrently. A package is built after all its dependencies are built.
+type builder struct {
+	// parallelism is the max number of packages built at once. If it is not positive, runtime.NumCPU() is used.
+	parallelism int
+	build       func(ctx context.Context, pkg *packageInfo, stdout, stderr io.Writer) error
+
+	mu             sync.Mutex
+	progressAll    int
+	progress       int
+	logger         func(string)
+	stdout, stderr io.Writer
+}
+
+func (b *builder) logf(format string, a ...interface{}) {
+	if b.logger == nil {
+		return
+	}
+	pre := ""
+	if b.progressAll > 0 {
+		pre = fmt.Sprintf("(%d/%d) ", b.progress, b.progressAll)
+	}
+	b.logger(pre + fmt.Sprintf(format, a...))
+}
+
+// buildResult is the result of the build of a package.
+type buildResult struct {
+	pkg *packageInfo
+	err error
+}
+
+// run builds pkgs. pkgs must be sorted in the order of dependencies.
+// run stops scheduling new builds on the first failure or when ctx is cancelled and returns after running builds finish.
+func (b *builder) run(ctx context.Context, pkgs []*packageInfo) error {
+	parallelism := b.parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+	b.progressAll = len(pkgs)
+	// waiting[path] is the number of dependencies of path which are not built yet.
+	waiting := make(map[string]int)
+	dependents := make(map[string][]*packageInfo)
+	for _, pkg := range pkgs {
+		waiting[pkg.ImportPath] = 0
+	}
+	var ready []*packageInfo
 	for _, pkg := range pkgs {
-		if cok := w.walk(pkg.ImportPath); !cok {
-			ok = false
+		for _, im := range pkg.Imports {
+			if _, ok := waiting[im]; ok {
+				waiting[pkg.ImportPath]++
+				dependents[im] = append(dependents[im], pkg)
+			}
+		}
+		if waiting[pkg.ImportPath] == 0 {
+			ready = append(ready, pkg)
+		}
+	}
+
+	results := make(chan buildResult)
+	running := 0
+	var err error
+	for {
+		for err == nil && len(ready) > 0 && running < parallelism {
+			if cerr := ctx.Err(); cerr != nil {
+				err = cerr
+				break
+			}
+			pkg := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- buildResult{pkg, b.buildOne(ctx, pkg)}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		if r.err != nil {
+			if err == nil {
+				err = r.err
+			}
+			continue
+		}
+		for _, d := range dependents[r.pkg.ImportPath] {
+			waiting[d.ImportPath]--
+			if waiting[d.ImportPath] == 0 {
+				ready = append(ready, d)
+			}
 		}
 	}
-	if !ok {
-		return errors.New("failed to install .so files")
+	return err
+}
+
+// buildOne builds pkg. Outputs of the build are written at once after the build
+// so that outputs of concurrent builds are not mixed.
+func (b *builder) buildOne(ctx context.Context, pkg *packageInfo) error {
+	path := pkg.ImportPath
+	var err error
+	var stdout, stderr bytes.Buffer
+	if knownIncompatiblePkgs[path] {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.progress++
+		b.logf("skipped %q: known incompatible package", path)
+		return nil
+	}
+	err = b.build(ctx, pkg, &stdout, &stderr)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.progress++
+	if b.stdout != nil {
+		b.stdout.Write(stdout.Bytes())
+	}
+	if b.stderr != nil {
+		b.stderr.Write(stderr.Bytes())
+	}
+	if err != nil {
+		b.logf("failed to install %q: %v", path, err)
+		return fmt.Errorf("failed to install %q: %v", path, err)
 	}
+	b.logf("installed %q", path)
 	return nil
 }
 
@@ -116,7 +501,7 @@ func (si *SOInstaller) getPackage(path string) (*packageInfo, error) {
 }
 
 func (si *SOInstaller) getPackageList(args ...string) (infos []*packageInfo, err error) {
-	cmd := exec.Command("go", append([]string{"list", "-json"}, args...)...)
+	cmd := si.goCommand(append([]string{"list", "-json"}, args...)...)
 	cmd.Stderr = os.Stderr
 	// We do not need to close r (https://golang.org/pkg/os/exec/#Cmd.StdoutPipe).
 	r, err := cmd.StdoutPipe()
@@ -147,74 +532,3 @@ func (si *SOInstaller) getPackageList(args ...string) (infos []*packageInfo, err
 	}
 	return infos, nil
 }
-
-type walker struct {
-	si      *SOInstaller
-	visited map[string]bool
-
-	progressAll    int
-	progress       int
-	dryRun         bool
-	logger         func(string)
-	stdout, stderr io.Writer
-}
-
-func (w *walker) logf(format string, a ...interface{}) {
-	if w.logger == nil {
-		return
-	}
-	pre := ""
-	if w.progressAll > 0 {
-		pre = fmt.Sprintf("(%d/%d) ", w.progress, w.progressAll)
-	}
-	w.logger(pre + fmt.Sprintf(format, a...))
-}
-
-func (w *walker) walk(path string) bool {
-	if path == "C" || IsStdPkg(path) {
-		// Ignore "C" import and std packages.
-		return true
-	}
-	if w.visited[path] {
-		return true
-	}
-	pkg, err := w.si.getPackage(path)
-	if err != nil {
-		w.logf("failed to get package info for %q: %v", path, err)
-		return false
-	}
-	if pkg.Name == "main" {
-		return true
-	}
-	if w.visited == nil {
-		w.visited = make(map[string]bool)
-	}
-	w.visited[path] = true
-	depok := true
-	for _, im := range pkg.Imports {
-		if ok := w.walk(im); !ok {
-			depok = false
-		}
-	}
-	w.progress++
-	if !depok {
-		w.logf("skipped %q due to failures in dependencies", path)
-		return false
-	}
-	if w.dryRun {
-		return true
-	}
-	if knownIncompatiblePkgs[path] {
-		w.logf("skipped %q: known incompatible package", path)
-		return true
-	}
-	cmd := exec.Command("go", "install", "-buildmode=shared", "-linkshared", "-pkgdir", w.si.pkgDir, path)
-	cmd.Stdout = w.stdout
-	cmd.Stderr = w.stderr
-	if err := cmd.Run(); err != nil {
-		w.logf("failed to install %q: %v", path, err)
-		return false
-	}
-	w.logf("installed %q", path)
-	return true
-}
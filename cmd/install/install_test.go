@@ -1,9 +1,18 @@
 package install
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestIsStdPkg(t *testing.T) {
@@ -14,8 +23,12 @@ func TestIsStdPkg(t *testing.T) {
 		{"os", true},
 		{"os/exec", true},
 		{"os/...", true},
+		{"net/ht...", true},
+		{"internal/...", true},
+		{"os/nosuchpkg", false},
 		{"github.com/yunabe/lgo", false},
 		{"github.com/yunabe/...", false},
+		{"mylib/geom", false},
 	}
 	for _, tc := range tests {
 		got := IsStdPkg(tc.path)
@@ -70,3 +83,175 @@ func TestGetPackageList(t *testing.T) {
 		t.Errorf("got %v, want %v", paths, want)
 	}
 }
+
+func TestModuleKey(t *testing.T) {
+	tests := []struct {
+		mod  *moduleInfo
+		want string
+	}{
+		{nil, ""},
+		{&moduleInfo{Path: "golang.org/x/text", Version: "v0.3.0"}, "golang.org/x/text@v0.3.0"},
+		{&moduleInfo{Path: "example.com/m", Main: true}, "example.com/m"},
+		{
+			&moduleInfo{Path: "golang.org/x/text", Version: "v0.3.0", Replace: &moduleInfo{Path: "/src/text"}},
+			"golang.org/x/text@v0.3.0=>/src/text",
+		},
+		{
+			&moduleInfo{Path: "golang.org/x/text", Version: "v0.3.0", Replace: &moduleInfo{Path: "example.com/text", Version: "v0.1.0"}},
+			"golang.org/x/text@v0.3.0=>example.com/text@v0.1.0",
+		},
+	}
+	for _, tc := range tests {
+		pkg := &packageInfo{Module: tc.mod}
+		if got := pkg.moduleKey(); got != tc.want {
+			t.Errorf("moduleKey() = %q; want %q", got, tc.want)
+		}
+	}
+}
+
+func TestModLib(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgo_modlib_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	si := NewModuleSOInstaller(dir, dir)
+	v1 := &packageInfo{ImportPath: "example.com/a/b", Module: &moduleInfo{Path: "example.com/a", Version: "v1.0.0"}}
+	v2 := &packageInfo{ImportPath: "example.com/a/b", Module: &moduleInfo{Path: "example.com/a", Version: "v2.0.0"}}
+	if got, want := modLibPath(si.pkgDir, v1), filepath.Join(dir, "pkg/mod/example.com%2Fa@v1.0.0/libexample.com-a-b.so"); got != want {
+		t.Errorf("modLibPath() = %q; want %q", got, want)
+	}
+	if got := modLibPath(si.pkgDir, &packageInfo{ImportPath: "a/b"}); got != "" {
+		t.Errorf("modLibPath() = %q in GOPATH mode; want \"\"", got)
+	}
+	install := func() {
+		// go install replaces the .so file with a new file.
+		so := filepath.Join(si.pkgDir, soFileName("example.com/a/b"))
+		if err := os.MkdirAll(si.pkgDir, 0766); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(so); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(so, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if si.isModLibInstalled(v1) {
+		t.Error("v1 is installed before install")
+	}
+	install()
+	if err := si.linkModLib(v1); err != nil {
+		t.Fatal(err)
+	}
+	if !si.isModLibInstalled(v1) || si.isModLibInstalled(v2) {
+		t.Error("Only v1 must be installed")
+	}
+	install()
+	if err := si.linkModLib(v2); err != nil {
+		t.Fatal(err)
+	}
+	if si.isModLibInstalled(v1) || !si.isModLibInstalled(v2) {
+		t.Error("Only v2 must be installed")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	// d depends on b and c, and b and c depend on a.
+	pkgs := []*packageInfo{
+		{ImportPath: "a"},
+		{ImportPath: "b", Imports: []string{"a", "os"}},
+		{ImportPath: "c", Imports: []string{"a"}},
+		{ImportPath: "d", Imports: []string{"b", "c"}},
+	}
+	var mu sync.Mutex
+	built := make(map[string]bool)
+	running, maxRunning := 0, 0
+	var logs []string
+	b := &builder{
+		parallelism: 2,
+		build: func(ctx context.Context, pkg *packageInfo, stdout, stderr io.Writer) error {
+			mu.Lock()
+			for _, im := range pkg.Imports {
+				if im != "os" && !built[im] {
+					t.Errorf("%s is built before %s", pkg.ImportPath, im)
+				}
+			}
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			built[pkg.ImportPath] = true
+			mu.Unlock()
+			return nil
+		},
+		logger: func(s string) { logs = append(logs, s) },
+	}
+	if err := b.run(context.Background(), pkgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != len(pkgs) {
+		t.Errorf("Got %v; want all packages built", built)
+	}
+	if maxRunning != 2 {
+		t.Errorf("Got %d concurrent builds; want 2", maxRunning)
+	}
+	if len(logs) != 4 || !strings.HasPrefix(logs[0], "(1/4) installed ") || logs[3] != `(4/4) installed "d"` {
+		t.Errorf("Unexpected logs: %q", logs)
+	}
+}
+
+func TestBuilderFailure(t *testing.T) {
+	pkgs := []*packageInfo{
+		{ImportPath: "a"},
+		{ImportPath: "b"},
+		{ImportPath: "c", Imports: []string{"a"}},
+	}
+	var mu sync.Mutex
+	var built []string
+	b := &builder{
+		parallelism: 1,
+		build: func(ctx context.Context, pkg *packageInfo, stdout, stderr io.Writer) error {
+			mu.Lock()
+			defer mu.Unlock()
+			built = append(built, pkg.ImportPath)
+			if pkg.ImportPath == "a" {
+				return errors.New("broken")
+			}
+			return nil
+		},
+	}
+	err := b.run(context.Background(), pkgs)
+	if err == nil || !strings.Contains(err.Error(), `failed to install "a": broken`) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(built, []string{"a"}) {
+		t.Errorf("Got %v; want builds stopped after the failure of a", built)
+	}
+}
+
+func TestBuilderCancel(t *testing.T) {
+	pkgs := []*packageInfo{
+		{ImportPath: "a"},
+		{ImportPath: "b", Imports: []string{"a"}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var built []string
+	b := &builder{
+		build: func(ctx context.Context, pkg *packageInfo, stdout, stderr io.Writer) error {
+			built = append(built, pkg.ImportPath)
+			cancel()
+			return nil
+		},
+	}
+	if err := b.run(ctx, pkgs); err != context.Canceled {
+		t.Errorf("Got %v; want %v", err, context.Canceled)
+	}
+	if !reflect.DeepEqual(built, []string{"a"}) {
+		t.Errorf("Got %v; want only a built", built)
+	}
+}
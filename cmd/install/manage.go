@@ -0,0 +1,202 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// shlibnameExt is the extension of files which go install -linkshared writes next to archives.
+// The content of a .shlibname file is the name of the shared library which contains the package.
+const shlibnameExt = ".shlibname"
+
+// sessPkgPrefix is the prefix of packages built from cells. They are not managed by SOInstaller.
+const sessPkgPrefix = "github.com/yunabe/lgo/sess"
+
+// InstalledPackage is a package installed as a shared library in $LGOPATH/pkg.
+type InstalledPackage struct {
+	Path string
+	// Lib is the path to the shared library of the package.
+	Lib string
+	// Stale is true if the shared library is out of date with the current sources.
+	Stale       bool
+	StaleReason string
+}
+
+// installedLibs returns the map from packages installed in pkgDir to the paths to their shared libraries.
+func (si *SOInstaller) installedLibs() (map[string]string, error) {
+	libs := make(map[string]string)
+	err := filepath.Walk(si.pkgDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == si.pkgDir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, shlibnameExt) {
+			return nil
+		}
+		rel, err := filepath.Rel(si.pkgDir, strings.TrimSuffix(p, shlibnameExt))
+		if err != nil {
+			return err
+		}
+		pkg := filepath.ToSlash(rel)
+		if IsStdPkg(pkg) || strings.HasPrefix(pkg, sessPkgPrefix) {
+			// std packages are installed into libstd.so by lgo install.
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		libs[pkg] = filepath.Join(si.pkgDir, string(bytes.TrimSpace(b)))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packages in %s: %v", si.pkgDir, err)
+	}
+	return libs, nil
+}
+
+// listInstalled returns go list results of installed packages with the flags used to install them
+// so that Stale is computed against the shared libraries in pkgDir.
+func (si *SOInstaller) listInstalled(libs map[string]string) ([]*packageInfo, error) {
+	if len(libs) == 0 {
+		return nil, nil
+	}
+	var pkgs []string
+	for pkg := range libs {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return si.getPackageList(append([]string{"-e", "-buildmode=shared", "-linkshared", "-pkgdir", si.pkgDir}, pkgs...)...)
+}
+
+// ListInstalled returns packages installed in $LGOPATH/pkg sorted by their paths.
+func (si *SOInstaller) ListInstalled() ([]*InstalledPackage, error) {
+	pkgs, _, err := si.installedPackages()
+	return pkgs, err
+}
+
+// installedPackages returns packages installed in $LGOPATH/pkg and go list results of them.
+func (si *SOInstaller) installedPackages() ([]*InstalledPackage, []*packageInfo, error) {
+	libs, err := si.installedLibs()
+	if err != nil {
+		return nil, nil, err
+	}
+	infos, err := si.listInstalled(libs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pkgs []*InstalledPackage
+	for _, info := range infos {
+		pkg := &InstalledPackage{
+			Path:        info.ImportPath,
+			Lib:         libs[info.ImportPath],
+			Stale:       info.Stale,
+			StaleReason: info.StaleReason,
+		}
+		if info.Error != nil {
+			pkg.Stale, pkg.StaleReason = true, info.Error.Err
+		} else if si.modDir != "" && !si.isModLibInstalled(info) {
+			pkg.Stale, pkg.StaleReason = true, "module version changed to "+info.moduleKey()
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Path < pkgs[j].Path })
+	return pkgs, infos, nil
+}
+
+// Stale returns installed packages whose shared libraries are out of date and installed packages which depend on them.
+// The dependents are returned because their shared libraries are linked with the out-of-date libraries
+// even if go list does not report them as stale (e.g. only the module version of a dependency is changed).
+func (si *SOInstaller) Stale() ([]string, error) {
+	pkgs, infos, err := si.installedPackages()
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, pkg := range pkgs {
+		if pkg.Stale {
+			stale = append(stale, pkg.Path)
+		}
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	return dependents(infos, stale), nil
+}
+
+// dependents returns targets and packages in pkgs which depend on targets directly or indirectly.
+func dependents(pkgs []*packageInfo, targets []string) []string {
+	set := make(map[string]bool)
+	for _, t := range targets {
+		set[t] = true
+	}
+	for _, pkg := range pkgs {
+		for _, dep := range pkg.Deps {
+			if set[dep] {
+				set[pkg.ImportPath] = true
+				break
+			}
+		}
+	}
+	var ret []string
+	for pkg := range set {
+		ret = append(ret, pkg)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Remove removes installed packages in paths and installed packages which depend on them.
+// The dependents are removed because their shared libraries can not be loaded without the removed libraries.
+// It returns the removed packages. If dryRun is true, Remove only returns packages to be removed.
+func (si *SOInstaller) Remove(paths []string, dryRun bool) ([]string, error) {
+	libs, err := si.installedLibs()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		if p == core.SelfPkgPath {
+			return nil, fmt.Errorf("%q can not be removed because lgo depends on it", p)
+		}
+		if _, ok := libs[p]; !ok {
+			return nil, fmt.Errorf("%q is not installed", p)
+		}
+	}
+	infos, err := si.listInstalled(libs)
+	if err != nil {
+		return nil, err
+	}
+	removed := dependents(infos, paths)
+	if dryRun {
+		return removed, nil
+	}
+	for _, pkg := range removed {
+		files := []string{
+			filepath.Join(si.pkgDir, filepath.FromSlash(pkg)+".a"),
+			filepath.Join(si.pkgDir, filepath.FromSlash(pkg)+shlibnameExt),
+			libs[pkg],
+		}
+		// Links of the .so file with module versions.
+		mods, err := filepath.Glob(filepath.Join(si.pkgDir, modLibDir, "*", soFileName(pkg)))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, mods...)
+		for _, f := range files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		delete(si.cache, pkg)
+	}
+	return removed, nil
+}
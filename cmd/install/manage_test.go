@@ -0,0 +1,294 @@
+package install
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+func TestInstalledLibs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lgo-manage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	si := NewSOInstaller(dir)
+	if libs, err := si.installedLibs(); err != nil || len(libs) != 0 {
+		t.Errorf("installedLibs() = %v, %v; want an empty map for a missing pkg dir", libs, err)
+	}
+	files := map[string]string{
+		"fmt.shlibname":                "libstd.so",
+		"github.com/foo/bar.shlibname": "libgithub.com-foo-bar.so\n",
+		"github.com/foo/bar.a":         "",
+		"github.com/yunabe/lgo/sess7b2274696d65/exec1.shlibname": "libgithub.com-yunabe-lgo-sess7b2274696d65-exec1.so",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, "pkg", name)
+		if err := os.MkdirAll(filepath.Dir(p), 0766); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	libs, err := si.installedLibs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"github.com/foo/bar": filepath.Join(dir, "pkg", "libgithub.com-foo-bar.so"),
+	}
+	if !reflect.DeepEqual(libs, want) {
+		t.Errorf("Got %v; want %v", libs, want)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	pkgs := []*packageInfo{
+		{ImportPath: "a", Deps: []string{"fmt"}},
+		{ImportPath: "b", Deps: []string{"a", "fmt"}},
+		{ImportPath: "c", Deps: []string{"a", "b", "fmt"}},
+		{ImportPath: "d", Deps: []string{"fmt"}},
+	}
+	tests := []struct {
+		targets []string
+		want    []string
+	}{
+		{[]string{"a"}, []string{"a", "b", "c"}},
+		{[]string{"b"}, []string{"b", "c"}},
+		{[]string{"c", "d"}, []string{"c", "d"}},
+	}
+	for _, tc := range tests {
+		if got := dependents(pkgs, tc.targets); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("dependents(%v) = %v; want %v", tc.targets, got, tc.want)
+		}
+	}
+}
+
+// fixturePkgs are the sources of packages in the GOPATH of installFixture. fix/b depends on fix/a.
+var fixturePkgs = map[string]string{
+	"fix/a": "package a\n\nfunc A() int { return 1 }\n",
+	"fix/b": "package b\n\nimport \"fix/a\"\n\nfunc B() int { return a.A() + 1 }\n",
+	"fix/c": "package c\n\nfunc C() int { return 3 }\n",
+}
+
+func writeFixturePkg(t *testing.T, gopath, pkg, src string) {
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(pkg))
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(dir)+".go"), []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setenv(t *testing.T, key, value string) (restore func()) {
+	orig, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, orig)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// installFixture installs fixturePkgs as shared libraries into a temporary LGOPATH.
+// The go command resolves fixturePkgs in a temporary GOPATH until cleanup is called.
+func installFixture(t *testing.T) (si *SOInstaller, gopath string, cleanup func()) {
+	if testing.Short() {
+		t.Skip("Installing shared libraries is slow")
+	}
+	dir, err := ioutil.TempDir("", "lgo-manage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath = filepath.Join(dir, "gopath")
+	restoreGopath := setenv(t, "GOPATH", gopath)
+	restoreMod := setenv(t, "GO111MODULE", "off")
+	cleanup = func() {
+		restoreMod()
+		restoreGopath()
+		os.RemoveAll(dir)
+	}
+	for pkg, src := range fixturePkgs {
+		writeFixturePkg(t, gopath, pkg, src)
+	}
+	si = NewSOInstaller(filepath.Join(dir, "lgo"))
+	// lgo install installs std packages into libstd.so before other packages.
+	// Only runtime, on which fixturePkgs depend, is installed here to save time.
+	cmd := GoCommand(context.Background(), "", "install", "-buildmode=shared", "-linkshared", "-pkgdir", si.pkgDir, "runtime")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		t.Fatalf("Failed to install runtime: %v\n%s", err, out)
+	}
+	if err := si.Install("fix/..."); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return si, gopath, cleanup
+}
+
+func installedPaths(t *testing.T, si *SOInstaller) (paths, stale []string) {
+	pkgs, err := si.ListInstalled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range pkgs {
+		paths = append(paths, pkg.Path)
+		if pkg.Stale {
+			stale = append(stale, pkg.Path)
+		}
+	}
+	return paths, stale
+}
+
+func TestListInstalled(t *testing.T) {
+	si, gopath, cleanup := installFixture(t)
+	defer cleanup()
+	pkgs, err := si.ListInstalled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []InstalledPackage
+	for _, pkg := range pkgs {
+		got = append(got, *pkg)
+	}
+	want := []InstalledPackage{
+		{Path: "fix/a", Lib: filepath.Join(si.pkgDir, "libfix-a.so")},
+		{Path: "fix/b", Lib: filepath.Join(si.pkgDir, "libfix-b.so")},
+		{Path: "fix/c", Lib: filepath.Join(si.pkgDir, "libfix-c.so")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v; want %+v", got, want)
+	}
+
+	writeFixturePkg(t, gopath, "fix/a", "package a\n\nfunc A() int { return 10 }\n")
+	paths, stale := installedPaths(t, si)
+	if want := []string{"fix/a", "fix/b", "fix/c"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Got %v; want %v", paths, want)
+	}
+	// fix/b is stale because it depends on fix/a.
+	if want := []string{"fix/a", "fix/b"}; !reflect.DeepEqual(stale, want) {
+		t.Errorf("Stale packages are %v; want %v", stale, want)
+	}
+
+	// The source of an installed package is removed.
+	if err := os.RemoveAll(filepath.Join(gopath, "src", "fix", "c")); err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err = si.ListInstalled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := pkgs[len(pkgs)-1]; c.Path != "fix/c" || !c.Stale || c.StaleReason == "" {
+		t.Errorf("Got %+v; want fix/c with a stale reason", c)
+	}
+}
+
+// TestUpdateStale tests the flow of lgo installpkg -update, which reinstalls stale packages.
+func TestUpdateStale(t *testing.T) {
+	si, gopath, cleanup := installFixture(t)
+	defer cleanup()
+	stale, err := si.Stale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("Stale() = %v just after Install; want none", stale)
+	}
+	libB := filepath.Join(si.pkgDir, "libfix-b.so")
+	before, err := os.Stat(libB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFixturePkg(t, gopath, "fix/a", "package a\n\nfunc A() int { return 10 }\n")
+	stale, err = si.Stale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fix/a", "fix/b"}; !reflect.DeepEqual(stale, want) {
+		t.Errorf("Stale() = %v; want %v", stale, want)
+	}
+	if err := si.Install(stale...); err != nil {
+		t.Fatal(err)
+	}
+	if _, stale := installedPaths(t, si); len(stale) != 0 {
+		t.Errorf("%v are still stale after they are reinstalled", stale)
+	}
+	after, err := os.Stat(libB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) && before.ModTime().Equal(after.ModTime()) {
+		t.Errorf("%s, which depends on a stale package, is not rebuilt", libB)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	si, _, cleanup := installFixture(t)
+	defer cleanup()
+	files := func(pkg string) []string {
+		return []string{
+			filepath.Join(si.pkgDir, filepath.FromSlash(pkg)+".a"),
+			filepath.Join(si.pkgDir, filepath.FromSlash(pkg)+shlibnameExt),
+			filepath.Join(si.pkgDir, soFileName(pkg)),
+		}
+	}
+	for _, pkg := range []string{"fix/a", "fix/b", "fix/c"} {
+		for _, f := range files(pkg) {
+			if _, err := os.Stat(f); err != nil {
+				t.Fatalf("%s is not installed: %v", f, err)
+			}
+		}
+	}
+
+	for _, pkg := range []string{"fix/nosuchpkg", core.SelfPkgPath} {
+		if _, err := si.Remove([]string{pkg}, false); err == nil {
+			t.Errorf("Remove(%q) succeeded unexpectedly", pkg)
+		}
+	}
+
+	removed, err := si.Remove([]string{"fix/a"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fix/a", "fix/b"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("Remove(dryRun) = %v; want %v", removed, want)
+	}
+	if paths, _ := installedPaths(t, si); len(paths) != 3 {
+		t.Errorf("Remove with dryRun removed packages: %v are installed", paths)
+	}
+
+	removed, err = si.Remove([]string{"fix/a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fix/a", "fix/b"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("Remove() = %v; want %v", removed, want)
+	}
+	for _, pkg := range removed {
+		for _, f := range files(pkg) {
+			if _, err := os.Stat(f); !os.IsNotExist(err) {
+				t.Errorf("%s is not removed: %v", f, err)
+			}
+		}
+	}
+	for _, f := range files("fix/c") {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("%s is removed unexpectedly: %v", f, err)
+		}
+	}
+	if paths, _ := installedPaths(t, si); !reflect.DeepEqual(paths, []string{"fix/c"}) {
+		t.Errorf("Got %v after Remove; want [fix/c]", paths)
+	}
+}
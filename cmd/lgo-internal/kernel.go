@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"go/scanner"
+	"go/types"
 	"io"
 	"log"
 	"math/rand"
@@ -25,6 +28,7 @@ import (
 type handlers struct {
 	runner    *runner.LgoRunner
 	execCount int
+	comms     *jupyterCommManager
 }
 
 func (*handlers) HandleKernelInfo() scaffold.KernelInfo {
@@ -73,6 +77,32 @@ func pipeOutput(send func(string), file **os.File, done chan<- struct{}) (close
 	return close, nil
 }
 
+// redirectOutputs replaces os.Stdout and os.Stderr with pipes whose outputs are sent with stream.
+// restore restores os.Stdout and os.Stderr and waits until all outputs are sent.
+func redirectOutputs(stream func(name, text string)) (restore func(), err error) {
+	done := make(chan struct{})
+	soClose, err := pipeOutput(func(msg string) {
+		stream("stdout", msg)
+	}, &os.Stdout, done)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout pipe: %v", err)
+	}
+	seClose, err := pipeOutput(func(msg string) {
+		stream("stderr", msg)
+	}, &os.Stderr, done)
+	if err != nil {
+		soClose()
+		<-done
+		return nil, fmt.Errorf("failed to open stderr pipe: %v", err)
+	}
+	return func() {
+		soClose()
+		seClose()
+		<-done
+		<-done
+	}, nil
+}
+
 type jupyterDisplayer func(data *scaffold.DisplayData, update bool)
 
 func init() {
@@ -113,6 +143,14 @@ func (d jupyterDisplayer) Raw(contentType string, v interface{}, id *string) err
 	return nil
 }
 
+func (d jupyterDisplayer) DisplayBundle(bundle map[string]interface{}) error {
+	if _, err := json.Marshal(bundle); err != nil {
+		return err
+	}
+	d.display(&scaffold.DisplayData{Data: bundle}, nil)
+	return nil
+}
+
 func (d jupyterDisplayer) displayString(contentType, content string, id *string) {
 	d.display(&scaffold.DisplayData{
 		Data: map[string]interface{}{
@@ -142,54 +180,177 @@ func (d jupyterDisplayer) GIF(b []byte, id *string)      { d.displayBytes("image
 func (d jupyterDisplayer) PDF(b []byte, id *string)      { d.displayBytes("application/pdf", b, id) }
 func (d jupyterDisplayer) Text(s string, id *string)     { d.displayString("text/plain", s, id) }
 
-func (h *handlers) HandleExecuteRequest(ctx context.Context, r *scaffold.ExecuteRequest, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool)) *scaffold.ExecuteResult {
-	h.execCount++
-	rDone := make(chan struct{})
-	soClose, err := pipeOutput(func(msg string) {
-		stream("stdout", msg)
-	}, &os.Stdout, rDone)
+// jupyterResultDisplayer is a jupyterDisplayer that also keeps the value of the last expression.
+type jupyterResultDisplayer struct {
+	jupyterDisplayer
+	// result is sent with execute_result after all outputs of the execution are sent.
+	result *scaffold.DisplayData
+}
+
+func (d *jupyterResultDisplayer) DisplayResult(bundle map[string]interface{}) {
+	d.result = &scaffold.DisplayData{Data: bundle}
+}
+
+// jupyterInputReader reads inputs from users with input_request.
+type jupyterInputReader func(ctx context.Context, prompt string, password bool) (string, error)
+
+func (r jupyterInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	return r(ctx, prompt, password)
+}
+
+// jupyterCommManager opens comms with the frontend.
+type jupyterCommManager struct {
+	server *scaffold.Server
+	// ctx is passed to callbacks of comms.
+	ctx context.Context
+}
+
+// runCallback runs a callback from the frontend as lgo code so that goroutines in it are managed by core.
+// Outputs and errors of callbacks are sent to the frontend with the comm message as the parent.
+func (m *jupyterCommManager) runCallback(f func()) {
+	restore, err := redirectOutputs(m.server.CommStream)
 	if err != nil {
-		glog.Errorf("Failed to open stdout pipe: %v", err)
-		return &scaffold.ExecuteResult{
-			Status:         "error",
-			ExecutionCount: h.execCount,
-		}
+		glog.Errorf("Failed to run a callback of a comm: %v", err)
+		return
 	}
-	seClose, err := pipeOutput(func(msg string) {
-		stream("stderr", msg)
-	}, &os.Stderr, rDone)
+	lgoCtx := core.LgoContext{Context: m.ctx, Display: jupyterDisplayer(m.server.CommDisplayData), Comm: m}
+	err = core.ExecLgoEntryPoint(lgoCtx, f)
+	restore()
 	if err != nil {
-		glog.Errorf("Failed to open stderr pipe: %v", err)
+		var buf bytes.Buffer
+		runner.PrintError(&buf, err)
+		m.server.CommError(errorName(err), errorValue(err), splitTraceback(buf.String()))
+	}
+}
+
+func (m *jupyterCommManager) wrapComm(c *scaffold.Comm) core.Comm {
+	return &jupyterComm{c, m}
+}
+
+func (m *jupyterCommManager) Open(targetName string, data interface{}, metadata map[string]interface{}) (core.Comm, error) {
+	c, err := m.server.OpenComm(targetName, data, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return m.wrapComm(c), nil
+}
+
+func (m *jupyterCommManager) RegisterTarget(targetName string, onOpen func(c core.Comm, data map[string]interface{})) {
+	m.server.RegisterCommTarget(targetName, func(c *scaffold.Comm, data map[string]interface{}) {
+		m.runCallback(func() {
+			onOpen(m.wrapComm(c), data)
+		})
+	})
+}
+
+type jupyterComm struct {
+	*scaffold.Comm
+	manager *jupyterCommManager
+}
+
+func (c *jupyterComm) OnMsg(f func(data map[string]interface{})) {
+	if f == nil {
+		c.Comm.OnMsg(nil)
+		return
+	}
+	c.Comm.OnMsg(func(data map[string]interface{}) {
+		c.manager.runCallback(func() { f(data) })
+	})
+}
+
+func (c *jupyterComm) OnClose(f func(data map[string]interface{})) {
+	if f == nil {
+		c.Comm.OnClose(nil)
+		return
+	}
+	c.Comm.OnClose(func(data map[string]interface{}) {
+		c.manager.runCallback(func() { f(data) })
+	})
+}
+
+// errorName returns ename of the error reply of err.
+func errorName(err error) string {
+	switch err.(type) {
+	case scanner.ErrorList, converter.ErrorList, types.Error:
+		return "CompileError"
+	case *core.PanicError:
+		return "panic"
+	}
+	return "Error"
+}
+
+// errorValue returns evalue of the error reply of err.
+func errorValue(err error) string {
+	if p, ok := err.(*core.PanicError); ok {
+		return p.Value
+	}
+	return err.Error()
+}
+
+// splitTraceback splits traceback into lines of traceback in error replies.
+func splitTraceback(traceback string) []string {
+	return strings.Split(strings.TrimSuffix(traceback, "\n"), "\n")
+}
+
+// errorResult returns an execute_reply of an error. Lines of traceback are shown as the error output in the client.
+func errorResult(count int, ename, evalue, traceback string) *scaffold.ExecuteResult {
+	return &scaffold.ExecuteResult{
+		Status:         "error",
+		ExecutionCount: count,
+		Ename:          ename,
+		Evalue:         evalue,
+		Traceback:      splitTraceback(traceback),
+	}
+}
+
+func (h *handlers) HandleExecuteRequest(ctx context.Context, r *scaffold.ExecuteRequest, stream func(string, string), displayData func(data *scaffold.DisplayData, update bool), readInput func(ctx context.Context, prompt string, password bool) (string, error), executeResult func(count int, data *scaffold.DisplayData)) *scaffold.ExecuteResult {
+	h.execCount++
+	restoreOutputs, err := redirectOutputs(stream)
+	if err != nil {
+		glog.Error(err)
 		return &scaffold.ExecuteResult{
 			Status:         "error",
 			ExecutionCount: h.execCount,
 		}
 	}
+	display := &jupyterResultDisplayer{jupyterDisplayer: jupyterDisplayer(displayData)}
 	lgoCtx := core.LgoContext{
-		Context: ctx, Display: jupyterDisplayer(displayData),
+		Context: ctx, Display: display,
+	}
+	if h.comms != nil {
+		lgoCtx.Comm = h.comms
 	}
+	if r.AllowStdin {
+		lgoCtx.Input = jupyterInputReader(readInput)
+		restoreStdin, err := runner.RedirectStdin(ctx, lgoCtx.Input)
+		if err != nil {
+			glog.Errorf("Failed to redirect stdin: %v", err)
+		} else {
+			defer restoreStdin()
+		}
+	}
+	var failure *scaffold.ExecuteResult
 	func() {
 		defer func() {
 			p := recover()
 			if p != nil {
-				// The return value of debug.Stack() ends with \n.
-				fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", p, debug.Stack())
+				failure = errorResult(h.execCount, "panic", fmt.Sprint(p), fmt.Sprintf("panic: %v\n\n%s", p, runner.FormatStack(debug.Stack())))
 			}
 		}()
-		// Print the err in the notebook
-		if err = h.runner.Run(lgoCtx, r.Code); err != nil {
-			runner.PrintError(os.Stderr, err)
+		h.runner.SetCellLabel(fmt.Sprintf("In[%d]", h.execCount))
+		// The err is shown in the notebook as an error output.
+		if err = runCode(lgoCtx, h.runner, r.Code); err != nil {
+			var buf bytes.Buffer
+			runner.PrintError(&buf, err)
+			failure = errorResult(h.execCount, errorName(err), errorValue(err), buf.String())
 		}
 	}()
-	soClose()
-	seClose()
-	<-rDone
-	<-rDone
-	if err != nil {
-		return &scaffold.ExecuteResult{
-			Status:         "error",
-			ExecutionCount: h.execCount,
-		}
+	restoreOutputs()
+	if display.result != nil {
+		executeResult(h.execCount, display.result)
+	}
+	if failure != nil {
+		return failure
 	}
 	return &scaffold.ExecuteResult{
 		Status:         "ok",
@@ -268,6 +429,19 @@ func (*handlers) HandleGoFmt(req *scaffold.GoFmtRequest) (*scaffold.GoFmtReply,
 	}, nil
 }
 
+func (h *handlers) HandleVariables(ctx context.Context, req *scaffold.VariablesRequest) (*scaffold.VariablesReply, error) {
+	// Outputs of the code to read variables are not sent to the frontend.
+	infos, err := h.runner.Variables(core.LgoContext{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+	reply := &scaffold.VariablesReply{Status: "ok"}
+	for _, info := range infos {
+		reply.Variables = append(reply.Variables, scaffold.Variable(info))
+	}
+	return reply, nil
+}
+
 // kernelLogWriter forwards messages to the current os.Stderr, which is change on every execution.
 type kernelLogWriter struct{}
 
@@ -291,15 +465,17 @@ func (*glogLogger) Fatal(msg string) {
 	glog.FatalDepth(2, msg)
 }
 
-func kernelMain(lgopath string, sessID *runner.SessionID) {
+func kernelMain(lgopath string, sessID *runner.SessionID, rn *runner.LgoRunner) {
 	log.SetOutput(kernelLogWriter{})
 	scaffold.SetLogger(&glogLogger{})
-	server, err := scaffold.NewServer(context.Background(), *connectionFile, &handlers{
-		runner: runner.NewLgoRunner(lgopath, sessID),
-	})
+	h := &handlers{
+		runner: rn,
+	}
+	server, err := scaffold.NewServer(context.Background(), *connectionFile, h)
 	if err != nil {
 		glog.Fatalf("Failed to create a server: %v", err)
 	}
+	h.comms = &jupyterCommManager{server: server, ctx: server.Context()}
 
 	// Start the server loop
 	server.Loop()
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/converter"
+	"github.com/yunabe/lgo/core"
+)
+
+func TestErrorResult(t *testing.T) {
+	err := converter.ErrorList{errors.New("1:1: undeclared name: x"), errors.New("2:1: undeclared name: y")}
+	if name := errorName(err); name != "CompileError" {
+		t.Errorf("errorName(%v) = %q; want CompileError", err, name)
+	}
+	if name := errorName(errors.New("main routine failed")); name != "Error" {
+		t.Errorf("Got %q; want Error", name)
+	}
+	if name := errorName(&core.PanicError{Value: "boom", Message: "main routine failed"}); name != "panic" {
+		t.Errorf("Got %q; want panic", name)
+	}
+	r := errorResult(3, "CompileError", err.Error(), "1:1: undeclared name: x\n2:1: undeclared name: y\n")
+	want := []string{"1:1: undeclared name: x", "2:1: undeclared name: y"}
+	if r.Status != "error" || r.ExecutionCount != 3 || !reflect.DeepEqual(r.Traceback, want) {
+		t.Errorf("Unexpected result: %#v", r)
+	}
+}
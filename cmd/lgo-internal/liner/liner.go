@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/peterh/liner"
+	"github.com/yunabe/lgo/magic"
 	"github.com/yunabe/lgo/parser"
 )
 
@@ -91,7 +92,81 @@ func continueForMethods(b *parser.LGOBlock, lines []string) (bool, int) {
 	return true, 0
 }
 
+// isPackageFileMarker returns true if line separates files in the body of %%package (e.g. `-- geom.go --`).
+func isPackageFileMarker(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) >= len("-- x --") && strings.HasPrefix(line, "-- ") && strings.HasSuffix(line, " --")
+}
+
+// continueGoFile returns true if lines are the incomplete source of a Go file.
+func continueGoFile(lines []string) (bool, int) {
+	dropped := dropEmptyLine(lines)
+	if len(dropped) == 0 {
+		return true, 0
+	}
+	src := strings.Join(dropped, "\n")
+	_, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if errs, ok := err.(scanner.ErrorList); ok && isUnexpectedEOF(errs, dropped) {
+		return true, nextIndent(src)
+	}
+	return false, 0
+}
+
+// continueCellMagic returns true if a cell magic with body is not finished.
+// The body of a cell magic (the source of a package in %%package) may contain empty lines.
+// Thus, a cell magic ends with two empty lines after the last file of the body is complete.
+func continueCellMagic(body []string) (bool, int) {
+	start := 0
+	for i, line := range body {
+		if isPackageFileMarker(line) {
+			start = i + 1
+		}
+	}
+	if cont, indent := continueGoFile(body[start:]); cont {
+		return true, indent
+	}
+	n := len(body)
+	return n < 2 || strings.TrimSpace(body[n-1]) != "" || strings.TrimSpace(body[n-2]) != "", 0
+}
+
+// continueLineMagic returns true if the arguments of a line magic are incomplete Go code
+// (e.g. `%time for i := 0; i < n; i++ {`). Otherwise, a line magic ends at the end of the line.
+func continueLineMagic(args string) (bool, int) {
+	sc := &scanner.Scanner{}
+	var unterminated bool
+	sc.Init(token.NewFileSet().AddFile("", -1, len(args)), []byte(args), func(_ token.Position, msg string) {
+		if msg == "raw string literal not terminated" || msg == "comment not terminated" {
+			unterminated = true
+		}
+	}, 0)
+	var depth int
+	for {
+		_, tok, _ := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	if unterminated || depth > 0 {
+		return true, nextIndent(args)
+	}
+	return false, 0
+}
+
 func continueLine(lines []string) (bool, int) {
+	if cmd, ok := magic.Parse(strings.Join(lines, "\n")); ok {
+		if cmd.Cell {
+			return continueCellMagic(strings.Split(cmd.Body, "\n"))
+		}
+		return continueLineMagic(cmd.Args)
+	}
 	dropped := dropEmptyLine(lines)
 	src := strings.Join(dropped, "\n")
 	b, err := parseLesserGoString(src)
@@ -108,6 +108,51 @@ func TestContinueLine(t *testing.T) {
 	}, {
 		lines:  []string{"func (s) f(){}", ""},
 		expect: false,
+	}, {
+		// A line magic ends at the end of the line unless its argument has unclosed brackets.
+		lines:  []string{"%save_session /tmp/"},
+		expect: false,
+	}, {
+		lines:  []string{"%time for i := 0; i < n; i++ {"},
+		expect: true,
+		indent: 1,
+	}, {
+		lines:  []string{"%time for i := 0; i < n; i++ {", "}"},
+		expect: false,
+	}, {
+		lines:  []string{"%time f(`a"},
+		expect: true,
+	}, {
+		lines:  []string{"%%package mylib/geom"},
+		expect: true,
+	}, {
+		lines:  []string{"%%package mylib/geom", "package geom", "func f() {"},
+		expect: true,
+		indent: 1,
+	}, {
+		lines:  []string{"%%package mylib/geom", "package geom", "func f() {", "", ""},
+		expect: true,
+		indent: 1,
+	}, {
+		// Empty lines in the body do not end the cell.
+		lines:  []string{"%%package mylib/geom", "package geom", ""},
+		expect: true,
+	}, {
+		lines:  []string{"%%package mylib/geom", "package geom", "", "func f() {}", "", "func g() {}", ""},
+		expect: true,
+	}, {
+		lines:  []string{"%%package mylib/geom", "package geom", "", "func f() {}", "", ""},
+		expect: false,
+	}, {
+		lines:  []string{"%%package mylib/geom", "package geom", "-- b.go --", "", ""},
+		expect: true,
+	}, {
+		lines:  []string{"%%package mylib/geom", "package geom", "-- b.go --", "package geom", "", ""},
+		expect: false,
+	}, {
+		// The cell ends with a syntax error to report it.
+		lines:  []string{"%%package mylib/geom", "package geom", "func f() {)", "", ""},
+		expect: false,
 	},
 	}
 
@@ -8,7 +8,6 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path"
 	"path/filepath"
@@ -17,10 +16,12 @@ import (
 	"syscall"
 
 	"github.com/golang/glog"
+	"github.com/yunabe/lgo/cmd/install"
 	"github.com/yunabe/lgo/cmd/lgo-internal/liner"
 	"github.com/yunabe/lgo/cmd/runner"
 	"github.com/yunabe/lgo/converter"
 	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/magic"
 	"golang.org/x/sys/unix"
 )
 
@@ -28,14 +29,33 @@ var (
 	subcomandFlag  = flag.String("subcommand", "", "lgo subcommand")
 	sessIDFlag     = flag.String("sess_id", "", "lgo session id")
 	connectionFile = flag.String("connection_file", "", "jupyter kernel connection file path. This flag is used with kernel subcommand")
+	goModFlag      = flag.String("gomod", "", "If set, lgo runs in module mode and lgo code uses requirements in this go.mod file")
+	workerFlag     = flag.Bool("worker", false, "If true, lgo code is executed in a worker process so that the kernel survives crashes of lgo code")
+	executorFlag   = flag.String("executor", runner.DefaultExecutorName, "The executor to build and run lgo code: "+strings.Join(runner.ExecutorNames(), ", "))
 )
 
 type printer struct{}
 
+// Println renders the values of the last expression with registered renderers.
+// The result is displayed as the result of the execution if the current Display supports it.
+// Otherwise, the text representation is printed to stdout.
 func (*printer) Println(args ...interface{}) {
-	for _, arg := range args {
-		fmt.Println(arg)
+	var bundle map[string]interface{}
+	if len(args) == 1 {
+		bundle = core.RenderMimeBundle(args[0])
+	} else {
+		// Rich representations are not used for multiple values.
+		var texts []string
+		for _, arg := range args {
+			texts = append(texts, fmt.Sprint(core.RenderMimeBundle(arg)["text/plain"]))
+		}
+		bundle = map[string]interface{}{"text/plain": strings.Join(texts, "\n")}
+	}
+	if d, ok := core.GetExecContext().Display.(core.ResultDisplayer); ok {
+		d.DisplayResult(bundle)
+		return
 	}
+	fmt.Println(bundle["text/plain"])
 }
 
 func createRunContext(parent context.Context, sigint <-chan os.Signal) (ctx context.Context, cancel func()) {
@@ -87,8 +107,8 @@ func fromFiles(ctx context.Context, rn *runner.LgoRunner) {
 			glog.Errorf("Failed to read %s: %v", path, err)
 			return
 		}
-		if err = rn.Run(core.LgoContext{Context: ctx}, string(src)); err != nil {
-			glog.Error(err)
+		if err = runCode(core.LgoContext{Context: ctx}, rn, string(src)); err != nil {
+			runner.PrintError(os.Stderr, err)
 			return
 		}
 	}
@@ -146,24 +166,73 @@ loop:
 				p := recover()
 				if p != nil {
 					// The return value of debug.Stack() ends with \n.
-					fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", p, debug.Stack())
+					fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", p, runner.FormatStack(debug.Stack()))
 				}
 			}()
-			if err := rn.Run(core.LgoContext{Context: runCtx}, src); err != nil {
-				glog.Error(err)
+			if err := runCode(core.LgoContext{Context: runCtx}, rn, src); err != nil {
+				runner.PrintError(os.Stderr, err)
 			}
 		}()
 	}
 }
 
-func installPkgArchive(pkgDir string, paths []string) error {
-	return exec.Command("go", append([]string{"install", "-linkshared", "-pkgdir", pkgDir}, paths...)...).Run()
+// runCode runs src with rn. If src is a magic command (e.g. %restart_worker), runCode runs the command instead.
+func runCode(ctx core.LgoContext, rn *runner.LgoRunner, src string) error {
+	if cmd, ok := magic.Parse(src); ok {
+		return rn.RunMagic(ctx, cmd)
+	}
+	return rn.Run(ctx, src)
 }
 
-type packageArchiveInstaller struct{ pkgDir string }
+func installPkgArchive(pkgDir, modDir string, paths []string) error {
+	args := []string{"install", "-pkgdir", pkgDir}
+	if *executorFlag == "shared" {
+		// Archives must be built with -linkshared to be linked to shared libraries.
+		args = append(args, "-linkshared")
+	}
+	cmd := install.GoCommand(context.Background(), modDir, append(args, paths...)...)
+	if modDir != "" {
+		cmd.Env = append(cmd.Env, "GOFLAGS=-mod=mod")
+	}
+	return cmd.Run()
+}
+
+type packageArchiveInstaller struct{ pkgDir, modDir string }
 
 func (in *packageArchiveInstaller) Install(pkgs []string) error {
-	return installPkgArchive(in.pkgDir, pkgs)
+	return installPkgArchive(in.pkgDir, in.modDir, pkgs)
+}
+
+func newRunner(lgopath string, sessID *runner.SessionID) *runner.LgoRunner {
+	rn := runner.NewLgoRunner(lgopath, sessID)
+	if err := rn.UseExecutor(*executorFlag); err != nil {
+		glog.Fatalf("Failed to use --executor=%s: %v", *executorFlag, err)
+	}
+	if *goModFlag != "" {
+		if err := rn.UseModule(*goModFlag); err != nil {
+			glog.Fatalf("Failed to use --gomod=%s: %v", *goModFlag, err)
+		}
+	}
+	if *workerFlag && *subcomandFlag != "worker" {
+		exe, err := os.Executable()
+		if err != nil {
+			glog.Fatalf("Failed to get the path of lgo-internal: %v", err)
+		}
+		if err := rn.UseWorker(exe, "--subcommand=worker", "--sess_id="+*sessIDFlag, "--executor="+rn.ExecutorName()); err != nil {
+			glog.Fatalf("Failed to start a worker process: %v", err)
+		}
+	}
+	return rn
+}
+
+// workerMain serves requests from the kernel in a worker process.
+func workerMain(rn *runner.LgoRunner) {
+	// Jupyter sends SIGINT to the process group of the kernel to interrupt it.
+	// The worker ignores SIGINT because cancellation is notified from the kernel.
+	signal.Ignore(syscall.SIGINT)
+	if err := rn.ServeWorker(createProcessContext(false)); err != nil {
+		glog.Fatal(err)
+	}
 }
 
 func main() {
@@ -188,26 +257,33 @@ func main() {
 		glog.Fatalf("Failed to get the absolute path of LGOPATH: %v", err)
 	}
 	core.RegisterLgoPrinter(&printer{})
+	rn := newRunner(lgopath, &sessID)
 	pkgDir := path.Join(lgopath, "pkg")
+	modDir := rn.ModuleDir()
 	// Fom go1.10, go install does not install .a files into GOPATH.
 	// We need to read package information from .a files installed in LGOPATH instead.
 	converter.SetLGOImporter(importer.For("gc", func(path string) (io.ReadCloser, error) {
 		abs := filepath.Join(lgopath, "pkg", path+".a")
 		if _, err := os.Stat(abs); os.IsNotExist(err) {
-			installPkgArchive(pkgDir, []string{path})
+			installPkgArchive(pkgDir, modDir, []string{path})
 		}
 		return os.Open(abs)
 	}))
 	converter.SetPackageArchiveInstaller(&packageArchiveInstaller{
 		pkgDir: pkgDir,
+		modDir: modDir,
 	})
 
+	if *subcomandFlag == "worker" {
+		// Files of the session are cleaned by the kernel.
+		workerMain(rn)
+		exitProcess()
+	}
 	if *subcomandFlag == "kernel" {
-		kernelMain(lgopath, &sessID)
+		kernelMain(lgopath, &sessID, rn)
 		exitProcess()
 	}
 
-	rn := runner.NewLgoRunner(lgopath, &sessID)
 	useFiles := len(flag.Args()) > 0
 	ctx := createProcessContext(useFiles)
 
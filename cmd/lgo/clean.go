@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yunabe/lgo/cmd/runner"
+)
+
+// formatSize formats a size in bytes in a human-readable form.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// cleanMain removes files of sessions whose owner processes are gone (e.g. crashed kernels).
+func cleanMain() {
+	fs := flag.NewFlagSet("lgo clean", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "If true, lgo clean only reports sessions to be removed.")
+	olderThan := fs.Duration("older-than", 0, "If set, only sessions started before this duration ago (e.g. 24h) are removed.")
+	fs.Parse(os.Args[2:])
+
+	lgopath := os.Getenv("LGOPATH")
+	if lgopath == "" {
+		log.Fatal("LGOPATH is empty")
+	}
+	lgopath, err := filepath.Abs(lgopath)
+	if err != nil {
+		log.Fatalf("Failed to get the absolute path of LGOPATH: %v", err)
+	}
+	sessions, err := runner.ListSessions(lgopath)
+	if err != nil {
+		log.Fatalf("Failed to list sessions: %v", err)
+	}
+	now := time.Now()
+	var total int64
+	var count int
+	for _, s := range sessions {
+		if s.ID.IsAlive() {
+			continue
+		}
+		start := s.ID.StartTime()
+		if now.Sub(start) < *olderThan {
+			continue
+		}
+		count++
+		total += s.Size
+		owner := "unknown process"
+		if s.ID.PID > 0 {
+			owner = fmt.Sprintf("process %d", s.ID.PID)
+		}
+		fmt.Printf("%s (started at %s by %s): %s\n", s.ID.Marshal(), start.Format(time.RFC3339), owner, formatSize(s.Size))
+		if *dryRun {
+			continue
+		}
+		if err := s.Remove(); err != nil {
+			log.Printf("Failed to remove files of %s: %v", s.ID.Marshal(), err)
+		}
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d sessions (%s)\n", verb, count, formatSize(total))
+}
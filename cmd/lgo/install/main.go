@@ -41,7 +41,8 @@ func recordStderr(logPath string) error {
 	return tee.Start()
 }
 
-func checkEnv(logFileName string) string {
+// lgoPath returns the absolute path of $LGOPATH.
+func lgoPath() string {
 	if runtime.GOOS != "linux" {
 		log.Fatal("lgo only supports Linux")
 	}
@@ -53,6 +54,11 @@ func checkEnv(logFileName string) string {
 	if err != nil {
 		log.Fatalf("Failed to get the abspath of LGOPATH: %v", err)
 	}
+	return root
+}
+
+func checkEnv(logFileName string) string {
+	root := lgoPath()
 	if err := os.MkdirAll(root, 0766); err != nil {
 		log.Fatalf("Failed to create a directory on $LGOPATH: %v", err)
 	}
@@ -66,6 +72,9 @@ func checkEnv(logFileName string) string {
 func InstallMain() {
 	fSet := flag.NewFlagSet(os.Args[0]+" install", flag.ExitOnError)
 	cleanBeforeInstall := fSet.Bool("clean", false, "If true, clean existing files before install")
+	executor := fSet.String("executor", "shared", "The executor to build and run Go code. "+
+		"shared: Load shared libraries built with -buildmode=shared. "+
+		"plugin: Load plugins built with -buildmode=plugin. Use this with go1.10 or later")
 	// Ignore errors; fSet is set for ExitOnError.
 	fSet.Parse(os.Args[2:])
 
@@ -90,6 +99,19 @@ func InstallMain() {
 		log.Fatalf("Failed to clean pkg dir: %v", err)
 	}
 
+	switch *executor {
+	case "shared":
+		installShared(binDir, pkgDir)
+	case "plugin":
+		installPlugin(binDir, pkgDir)
+	default:
+		log.Fatalf("Unknown executor: %q", *executor)
+	}
+	log.Printf("lgo was installed in %s successfully", root)
+}
+
+// installShared installs libstd.so, the core library and lgo-internal for the shared executor.
+func installShared(binDir, pkgDir string) {
 	log.Print("Building libstd.so")
 	// Note: From go1.10, libstd.so should be installed with -linkshared to avoid recompiling std libraries.
 	//       go1.8 and go1.9 work regardless of the existence of -linkshared here.
@@ -120,11 +142,36 @@ func InstallMain() {
 	if err := cmd.Run(); err != nil {
 		log.Fatalf("Failed to build lgo-internal: %v", err)
 	}
-	log.Printf("lgo was installed in %s successfully", root)
+}
+
+// installPlugin installs lgo-internal for the plugin executor.
+// Plugins and lgo-internal are built with the standard build cache, not with pkgDir,
+// so that packages linked to both of them are built in the same way.
+func installPlugin(binDir, pkgDir string) {
+	log.Print("Installing the archive of lgo core package")
+	// The archive in pkgDir is used to type-check lgo code.
+	cmd := exec.Command("go", "install", "-pkgdir", pkgDir, core.SelfPkgPath)
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("Failed to install the core library: %v", err)
+	}
+
+	log.Print("Installing lgo-internal")
+	cmd = exec.Command("go", "build", "-tags", "lgoplugin",
+		"-o", path.Join(binDir, "lgo-internal"), "github.com/yunabe/lgo/cmd/lgo-internal")
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("Failed to build lgo-internal: %v", err)
+	}
 }
 
 func InstallPkgMain() {
 	fSet := flag.NewFlagSet(os.Args[0]+" pkginstall", flag.ExitOnError)
+	gomod := fSet.String("gomod", "", "If set, packages are resolved in module mode with this go.mod file.")
+	parallel := fSet.Int("parallel", 0, "The number of packages built concurrently. If it is not positive, the number of CPUs is used.")
+	update := fSet.Bool("update", false, "If true, stale packages installed before and their dependents are rebuilt in addition to packages in args.")
 	// Ignore errors; fSet is set for ExitOnError.
 	fSet.Parse(os.Args[2:])
 
@@ -142,7 +189,82 @@ func InstallPkgMain() {
 	if !ok {
 		os.Exit(1)
 	}
-	if err := install.NewSOInstaller(root).Install(args...); err != nil {
+	si := newSOInstaller(root, *gomod)
+	si.Parallelism = *parallel
+	if *update {
+		stale, err := si.Stale()
+		if err != nil {
+			log.Fatalf("Failed to list stale packages: %v", err)
+		}
+		if len(stale) > 0 {
+			log.Printf("Rebuild stale packages: %v", stale)
+		}
+		args = append(args, stale...)
+		if len(args) == 0 {
+			log.Print("No stale packages")
+			return
+		}
+	}
+	if err := si.Install(args...); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// newSOInstaller returns install.SOInstaller. If gomod is not empty, packages are resolved in module mode.
+func newSOInstaller(root, gomod string) *install.SOInstaller {
+	if gomod == "" {
+		return install.NewSOInstaller(root)
+	}
+	modFile, err := filepath.Abs(gomod)
+	if err != nil {
+		log.Fatalf("Failed to get the abspath of %s: %v", gomod, err)
+	}
+	return install.NewModuleSOInstaller(root, filepath.Dir(modFile))
+}
+
+// ListPkgMain lists packages installed in $LGOPATH/pkg.
+func ListPkgMain() {
+	fSet := flag.NewFlagSet(os.Args[0]+" listpkg", flag.ExitOnError)
+	gomod := fSet.String("gomod", "", "If set, packages are resolved in module mode with this go.mod file.")
+	staleOnly := fSet.Bool("stale", false, "If true, only stale packages are listed.")
+	// Ignore errors; fSet is set for ExitOnError.
+	fSet.Parse(os.Args[2:])
+
+	root := lgoPath()
+	pkgs, err := newSOInstaller(root, *gomod).ListInstalled()
+	if err != nil {
+		log.Fatalf("Failed to list installed packages: %v", err)
+	}
+	for _, pkg := range pkgs {
+		if pkg.Stale {
+			fmt.Printf("%s (stale: %s)\n", pkg.Path, pkg.StaleReason)
+		} else if !*staleOnly {
+			fmt.Println(pkg.Path)
+		}
+	}
+}
+
+// RemovePkgMain removes packages and packages which depend on them from $LGOPATH/pkg.
+func RemovePkgMain() {
+	fSet := flag.NewFlagSet(os.Args[0]+" removepkg", flag.ExitOnError)
+	gomod := fSet.String("gomod", "", "If set, packages are resolved in module mode with this go.mod file.")
+	dryRun := fSet.Bool("dry-run", false, "If true, removepkg only reports packages to be removed.")
+	// Ignore errors; fSet is set for ExitOnError.
+	fSet.Parse(os.Args[2:])
+	if fSet.NArg() == 0 {
+		log.Fatal("No packages to remove")
+	}
+
+	root := lgoPath()
+	removed, err := newSOInstaller(root, *gomod).Remove(fSet.Args(), *dryRun)
+	if err != nil {
+		log.Fatalf("Failed to remove packages: %v", err)
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	for _, pkg := range removed {
+		fmt.Printf("%s %s\n", verb, pkg)
+	}
+}
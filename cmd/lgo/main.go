@@ -26,10 +26,12 @@ The commands are;
 
 	install       install lgo into $LGOPATH. You need to run this command before using lgo
 	installpkg    install packages into $LGOPATH. This operation is optional.
+	listpkg       list packages installed in $LGOPATH
+	removepkg     remove packages and packages depending on them from $LGOPATH
 	kernel        run a jupyter notebook kernel
 	run           run Go code defined in files
 	repl          ...
-	clean         clean temporary files created by lgo
+	clean         clean temporary files of sessions whose processes are gone
 `
 
 var commandStrRe = regexp.MustCompile("[a-z]+")
@@ -79,17 +81,69 @@ func runLgoInternal(subcommand string, extraArgs []string) {
 	runner.CleanSession(lgopath, sessID)
 }
 
+// goModFlag defines --gomod flag in fs.
+func goModFlag(fs *flag.FlagSet) *string {
+	return fs.String("gomod", "", "If set, lgo runs in module mode and lgo code uses requirements in this go.mod file.")
+}
+
+// goModArgs returns args to pass --gomod to lgo-internal.
+func goModArgs(gomod string) []string {
+	if gomod == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(gomod)
+	if err != nil {
+		log.Fatalf("Failed to get the absolute path of --gomod: %v", err)
+	}
+	return []string{"--gomod=" + abs}
+}
+
+// executorFlag defines --executor flag in fs.
+func executorFlag(fs *flag.FlagSet) *string {
+	return fs.String("executor", "", "The executor to build and run Go code: shared or plugin. If empty, the executor selected by `lgo install` is used.")
+}
+
+// executorArgs returns args to pass --executor to lgo-internal.
+func executorArgs(executor string) []string {
+	if executor == "" {
+		return nil
+	}
+	return []string{"--executor=" + executor}
+}
+
+// workerFlag defines --worker flag in fs.
+func workerFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("worker", false, "If true, Go code is executed in a worker process so that lgo survives crashes of the code.")
+}
+
+func workerArgs(worker bool) []string {
+	if !worker {
+		return nil
+	}
+	return []string{"--worker"}
+}
+
 func runMain() {
 	fs := flag.NewFlagSet("lgo run", flag.ExitOnError)
+	gomod := goModFlag(fs)
+	executor := executorFlag(fs)
+	worker := workerFlag(fs)
 	fs.Parse(os.Args[2:])
-	runLgoInternal("run", fs.Args())
+	args := append(goModArgs(*gomod), executorArgs(*executor)...)
+	args = append(args, workerArgs(*worker)...)
+	runLgoInternal("run", append(args, fs.Args()...))
 }
 
 func kernelMain() {
 	fs := flag.NewFlagSet("lgo kernel", flag.ExitOnError)
 	connectionFile := fs.String("connection_file", "", "jupyter kernel connection file path.")
+	gomod := goModFlag(fs)
+	executor := executorFlag(fs)
+	worker := workerFlag(fs)
 	fs.Parse(os.Args[2:])
-	runLgoInternal("kernel", []string{"--connection_file=" + *connectionFile})
+	args := append([]string{"--connection_file=" + *connectionFile}, goModArgs(*gomod)...)
+	args = append(args, executorArgs(*executor)...)
+	runLgoInternal("kernel", append(args, workerArgs(*worker)...))
 }
 
 func main() {
@@ -105,12 +159,16 @@ func main() {
 		install.InstallMain()
 	case "installpkg":
 		install.InstallPkgMain()
+	case "listpkg":
+		install.ListPkgMain()
+	case "removepkg":
+		install.RemovePkgMain()
 	case "kernel":
 		kernelMain()
 	case "run":
 		runMain()
 	case "clean":
-		fmt.Fprint(os.Stderr, "not implemented")
+		cleanMain()
 	case "help":
 		printUsageAndExit()
 	default:
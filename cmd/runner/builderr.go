@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// buildOutputWriter rewrites outputs of the go command and the compiler which build the package of a cell
+// so that errors look like errors of the converter: positions in src.go (mapped to lines and columns
+// in the cell with line directives) are printed without the file name and identifiers do not have LgoExport_.
+type buildOutputWriter struct {
+	w       io.Writer
+	pkgPath string
+	buf     []byte
+}
+
+func newBuildOutputWriter(w io.Writer, pkgPath string) *buildOutputWriter {
+	return &buildOutputWriter{w: w, pkgPath: pkgPath}
+}
+
+func (w *buildOutputWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+		if err := w.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line which does not end with a newline.
+func (w *buildOutputWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+func (w *buildOutputWriter) writeLine(line string) error {
+	line, ok := rewriteBuildOutputLine(line, w.pkgPath)
+	if !ok {
+		return nil
+	}
+	_, err := io.WriteString(w.w, line+"\n")
+	return err
+}
+
+// rewriteBuildOutputLine rewrites a line of the outputs to build pkgPath. It returns false if the line should be dropped.
+func rewriteBuildOutputLine(line, pkgPath string) (string, bool) {
+	if line == "# "+pkgPath {
+		// The header of errors of the package printed by the go command.
+		return "", false
+	}
+	// The compiler prints the path of src.go in the absolute form or the relative form.
+	if src := pkgPath + "/src.go:"; strings.Contains(line, src) {
+		line = line[strings.Index(line, src)+len(src):]
+	} else if strings.HasPrefix(line, "./src.go:") {
+		line = line[len("./src.go:"):]
+	}
+	return strings.Replace(line, lgoExportPrefix, "", -1), true
+}
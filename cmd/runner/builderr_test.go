@@ -0,0 +1,34 @@
+package runner
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildOutputWriter(t *testing.T) {
+	pkg := "github.com/yunabe/lgo/sess7b2274696d65/exec3"
+	var buf bytes.Buffer
+	w := newBuildOutputWriter(&buf, pkg)
+	for _, s := range []string{
+		"# " + pkg + "\n./src.go:3:10: undefined: LgoExport_x\n",
+		"/tmp/gopath/src/" + pkg + "/src.go:4:2: cannot use LgoExport_y (variable of type int)",
+		" as string value\n",
+		"\thave LgoExport_f()\n",
+		"/tmp/gopath/src/github.com/foo/bar/src.go:1:1: other package",
+	} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `3:10: undefined: x
+4:2: cannot use y (variable of type int) as string value
+	have f()
+/tmp/gopath/src/github.com/foo/bar/src.go:1:1: other package
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go/types"
+	"sort"
+
+	"github.com/yunabe/lgo/converter"
+)
+
+// buildCache caches packages built from cells so that re-executing an unchanged cell reuses
+// the package built before instead of building a new package.
+type buildCache struct {
+	entries map[string]*cachedPackage
+}
+
+// cachedPackage is a package built from a cell.
+type cachedPackage struct {
+	pkg *Package
+	// defs are the objects declared in the package.
+	defs []types.Object
+}
+
+func newBuildCache() *buildCache {
+	return &buildCache{entries: make(map[string]*cachedPackage)}
+}
+
+// buildCacheKey returns the key of the package converted from src.
+// The key consists of src and the identities of declarations in other cells referred from the converted code.
+func buildCacheKey(src string, result *converter.ConvertResult) string {
+	var refs []string
+	seen := make(map[string]bool)
+	for _, obj := range result.Checker.Uses {
+		var ref string
+		if pname, ok := obj.(*types.PkgName); ok {
+			ref = "import " + pname.Name() + " " + pname.Imported().Path()
+		} else if pkg := obj.Pkg(); pkg != nil && pkg.IsLgo && pkg != result.Pkg {
+			ref = pkg.Path() + "." + obj.Name()
+		} else {
+			continue
+		}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	h := sha256.New()
+	h.Write([]byte(src))
+	for _, ref := range refs {
+		h.Write([]byte{0})
+		h.Write([]byte(ref))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookup returns the package cached with key.
+// It returns nil if declarations in the cached package were shadowed by later cells because
+// the cached package can not be reused to redefine them (e.g. the hooks of redefined functions are already set).
+func (c *buildCache) lookup(key string, vars map[string]types.Object) *cachedPackage {
+	e := c.entries[key]
+	if e == nil {
+		return nil
+	}
+	for _, obj := range e.defs {
+		if vars[obj.Name()] != obj {
+			return nil
+		}
+	}
+	return e
+}
+
+// add caches pkg built from the converted result with key.
+func (c *buildCache) add(key string, pkg *Package, result *converter.ConvertResult) {
+	var defs []types.Object
+	scope := result.Pkg.Scope()
+	for _, name := range scope.Names() {
+		if name != converter.LgoInitFuncName {
+			defs = append(defs, scope.Lookup(name))
+		}
+	}
+	c.entries[key] = &cachedPackage{pkg: pkg, defs: defs}
+}
+
+// clear removes all cached packages.
+// This must be called when packages built before are not reusable (e.g. the executor or go.mod is changed).
+func (c *buildCache) clear() {
+	c.entries = make(map[string]*cachedPackage)
+}
+
+// clearBuildCache clears the build cache of rn and the labels of cells built before.
+func (rn *LgoRunner) clearBuildCache() {
+	rn.cache.clear()
+	clearCellLabels(rn.sessDir())
+}
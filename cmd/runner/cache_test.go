@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/converter"
+	"github.com/yunabe/lgo/core"
+)
+
+type mapImporter map[string]*types.Package
+
+func (m mapImporter) Import(path string) (*types.Package, error) {
+	return m[path], nil
+}
+
+func newOldPackage(path string) *types.Package {
+	pkg := types.NewPackage(path, "exec1")
+	pkg.IsLgo = true
+	pkg.Scope().Insert(types.NewVar(token.NoPos, pkg, "X", types.Typ[types.Int]))
+	pkg.MarkComplete()
+	return pkg
+}
+
+func convertForCacheTest(t *testing.T, old *types.Package) *converter.ConvertResult {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "src.go", `package exec2
+import "lgo/exec1"
+var Y = exec1.X
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := types.NewPackage("lgo/exec2", "exec2")
+	pkg.IsLgo = true
+	info := &types.Info{Uses: make(map[*ast.Ident]types.Object)}
+	checker := types.NewChecker(&types.Config{Importer: mapImporter{"lgo/exec1": old}}, fset, pkg, info)
+	if err := checker.Files([]*ast.File{file}); err != nil {
+		t.Fatal(err)
+	}
+	return &converter.ConvertResult{Pkg: pkg, Checker: checker}
+}
+
+func TestBuildCache(t *testing.T) {
+	old := newOldPackage("lgo/exec1")
+	result := convertForCacheTest(t, old)
+	key := buildCacheKey("Y := X", result)
+	if again := buildCacheKey("Y := X", convertForCacheTest(t, old)); again != key {
+		t.Errorf("Keys of the same cell are different: %s, %s", key, again)
+	}
+	if other := buildCacheKey("Y := X", convertForCacheTest(t, newOldPackage("lgo/exec0"))); other == key {
+		t.Error("The key does not depend on referred declarations")
+	}
+	if other := buildCacheKey("Y := X ", result); other == key {
+		t.Error("The key does not depend on the source")
+	}
+
+	c := newBuildCache()
+	y := result.Pkg.Scope().Lookup("Y")
+	if e := c.lookup(key, map[string]types.Object{"Y": y}); e != nil {
+		t.Errorf("Unexpected cache hit: %v", e)
+	}
+	c.add(key, &Package{Path: "lgo/exec2"}, result)
+	if e := c.lookup(key, map[string]types.Object{"Y": y}); e == nil || e.pkg.Path != "lgo/exec2" {
+		t.Errorf("Unexpected cache entry: %v", e)
+	}
+	// The cached package can not be reused once Y is redefined in another cell.
+	redefined := types.NewVar(token.NoPos, types.NewPackage("lgo/exec3", "exec3"), "Y", types.Typ[types.Int])
+	if e := c.lookup(key, map[string]types.Object{"Y": redefined}); e != nil {
+		t.Errorf("Unexpected cache hit: %v", e)
+	}
+	c.clear()
+	if e := c.lookup(key, map[string]types.Object{"Y": y}); e != nil {
+		t.Errorf("Unexpected cache hit after clear: %v", e)
+	}
+}
+
+// countingExecutor counts packages built and loaded without building them.
+type countingExecutor struct {
+	built, loaded []string
+}
+
+func (e *countingExecutor) Build(ctx context.Context, pkg *Package) error {
+	e.built = append(e.built, pkg.Path)
+	return nil
+}
+
+func (e *countingExecutor) Load(ctx core.LgoContext, pkg *Package) error {
+	e.loaded = append(e.loaded, pkg.Path)
+	return nil
+}
+
+func TestRunCellCache(t *testing.T) {
+	// The converter imports core with the default importer.
+	if _, err := importer.Default().Import(core.SelfPkgPath); err != nil {
+		t.Skipf("core is not installed: %v", err)
+	}
+	lgopath, err := ioutil.TempDir("", "lgo_cache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lgopath)
+	sessID := NewSessionID()
+	defer CleanSession(lgopath, sessID)
+	rn := NewLgoRunner(lgopath, sessID)
+	e := &countingExecutor{}
+	rn.SetExecutor(e)
+	ctx := core.LgoContext{Context: context.Background()}
+	for _, src := range []string{"x := 10", "x := 10", "x := 11"} {
+		if err := rn.Run(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec1, exec3 := rn.sessDir()+"/exec1", rn.sessDir()+"/exec3"
+	// The second run of the same cell does not build a package.
+	if want := []string{exec1, exec3}; !reflect.DeepEqual(e.built, want) {
+		t.Errorf("Built %v; want %v", e.built, want)
+	}
+	if want := []string{exec1, exec1, exec3}; !reflect.DeepEqual(e.loaded, want) {
+		t.Errorf("Loaded %v; want %v", e.loaded, want)
+	}
+}
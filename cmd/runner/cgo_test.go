@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// TestPluginExecutor_cgo builds and loads packages in the form of converted cells which use cgo.
+// exec1 imports "C" and exec2 refers to C types in exec1 through the aliases declared by the converter.
+func TestPluginExecutor_cgo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Building plugins is slow")
+	}
+	lgopath, err := ioutil.TempDir("", "lgo_cgo_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lgopath)
+	sessID := NewSessionID()
+	defer CleanSession(lgopath, sessID)
+	rn := NewLgoRunner(lgopath, sessID)
+	if err := rn.UseExecutor("plugin"); err != nil {
+		t.Fatal(err)
+	}
+	p := &recordPrinter{}
+	core.RegisterLgoPrinter(p)
+	defer core.UnregisterLgoPrinter(p)
+
+	exec1 := path.Join(rn.sessDir(), "exec1")
+	writePkgSrc(t, exec1, `package exec1
+
+// extern double callback(double x);
+// static double apply(double x) { return callback(x); }
+import "C"
+
+import "github.com/yunabe/lgo/core"
+
+func LgoExport_callback(x C.double) C.double {
+	return x * 2
+}
+
+//export callback
+func callback(x C.double) C.double { return LgoExport_callback(x) }
+
+func lgo_init() {
+	LgoExport_x = C.apply(1.5)
+	core.LgoPrintln(float64(LgoExport_x))
+}
+
+type (
+	LgoCtype_int    = C.int
+	LgoCtype_double = C.double
+)
+
+var (
+	LgoExport_x LgoCtype_double
+)
+`)
+	exec2 := path.Join(rn.sessDir(), "exec2")
+	writePkgSrc(t, exec2, `package exec2
+
+import (
+	"github.com/yunabe/lgo/core"
+	pkg0 "`+exec1+`"
+)
+
+func lgo_init() {
+	LgoExport_y = pkg0.LgoExport_x + 1
+	LgoExport_z = pkg0.LgoCtype_int(LgoExport_y)
+	core.LgoPrintln(float64(LgoExport_y), int32(LgoExport_z))
+}
+
+var (
+	LgoExport_y pkg0.LgoCtype_double
+	LgoExport_z pkg0.LgoCtype_int
+)
+`)
+	ctx := core.LgoContext{Context: context.Background()}
+	for _, pkg := range []*Package{
+		{Path: exec1, HasEntry: true},
+		{Path: exec2, HasEntry: true},
+	} {
+		if err := rn.executor.Build(ctx, pkg); err != nil {
+			t.Fatal(err)
+		}
+		if err := rn.executor.Load(ctx, pkg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []interface{}{3.0, 4.0, int32(4)}
+	if !reflect.DeepEqual(p.lines, want) {
+		t.Errorf("Got %v; want %v", p.lines, want)
+	}
+}
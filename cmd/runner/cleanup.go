@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -38,3 +40,104 @@ func CleanSession(lgopath string, sessID *SessionID) error {
 	}
 	return soErr
 }
+
+// sessPkgPrefix is the prefix of package paths of sessions.
+const sessPkgPrefix = "github.com/yunabe/lgo/" + idPrefix
+
+// SessionFiles is the set of files of a session left in GOPATH and LGOPATH.
+type SessionFiles struct {
+	ID *SessionID
+	// Paths are files and directories of the session.
+	Paths []string
+	// Size is the total size of files in bytes.
+	Size int64
+}
+
+// ListSessions returns files of all sessions in GOPATH and LGOPATH sorted by the start time of sessions.
+func ListSessions(lgopath string) ([]*SessionFiles, error) {
+	return listSessions(build.Default.GOPATH, lgopath)
+}
+
+func listSessions(gopath, lgopath string) ([]*SessionFiles, error) {
+	sessions := make(map[string]*SessionFiles)
+	add := func(name, p string) {
+		s := sessions[name]
+		if s == nil {
+			var id SessionID
+			if err := id.Unmarshal(name); err != nil {
+				// Not a session.
+				return
+			}
+			s = &SessionFiles{ID: &id}
+			sessions[name] = s
+		}
+		s.Paths = append(s.Paths, p)
+		s.Size += diskUsage(p)
+	}
+	// Source files and archives of packages in sessions.
+	for _, dir := range []string{
+		path.Join(gopath, "src", path.Dir(sessPkgPrefix)),
+		path.Join(lgopath, "pkg", path.Dir(sessPkgPrefix)),
+	} {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() && strings.HasPrefix(f.Name(), idPrefix) {
+				add(f.Name(), path.Join(dir, f.Name()))
+			}
+		}
+	}
+	// Shared libraries of packages in sessions.
+	pkg := path.Join(lgopath, "pkg")
+	files, err := ioutil.ReadDir(pkg)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	libPrefix := "lib" + strings.Replace(path.Dir(sessPkgPrefix), "/", "-", -1) + "-"
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), libPrefix) || !strings.HasSuffix(f.Name(), ".so") {
+			continue
+		}
+		name := strings.TrimPrefix(f.Name(), libPrefix)
+		if i := strings.IndexAny(name, "-."); i >= 0 {
+			name = name[:i]
+		}
+		if strings.HasPrefix(name, idPrefix) {
+			add(name, path.Join(pkg, f.Name()))
+		}
+	}
+	var list []*SessionFiles
+	for _, s := range sessions {
+		sort.Strings(s.Paths)
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID.Time < list[j].ID.Time
+	})
+	return list, nil
+}
+
+// diskUsage returns the total size of files under p.
+func diskUsage(p string) int64 {
+	var size int64
+	filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Remove removes files of the session.
+func (s *SessionFiles) Remove() error {
+	var err error
+	for _, p := range s.Paths {
+		if rerr := os.RemoveAll(p); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestListSessions(t *testing.T) {
+	root, err := ioutil.TempDir("", "cleanup_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	gopath, lgopath := path.Join(root, "gopath"), path.Join(root, "lgopath")
+
+	// A process which is gone.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dead := &SessionID{Time: 1, PID: cmd.Process.Pid}
+	// The session must be created after the current process started. Otherwise, PID is regarded as reused.
+	alive := &SessionID{Time: time.Now().UnixNano(), PID: os.Getpid()}
+	srcDir := path.Join(gopath, "src/github.com/yunabe/lgo")
+	pkgDir := path.Join(lgopath, "pkg")
+	files := map[string]string{
+		path.Join(srcDir, dead.Marshal(), "exec1/src.go"):                                 "package lgo_exec",
+		path.Join(pkgDir, "github.com/yunabe/lgo", dead.Marshal(), "exec1.a"):             "12345",
+		path.Join(pkgDir, "libgithub.com-yunabe-lgo-"+dead.Marshal()+"-exec1.so"):         "123",
+		path.Join(srcDir, alive.Marshal(), "exec1/src.go"):                                "",
+		path.Join(pkgDir, "libgithub.com-yunabe-lgo-"+alive.Marshal()+"-exec2.plugin.so"): "",
+		path.Join(pkgDir, "libgithub.com-yunabe-lgo-core.so"):                             "",
+		path.Join(srcDir, "sessinvalid/exec1/src.go"):                                     "",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(name), 0766); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions, err := listSessions(gopath, lgopath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Unexpected sessions: %v", sessions)
+	}
+	if s := sessions[0]; *s.ID != *dead || len(s.Paths) != 3 || s.Size != 24 {
+		t.Errorf("Unexpected session: %+v", s)
+	}
+	if s := sessions[1]; *s.ID != *alive || len(s.Paths) != 2 {
+		t.Errorf("Unexpected session: %+v", s)
+	}
+	if sessions[0].ID.IsAlive() {
+		t.Error("The session of the exited process is alive")
+	}
+	if !sessions[1].ID.IsAlive() {
+		t.Error("The session of the current process is not alive")
+	}
+	if (&SessionID{Time: 3}).IsAlive() {
+		t.Error("The session without the owner is alive")
+	}
+
+	if err := sessions[0].Remove(); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err = listSessions(gopath, lgopath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || *sessions[0].ID != *alive {
+		t.Errorf("Unexpected sessions after Remove: %v", sessions)
+	}
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// A Package is a Go package converted from lgo code.
+// The source of the package is stored in GOPATH before it is passed to Executor.
+type Package struct {
+	// Path is the import path of the package.
+	Path string
+	// Deps is the list of package paths imported from the package.
+	Deps []string
+	// HasEntry is true if the package has the entry point (lgo_init) of lgo code.
+	// If HasEntry is false, Executor.Load only loads declarations in the package.
+	HasEntry bool
+	// Label is the name of the cell shown in stack traces (e.g. In[3]).
+	Label string
+}
+
+// Executor is the interface to build packages converted from lgo code and to execute them.
+// Build and Load are separated so that packages can be built and executed in different processes.
+type Executor interface {
+	// Build builds pkg.
+	Build(ctx context.Context, pkg *Package) error
+	// Load loads pkg built by Build into the current process and runs its entry point.
+	Load(ctx core.LgoContext, pkg *Package) error
+}
+
+// executors is the registry of executors available in this binary.
+// The shared executor is not available if lgo is built with lgoplugin tag.
+var executors = make(map[string]func(rn *LgoRunner) Executor)
+
+func registerExecutor(name string, newExecutor func(rn *LgoRunner) Executor) {
+	executors[name] = newExecutor
+}
+
+// ExecutorNames returns the names of executors available in this binary.
+func ExecutorNames() []string {
+	var names []string
+	for name := range executors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseExecutor switches the executor of rn to the executor registered with name.
+func (rn *LgoRunner) UseExecutor(name string) error {
+	newExecutor := executors[name]
+	if newExecutor == nil {
+		return fmt.Errorf("unknown executor %q (available: %v)", name, ExecutorNames())
+	}
+	rn.executor = newExecutor(rn)
+	rn.executorName = name
+	rn.clearBuildCache()
+	return nil
+}
+
+// SetExecutor replaces the executor of rn with e.
+func (rn *LgoRunner) SetExecutor(e Executor) {
+	rn.executor = e
+	rn.clearBuildCache()
+}
+
+// DefaultExecutorName is the name of the executor used by default.
+// It is "shared" unless lgo is built with lgoplugin tag.
+const DefaultExecutorName = defaultExecutorName
@@ -0,0 +1,201 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yunabe/lgo/cmd/install"
+	"github.com/yunabe/lgo/core"
+)
+
+// lgoModulePath is the module path of lgo. lgo code in module mode requires this module to import core.
+const lgoModulePath = "github.com/yunabe/lgo"
+
+// sessionModule manages go.mod of a lgo session in module mode.
+// Packages converted from lgo code are placed under the directory of the module.
+type sessionModule struct {
+	dir string
+	// baseDir is the directory against which relative paths of local replacements are resolved.
+	// It is the directory of the base go.mod or the working directory of the kernel if no base is given.
+	baseDir string
+}
+
+// goModJSON is the output of go mod edit -json.
+type goModJSON struct {
+	Module struct {
+		Path string
+	}
+	Go      string
+	Require []struct {
+		Path    string
+		Version string
+	}
+	Replace []struct {
+		Old struct {
+			Path    string
+			Version string
+		}
+		New struct {
+			Path    string
+			Version string
+		}
+	}
+}
+
+// prepareLgoModule creates a module of lgo under $LGOPATH and returns its directory.
+// The module links to the source of the core package in GOPATH so that
+// lgo code and the .so file of core installed by `lgo install` share the same source.
+func prepareLgoModule(lgopath string) (string, error) {
+	dir := path.Join(lgopath, "mod", lgoModulePath)
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "go.mod"), []byte("module "+lgoModulePath+"\n"), 0666); err != nil {
+		return "", err
+	}
+	pkg, err := build.Import(core.SelfPkgPath, "", build.FindOnly)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the source of %s: %v", core.SelfPkgPath, err)
+	}
+	link := path.Join(dir, "core")
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.Symlink(pkg.Dir, link); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// newSessionModule creates go.mod of the module modPath in dir.
+// If base is not empty, requirements and replacements in base (a path to go.mod) are copied to the new go.mod
+// and the module of base itself is also available from lgo code.
+func newSessionModule(lgopath, dir, modPath, base string) (*sessionModule, error) {
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "go.mod"), []byte("module "+modPath+"\n"), 0666); err != nil {
+		return nil, err
+	}
+	lgoDir, err := prepareLgoModule(lgopath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare lgo module: %v", err)
+	}
+	m := &sessionModule{dir: dir}
+	args := []string{"-require=" + lgoModulePath + "@v0.0.0", "-replace=" + lgoModulePath + "=" + lgoDir}
+	if base != "" {
+		base, err = filepath.Abs(base)
+		if err != nil {
+			return nil, err
+		}
+		baseArgs, err := importGoMod(base)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, baseArgs...)
+		m.baseDir = filepath.Dir(base)
+	} else if m.baseDir, err = os.Getwd(); err != nil {
+		return nil, err
+	}
+	if err := m.edit(args...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// isLocalPath returns whether p is a path to a local directory in replace directives of go.mod.
+func isLocalPath(p string) bool {
+	return p == "." || p == ".." || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") || filepath.IsAbs(p)
+}
+
+// absLocalPath returns the absolute path of the local path p. A relative p is relative to dir.
+func absLocalPath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(dir, p)
+}
+
+// importGoMod returns flags of go mod edit to copy requirements and replacements in base (an absolute path to go.mod).
+func importGoMod(base string) ([]string, error) {
+	var out bytes.Buffer
+	cmd := install.GoCommand(context.Background(), "", "mod", "edit", "-json", base)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", base, err)
+	}
+	var mod goModJSON
+	if err := json.Unmarshal(out.Bytes(), &mod); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", base, err)
+	}
+	baseDir := filepath.Dir(base)
+	var args []string
+	if mod.Go != "" {
+		args = append(args, "-go="+mod.Go)
+	}
+	if mod.Module.Path != "" {
+		// Make packages in the base module importable from lgo code.
+		args = append(args, "-require="+mod.Module.Path+"@v0.0.0", "-replace="+mod.Module.Path+"="+baseDir)
+	}
+	for _, r := range mod.Require {
+		args = append(args, "-require="+r.Path+"@"+r.Version)
+	}
+	for _, r := range mod.Replace {
+		old := r.Old.Path
+		if r.Old.Version != "" {
+			old += "@" + r.Old.Version
+		}
+		repl := r.New.Path
+		if r.New.Version != "" {
+			repl += "@" + r.New.Version
+		} else if isLocalPath(repl) {
+			// Local replacements are relative to the directory of base.
+			repl = absLocalPath(baseDir, repl)
+		}
+		args = append(args, "-replace="+old+"="+repl)
+	}
+	return args, nil
+}
+
+func (m *sessionModule) edit(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := install.GoCommand(context.Background(), m.dir, append([]string{"mod", "edit"}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go mod edit failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Require adds a requirement of the module path@version to go.mod.
+func (m *sessionModule) Require(path, version string) error {
+	return m.edit("-require=" + path + "@" + version)
+}
+
+// Replace adds a replacement of the module old with repl to go.mod.
+// repl is a module path with a version or a path to a local directory.
+// Like replacements imported from the base go.mod, a relative local path is relative to m.baseDir.
+func (m *sessionModule) Replace(old, repl string) error {
+	if isLocalPath(repl) {
+		repl = absLocalPath(m.baseDir, repl)
+	}
+	return m.edit("-replace=" + old + "=" + repl)
+}
+
+// goCommand returns exec.Cmd to run the go command in the module.
+// -mod=mod is set so that go commands can add missing requirements to go.mod.
+func (m *sessionModule) goCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := install.GoCommand(ctx, m.dir, args...)
+	cmd.Env = append(cmd.Env, "GOFLAGS=-mod=mod")
+	return cmd
+}
@@ -0,0 +1,233 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/yunabe/lgo/cmd/install"
+)
+
+func skipIfNoModules(t *testing.T) {
+	if err := exec.Command("go", "help", "mod").Run(); err != nil {
+		t.Skipf("go does not support modules: %v", err)
+	}
+}
+
+// readGoMod returns the module path, requirements and replacements in go.mod in dir.
+// Requirements are formatted as "path@version" and replacements as "old => new" in the sorted order.
+func readGoMod(t *testing.T, dir string) (modPath string, requires, replaces []string) {
+	var out bytes.Buffer
+	cmd := install.GoCommand(context.Background(), "", "mod", "edit", "-json", filepath.Join(dir, "go.mod"))
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var mod goModJSON
+	if err := json.Unmarshal(out.Bytes(), &mod); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range mod.Require {
+		requires = append(requires, r.Path+"@"+r.Version)
+	}
+	for _, r := range mod.Replace {
+		old, repl := r.Old.Path, r.New.Path
+		if r.Old.Version != "" {
+			old += "@" + r.Old.Version
+		}
+		if r.New.Version != "" {
+			repl += "@" + r.New.Version
+		}
+		replaces = append(replaces, old+" => "+repl)
+	}
+	sort.Strings(requires)
+	sort.Strings(replaces)
+	return mod.Module.Path, requires, replaces
+}
+
+func TestNewSessionModule(t *testing.T) {
+	skipIfNoModules(t)
+	tmp, err := ioutil.TempDir("", "lgo_gomod_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	lgopath := filepath.Join(tmp, "lgo")
+	lgoDir := filepath.Join(lgopath, "mod", lgoModulePath)
+	baseDir := filepath.Join(tmp, "notebook")
+	if err := os.MkdirAll(baseDir, 0766); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		base     string // The content of the base go.mod. No base is used if it is empty.
+		requires []string
+		replaces []string
+		baseDir  string
+	}{
+		{
+			name:     "no base",
+			requires: []string{lgoModulePath + "@v0.0.0"},
+			replaces: []string{lgoModulePath + " => " + lgoDir},
+			baseDir:  wd,
+		},
+		{
+			name: "base with module path",
+			base: "module example.com/base\n\nrequire golang.org/x/text v0.3.0\n",
+			requires: []string{
+				"example.com/base@v0.0.0",
+				lgoModulePath + "@v0.0.0",
+				"golang.org/x/text@v0.3.0",
+			},
+			replaces: []string{
+				"example.com/base => " + baseDir,
+				lgoModulePath + " => " + lgoDir,
+			},
+			baseDir: baseDir,
+		},
+		{
+			name: "replaces",
+			base: "require (\n\texample.com/a v1.0.0\n\texample.com/b v1.0.0\n)\n\nreplace (\n" +
+				"\texample.com/a => ./a\n" +
+				"\texample.com/b v1.0.0 => ../b\n" +
+				"\texample.com/c => /abs/c\n" +
+				"\tgolang.org/x/net => golang.org/x/net v0.0.1\n" +
+				"\tgolang.org/x/text v0.3.0 => example.com/text v0.3.1\n" +
+				")\n",
+			requires: []string{
+				"example.com/a@v1.0.0",
+				"example.com/b@v1.0.0",
+				lgoModulePath + "@v0.0.0",
+			},
+			replaces: []string{
+				"example.com/a => " + filepath.Join(baseDir, "a"),
+				"example.com/b@v1.0.0 => " + filepath.Join(tmp, "b"),
+				"example.com/c => /abs/c",
+				lgoModulePath + " => " + lgoDir,
+				"golang.org/x/net => golang.org/x/net@v0.0.1",
+				"golang.org/x/text@v0.3.0 => example.com/text@v0.3.1",
+			},
+			baseDir: baseDir,
+		},
+	}
+	for i, tc := range tests {
+		dir := filepath.Join(tmp, "sess", tc.name)
+		var base string
+		if tc.base != "" {
+			base = filepath.Join(baseDir, "go.mod")
+			if err := ioutil.WriteFile(base, []byte(tc.base), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m, err := newSessionModule(lgopath, dir, "example.com/sess", base)
+		if err != nil {
+			t.Errorf("#%d %s: %v", i, tc.name, err)
+			continue
+		}
+		if m.baseDir != tc.baseDir {
+			t.Errorf("#%d %s: baseDir = %q; want %q", i, tc.name, m.baseDir, tc.baseDir)
+		}
+		modPath, requires, replaces := readGoMod(t, dir)
+		if modPath != "example.com/sess" {
+			t.Errorf("#%d %s: module path = %q; want example.com/sess", i, tc.name, modPath)
+		}
+		if !reflect.DeepEqual(requires, tc.requires) {
+			t.Errorf("#%d %s: got requires %q; want %q", i, tc.name, requires, tc.requires)
+		}
+		if !reflect.DeepEqual(replaces, tc.replaces) {
+			t.Errorf("#%d %s: got replaces %q; want %q", i, tc.name, replaces, tc.replaces)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(lgoDir, "core", "core.go")); err != nil {
+		t.Errorf("core is not linked from the lgo module: %v", err)
+	}
+}
+
+func TestSessionModuleRequireReplace(t *testing.T) {
+	skipIfNoModules(t)
+	tmp, err := ioutil.TempDir("", "lgo_gomod_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	lgopath := filepath.Join(tmp, "lgo")
+	baseDir := filepath.Join(tmp, "notebook")
+	if err := os.MkdirAll(baseDir, 0766); err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(baseDir, "go.mod")
+	if err := ioutil.WriteFile(base, []byte("module example.com/base\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmp, "sess")
+	m, err := newSessionModule(lgopath, dir, "example.com/sess", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Require("golang.org/x/text", "v0.3.0"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		old, repl string
+		want      string
+	}{
+		{"example.com/a", "./a", "example.com/a => " + filepath.Join(baseDir, "a")},
+		{"example.com/b", "../b", "example.com/b => " + filepath.Join(tmp, "b")},
+		{"example.com/c", ".", "example.com/c => " + baseDir},
+		{"example.com/d", "/abs/d/", "example.com/d => /abs/d"},
+		{"example.com/e@v1.0.0", "example.com/f@v1.1.0", "example.com/e@v1.0.0 => example.com/f@v1.1.0"},
+	}
+	for _, tc := range tests {
+		if err := m.Replace(tc.old, tc.repl); err != nil {
+			t.Errorf("Replace(%q, %q) failed: %v", tc.old, tc.repl, err)
+			continue
+		}
+		_, _, replaces := readGoMod(t, dir)
+		found := false
+		for _, r := range replaces {
+			found = found || r == tc.want
+		}
+		if !found {
+			t.Errorf("Replace(%q, %q): %q is not found in %q", tc.old, tc.repl, tc.want, replaces)
+		}
+	}
+	_, requires, _ := readGoMod(t, dir)
+	want := []string{"example.com/base@v0.0.0", lgoModulePath + "@v0.0.0", "golang.org/x/text@v0.3.0"}
+	if !reflect.DeepEqual(requires, want) {
+		t.Errorf("Got requires %q; want %q", requires, want)
+	}
+}
+
+func TestIsLocalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{".", true},
+		{"..", true},
+		{"./a", true},
+		{"../a", true},
+		{"/a/b", true},
+		{".a", false},
+		{"example.com/a", false},
+		{"example.com/a@v1.0.0", false},
+	}
+	for _, tc := range tests {
+		if got := isLocalPath(tc.path); got != tc.want {
+			t.Errorf("isLocalPath(%q) = %v; want %v", tc.path, got, tc.want)
+		}
+	}
+}
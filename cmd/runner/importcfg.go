@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// shlibnameExt is the extension of files which the go command writes next to archives of packages
+// installed with -buildmode=shared. The content of a .shlibname file is the name of the shared library of the package.
+const shlibnameExt = ".shlibname"
+
+// importConfig is the import configuration of packages installed as shared libraries in $LGOPATH/pkg.
+// It is used to run compile and link without the go command.
+type importConfig struct {
+	pkgDir string
+	// shlibs maps import paths to the paths of shared libraries which contain the packages.
+	shlibs map[string]string
+}
+
+func newImportConfig(pkgDir string) *importConfig {
+	return &importConfig{pkgDir: pkgDir, shlibs: make(map[string]string)}
+}
+
+// load reads .shlibname files in pkgDir.
+func (c *importConfig) load() error {
+	shlibs := make(map[string]string)
+	err := filepath.Walk(c.pkgDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == c.pkgDir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, shlibnameExt) {
+			return nil
+		}
+		rel, err := filepath.Rel(c.pkgDir, strings.TrimSuffix(p, shlibnameExt))
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		shlibs[filepath.ToSlash(rel)] = filepath.Join(c.pkgDir, string(bytes.TrimSpace(b)))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load the import config of %s: %v", c.pkgDir, err)
+	}
+	c.shlibs = shlibs
+	return nil
+}
+
+// archive returns the path to the archive of pkg.
+func (c *importConfig) archive(pkg string) string {
+	return filepath.Join(c.pkgDir, filepath.FromSlash(pkg)+".a")
+}
+
+// install records that pkg is installed in the shared library lib (a file name in pkgDir).
+func (c *importConfig) install(pkg, lib string) error {
+	name := filepath.Join(c.pkgDir, filepath.FromSlash(pkg)+shlibnameExt)
+	if err := ioutil.WriteFile(name, []byte(lib+"\n"), 0666); err != nil {
+		return err
+	}
+	c.shlibs[pkg] = filepath.Join(c.pkgDir, lib)
+	return nil
+}
+
+// missing returns packages in deps which are not installed as shared libraries.
+func (c *importConfig) missing(deps []string) []string {
+	var m []string
+	for _, dep := range deps {
+		if dep == "unsafe" {
+			continue
+		}
+		if _, ok := c.shlibs[dep]; !ok {
+			m = append(m, dep)
+		}
+	}
+	return m
+}
+
+func (c *importConfig) sortedPkgs() []string {
+	var pkgs []string
+	for pkg := range c.shlibs {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// compileConfig returns the content of -importcfg of compile.
+func (c *importConfig) compileConfig() string {
+	var buf bytes.Buffer
+	buf.WriteString("# import config\n")
+	for _, pkg := range c.sortedPkgs() {
+		fmt.Fprintf(&buf, "packagefile %s=%s\n", pkg, c.archive(pkg))
+	}
+	return buf.String()
+}
+
+// linkConfig returns the content of -importcfg of link.
+func (c *importConfig) linkConfig() string {
+	var buf bytes.Buffer
+	buf.WriteString("# import config\n")
+	for _, pkg := range c.sortedPkgs() {
+		fmt.Fprintf(&buf, "packagefile %s=%s\n", pkg, c.archive(pkg))
+		fmt.Fprintf(&buf, "packageshlib %s=%s\n", pkg, c.shlibs[pkg])
+	}
+	return buf.String()
+}
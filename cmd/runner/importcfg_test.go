@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importcfg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "github.com/foo"), 0766); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"fmt.shlibname":                                    "libstd.so\n",
+		"github.com/foo.shlibname":                         "libgithub.com-foo.so\n",
+		"github.com/foo/bar.a":                             "",
+		"mod/github.com%2Ffoo@v1.0.0/libgithub.com-foo.so": "",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0766); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := newImportConfig(dir)
+	if err := cfg.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.missing([]string{"fmt", "unsafe", "github.com/foo", "github.com/foo/bar"}), []string{"github.com/foo/bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing() = %v; want %v", got, want)
+	}
+	if err := cfg.install("github.com/foo/bar", "libgithub.com-foo-bar.so"); err != nil {
+		t.Fatal(err)
+	}
+	want := "# import config\n" +
+		"packagefile fmt=" + dir + "/fmt.a\n" +
+		"packageshlib fmt=" + dir + "/libstd.so\n" +
+		"packagefile github.com/foo=" + dir + "/github.com/foo.a\n" +
+		"packageshlib github.com/foo=" + dir + "/libgithub.com-foo.so\n" +
+		"packagefile github.com/foo/bar=" + dir + "/github.com/foo/bar.a\n" +
+		"packageshlib github.com/foo/bar=" + dir + "/libgithub.com-foo-bar.so\n"
+	if got := cfg.linkConfig(); got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+
+	// The installed package is found when the config is reloaded.
+	reloaded := newImportConfig(dir)
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reloaded.shlibs, cfg.shlibs) {
+		t.Errorf("Got %v; want %v", reloaded.shlibs, cfg.shlibs)
+	}
+
+	// pkgDir does not exist.
+	if err := newImportConfig(filepath.Join(dir, "notexist")).load(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,3 +1,5 @@
+// +build !lgoplugin
+
 // Function declarations to access unexported methods in runtime package.
 // This technique to access unexported methods is used in other packages
 // (e.g. byteIndex in https://golang.org/src/net/parse.go)
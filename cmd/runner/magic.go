@@ -0,0 +1,289 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/magic"
+)
+
+// A Magic is a magic command available in cells (e.g. %save_session). See package magic for the syntax.
+type Magic struct {
+	// Name is the name of the command without prefixes.
+	Name string
+	// Cell is true if the command is a cell magic (%%name). Otherwise, the command is a line magic (%name).
+	Cell bool
+	// Usage is the description of the command shown by %lsmagic and inspection.
+	Usage string
+	// Run runs the command.
+	Run func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error
+	// Complete returns candidates to complete src at index like LgoRunner.Complete.
+	// It is called only when the cursor is not on the name of the command. Complete may be nil.
+	Complete func(ctx context.Context, rn *LgoRunner, src string, cmd *magic.Command, index int) (matches []string, start, end int)
+}
+
+func (m *Magic) String() string {
+	return (&magic.Command{Name: m.Name, Cell: m.Cell}).String()
+}
+
+// magics is the registry of magic commands keyed by their names with prefixes.
+var magics = make(map[string]*Magic)
+
+// RegisterMagic registers a magic command. Magic commands must be registered before LgoRunner runs cells.
+func RegisterMagic(m *Magic) error {
+	if m.Name == "" || m.Run == nil {
+		return errors.New("Name and Run of magic commands must be set")
+	}
+	if cmd, ok := magic.Parse(m.String()); !ok || cmd.Name != m.Name {
+		return fmt.Errorf("invalid name of a magic command: %q", m.Name)
+	}
+	if _, ok := magics[m.String()]; ok {
+		return fmt.Errorf("%s is already registered", m)
+	}
+	magics[m.String()] = m
+	return nil
+}
+
+func mustRegisterMagic(m *Magic) {
+	if err := RegisterMagic(m); err != nil {
+		panic(err)
+	}
+}
+
+// MagicNames returns the names of registered magic commands with prefixes in the sorted order.
+func MagicNames() []string {
+	var names []string
+	for name := range magics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunMagic runs the magic command cmd.
+func (rn *LgoRunner) RunMagic(ctx core.LgoContext, cmd *magic.Command) error {
+	m := magics[cmd.String()]
+	if m == nil {
+		return fmt.Errorf("unknown magic command: %s. Run %slsmagic to list magic commands", cmd, magic.Prefix)
+	}
+	rn.magicLabel = rn.nextLabel
+	rn.nextLabel = ""
+	defer func() { rn.magicLabel = "" }()
+	return m.Run(ctx, rn, cmd)
+}
+
+// completeMagic completes names of magic commands or delegates the completion to the magic command of src.
+func (rn *LgoRunner) completeMagic(ctx context.Context, src string, cmd *magic.Command, index int) (matches []string, start, end int) {
+	start, end, _ = magic.NameRange(src)
+	if start <= index && index <= end {
+		prefix := src[start:index]
+		for _, name := range MagicNames() {
+			if strings.HasPrefix(name, prefix) {
+				matches = append(matches, name)
+			}
+		}
+		return matches, start, end
+	}
+	if m := magics[cmd.String()]; m != nil && m.Complete != nil {
+		return m.Complete(ctx, rn, src, cmd, index)
+	}
+	return nil, 0, 0
+}
+
+// inspectMagic returns the usage of the magic command cmd.
+func inspectMagic(cmd *magic.Command) string {
+	m := magics[cmd.String()]
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", m, m.Usage)
+}
+
+// noArgs returns an error if cmd has arguments.
+func noArgs(cmd *magic.Command) error {
+	if cmd.Args != "" || cmd.Body != "" {
+		return fmt.Errorf("%s does not take arguments", cmd)
+	}
+	return nil
+}
+
+// snapshotDir returns the directory of the snapshot in the arguments of cmd.
+func (rn *LgoRunner) snapshotDir(cmd *magic.Command) (string, error) {
+	fields := strings.Fields(cmd.Args)
+	switch len(fields) {
+	case 0:
+		return DefaultSnapshotDir(rn.lgopath), nil
+	case 1:
+		return fields[0], nil
+	}
+	return "", fmt.Errorf("usage: %s [dir]", cmd)
+}
+
+// timeCode returns lgo code to measure the execution of stmt with fn (LgoTime or LgoTimeit) in core.
+// stmt starts in the first line so that lines in errors are lines in the cell.
+// The measurement is done in the converted code. Thus, it does not include the time to build and load the code.
+func timeCode(fn, stmt string) string {
+	return fmt.Sprintf("import %s %q; %s.%s(func() { %s\n})\n", snapshotImportName, core.SelfPkgPath, snapshotImportName, fn, stmt)
+}
+
+// newTimeMagic returns a line magic which measures the execution of the statement in the arguments with fn in core.
+func newTimeMagic(name, fn, usage string) *Magic {
+	return &Magic{
+		Name:  name,
+		Usage: usage,
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			if cmd.Args == "" {
+				return fmt.Errorf("usage: %s statement", cmd)
+			}
+			// The statement is code in the cell. Show the label of the cell in stack traces.
+			return rn.runInternal(ctx, timeCode(fn, cmd.Args), rn.magicLabel)
+		},
+		Complete: completeArgsAsCode,
+	}
+}
+
+// completeArgsAsCode completes the arguments of a magic command as lgo code.
+func completeArgsAsCode(ctx context.Context, rn *LgoRunner, src string, cmd *magic.Command, index int) (matches []string, start, end int) {
+	if index < cmd.ArgsOffset {
+		return nil, 0, 0
+	}
+	matches, start, end = rn.Complete(ctx, src[cmd.ArgsOffset:], index-cmd.ArgsOffset)
+	return matches, start + cmd.ArgsOffset, end + cmd.ArgsOffset
+}
+
+// completeVarNames completes the names of variables in the arguments of a magic command.
+func completeVarNames(ctx context.Context, rn *LgoRunner, src string, cmd *magic.Command, index int) (matches []string, start, end int) {
+	if index < cmd.ArgsOffset {
+		return nil, 0, 0
+	}
+	isSpace := func(c byte) bool { return strings.IndexByte(" \t\r\n", c) >= 0 }
+	start, end = index, index
+	for start > cmd.ArgsOffset && !isSpace(src[start-1]) {
+		start--
+	}
+	for end < len(src) && !isSpace(src[end]) {
+		end++
+	}
+	for _, name := range rn.VarNames() {
+		if strings.HasPrefix(name, src[start:index]) {
+			matches = append(matches, name)
+		}
+	}
+	return matches, start, end
+}
+
+func init() {
+	mustRegisterMagic(newTimeMagic("time", "LgoTime", "Runs the statement once and shows the wall time, the CPU time and allocations. Usage: %time statement"))
+	mustRegisterMagic(newTimeMagic("timeit", "LgoTimeit", "Runs the statement repeatedly like testing.Benchmark and shows time/op and allocs/op. Usage: %timeit statement"))
+	mustRegisterMagic(&Magic{
+		Name:  "lsmagic",
+		Usage: "Lists magic commands.",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			if err := noArgs(cmd); err != nil {
+				return err
+			}
+			for _, name := range MagicNames() {
+				fmt.Printf("%s: %s\n", name, magics[name].Usage)
+			}
+			return nil
+		},
+	})
+	mustRegisterMagic(&Magic{
+		Name:  "who",
+		Usage: "Lists the names of variables.",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			if err := noArgs(cmd); err != nil {
+				return err
+			}
+			names := rn.VarNames()
+			if len(names) == 0 {
+				fmt.Println("No variables are defined")
+				return nil
+			}
+			fmt.Println(strings.Join(names, "\t"))
+			return nil
+		},
+	})
+	mustRegisterMagic(&Magic{
+		Name:  "whos",
+		Usage: "Shows a table of variables with their types, approximate sizes of memory and values.",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			if err := noArgs(cmd); err != nil {
+				return err
+			}
+			return rn.Whos(ctx)
+		},
+	})
+	mustRegisterMagic(&Magic{
+		Name:  strings.TrimPrefix(deleteVarsCommand, magic.Prefix),
+		Usage: "Clears variables with zero values to release memory held by them and removes them from the session. Usage: %del name...",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			names := strings.Fields(cmd.Args)
+			if len(names) == 0 {
+				return fmt.Errorf("usage: %s name...", cmd)
+			}
+			return rn.DeleteVars(ctx, names)
+		},
+		Complete: completeVarNames,
+	})
+	mustRegisterMagic(&Magic{
+		Name:  strings.TrimPrefix(RestartWorkerCommand, magic.Prefix),
+		Usage: "Restarts the worker process (--worker). Variables are reset to zero values.",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			if err := noArgs(cmd); err != nil {
+				return err
+			}
+			if err := rn.RestartWorker(ctx); err != nil {
+				return err
+			}
+			fmt.Println("The worker process was restarted")
+			return nil
+		},
+	})
+	mustRegisterMagic(&Magic{
+		Name:  strings.TrimPrefix(SaveSessionCommand, magic.Prefix),
+		Usage: "Saves the snapshot of the session to dir ($LGOPATH/snapshots/default by default). Usage: " + SaveSessionCommand + " [dir]",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			dir, err := rn.snapshotDir(cmd)
+			if err != nil {
+				return err
+			}
+			if err := rn.SaveSession(ctx, dir); err != nil {
+				return err
+			}
+			fmt.Printf("The session was saved to %s\n", dir)
+			return nil
+		},
+	})
+	mustRegisterMagic(&Magic{
+		Name:  strings.TrimPrefix(RestoreSessionCommand, magic.Prefix),
+		Usage: "Restores the snapshot of a session saved by " + SaveSessionCommand + ". Usage: " + RestoreSessionCommand + " [dir]",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			dir, err := rn.snapshotDir(cmd)
+			if err != nil {
+				return err
+			}
+			if err := rn.RestoreSession(ctx, dir); err != nil {
+				return err
+			}
+			fmt.Printf("The session was restored from %s\n", dir)
+			return nil
+		},
+	})
+	mustRegisterMagic(&Magic{
+		Name:  strings.TrimPrefix(PackageCommand, magic.Prefix+magic.Prefix),
+		Cell:  true,
+		Usage: "Defines a package with the source in the cell. Usage: " + PackageCommand + " path",
+		Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+			if err := rn.DefinePackage(ctx, cmd.Args, cmd.Body); err != nil {
+				return err
+			}
+			fmt.Printf("%s was installed\n", cmd.Args)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"context"
+	"go/types"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/magic"
+)
+
+func TestRegisterMagic(t *testing.T) {
+	run := func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error { return nil }
+	defer delete(magics, "%testmagic")
+	if err := RegisterMagic(&Magic{Name: "testmagic", Run: run}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Magic{
+		{Name: "testmagic", Run: run},
+		{Name: "test-magic", Run: run},
+		{Name: "", Run: run},
+		{Name: "nofunc"},
+	} {
+		if err := RegisterMagic(m); err == nil {
+			t.Errorf("RegisterMagic(%q) succeeded unexpectedly", m.Name)
+		}
+	}
+	// The same name is available for a cell magic.
+	defer delete(magics, "%%testmagic")
+	if err := RegisterMagic(&Magic{Name: "testmagic", Cell: true, Run: run}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunMagicLabel(t *testing.T) {
+	var label string
+	defer delete(magics, "%testlabel")
+	mustRegisterMagic(&Magic{Name: "testlabel", Run: func(ctx core.LgoContext, rn *LgoRunner, cmd *magic.Command) error {
+		label = rn.magicLabel
+		return nil
+	}})
+	rn := &LgoRunner{}
+	rn.SetCellLabel("In[7]")
+	cmd, _ := magic.Parse("%testlabel")
+	if err := rn.RunMagic(core.LgoContext{}, cmd); err != nil {
+		t.Fatal(err)
+	}
+	if label != "In[7]" {
+		t.Errorf("Got %q; want In[7]", label)
+	}
+	// The label is not used by later cells.
+	if rn.nextLabel != "" || rn.magicLabel != "" {
+		t.Errorf("The label remains: %q, %q", rn.nextLabel, rn.magicLabel)
+	}
+}
+
+func TestCompleteMagic(t *testing.T) {
+	rn := &LgoRunner{}
+	tests := []struct {
+		src        string
+		index      int
+		matches    []string
+		start, end int
+	}{
+		{"%res", 4, []string{"%restart_worker", "%restore_session"}, 0, 4},
+		{"%save_session /tmp", 3, []string{"%save_session"}, 0, 13},
+		{"%%pa", 4, []string{"%%package"}, 0, 4},
+		{"%ti x", 3, []string{"%time", "%timeit"}, 0, 3},
+		{"%save_session /tmp", 18, nil, 0, 0},
+	}
+	for _, tc := range tests {
+		cmd, _ := magic.Parse(tc.src)
+		matches, start, end := rn.completeMagic(context.Background(), tc.src, cmd, tc.index)
+		if !reflect.DeepEqual(matches, tc.matches) || start != tc.start || end != tc.end {
+			t.Errorf("completeMagic(%q, %d) = %v, %d, %d; want %v, %d, %d", tc.src, tc.index, matches, start, end, tc.matches, tc.start, tc.end)
+		}
+	}
+}
+
+func TestCompleteVarNames(t *testing.T) {
+	rn := NewLgoRunner("/lgopath", &SessionID{})
+	pkg := types.NewPackage(rn.sessDir()+"/exec1", "exec1")
+	for _, name := range []string{"LgoExport_data", "LgoExport_db", "LgoExport_x"} {
+		rn.vars[name] = types.NewVar(0, pkg, name, types.Typ[types.Int])
+	}
+	tests := []struct {
+		src        string
+		index      int
+		matches    []string
+		start, end int
+	}{
+		{"%del d", 6, []string{"data", "db"}, 5, 6},
+		{"%del x da", 9, []string{"data"}, 7, 9},
+		{"%del dat x", 6, []string{"data", "db"}, 5, 8},
+		{"%del ", 5, []string{"data", "db", "x"}, 5, 5},
+	}
+	for _, tc := range tests {
+		cmd, _ := magic.Parse(tc.src)
+		matches, start, end := rn.completeMagic(context.Background(), tc.src, cmd, tc.index)
+		if !reflect.DeepEqual(matches, tc.matches) || start != tc.start || end != tc.end {
+			t.Errorf("completeMagic(%q, %d) = %v, %d, %d; want %v, %d, %d", tc.src, tc.index, matches, start, end, tc.matches, tc.start, tc.end)
+		}
+	}
+}
+
+func TestSnapshotDir(t *testing.T) {
+	rn := &LgoRunner{lgopath: "/lgo"}
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{"%save_session", "/lgo/snapshots/default"},
+		{"%save_session /tmp/s", "/tmp/s"},
+	} {
+		cmd, _ := magic.Parse(tc.src)
+		got, err := rn.snapshotDir(cmd)
+		if err != nil || got != tc.want {
+			t.Errorf("snapshotDir(%q) = %q, %v; want %q", tc.src, got, err, tc.want)
+		}
+	}
+	cmd, _ := magic.Parse("%save_session a b")
+	if _, err := rn.snapshotDir(cmd); err == nil {
+		t.Error("snapshotDir succeeded with two args")
+	}
+}
@@ -0,0 +1,5 @@
+// +build !race
+
+package runner
+
+const raceEnabled = false
@@ -0,0 +1,7 @@
+// +build lgoplugin
+
+package runner
+
+// The shared executor is excluded from lgo built with lgoplugin tag
+// because it depends on unexported functions in runtime package, which recent Go linkers reject.
+const defaultExecutorName = "plugin"
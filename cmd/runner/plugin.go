@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"plugin"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+)
+
+func init() {
+	registerExecutor("plugin", func(rn *LgoRunner) Executor { return &pluginExecutor{rn} })
+}
+
+// pluginMainDir is the name of the directory of the main package to build a plugin from a lgo package.
+const pluginMainDir = "lgoplugin"
+
+// pluginEntryName is the exported name of the entry point in plugins.
+const pluginEntryName = "LgoInit"
+
+// pluginExecutor builds packages with -buildmode=plugin and loads them with the plugin package.
+// Unlike sharedExecutor, this executor works with the standard go toolchain and does not need .so files of dependencies.
+//
+// A plugin is built from a main package generated in the lgoplugin directory of each lgo package
+// because plugins export only symbols in their main packages.
+// Packages of the previous executions linked to a plugin are shared with the plugins loaded before.
+type pluginExecutor struct {
+	rn *LgoRunner
+}
+
+func pluginFileName(pkgPath string) string {
+	return "lib" + strings.Replace(pkgPath, "/", "-", -1) + ".plugin.so"
+}
+
+// pluginMainSrc returns the source of the main package of the plugin of pkg.
+// The main package exports lgo_init of pkg as LgoInit with go:linkname.
+func pluginMainSrc(pkg *Package) string {
+	if !pkg.HasEntry {
+		return fmt.Sprintf("package main\n\nimport _ %q\n", pkg.Path)
+	}
+	return fmt.Sprintf(`package main
+
+import (
+	_ %q
+	_ "unsafe" // for go:linkname
+)
+
+//go:linkname lgoInit %s.lgo_init
+func lgoInit()
+
+// %s is the entry point of the plugin.
+func %s() {
+	lgoInit()
+}
+`, pkg.Path, pkg.Path, pluginEntryName, pluginEntryName)
+}
+
+func (e *pluginExecutor) Build(ctx context.Context, pkg *Package) error {
+	mainPath := path.Join(pkg.Path, pluginMainDir)
+	mainDir := path.Join(build.Default.GOPATH, "src", mainPath)
+	if err := os.MkdirAll(mainDir, 0766); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(mainDir, "main.go"), []byte(pluginMainSrc(pkg)), 0666); err != nil {
+		return err
+	}
+	// A function declaration without a body needs an assembly file in the package.
+	if err := ioutil.WriteFile(path.Join(mainDir, "empty.s"), nil, 0666); err != nil {
+		return err
+	}
+	args := []string{"build", "-buildmode=plugin"}
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	args = append(args, "-o", path.Join(e.rn.lgopath, "pkg", pluginFileName(pkg.Path)), mainPath)
+	cmd := e.rn.goCommand(ctx, args...)
+	stderr := newBuildOutputWriter(os.Stderr, pkg.Path)
+	cmd.Stderr = stderr
+	cmd.Stdout = os.Stdout
+	err := cmd.Run()
+	stderr.Flush()
+	if err != nil {
+		return fmt.Errorf("Failed to build a plugin of %s: %v", pkg.Path, err)
+	}
+	return nil
+}
+
+func (e *pluginExecutor) Load(ctx core.LgoContext, pkg *Package) error {
+	p, err := plugin.Open(path.Join(e.rn.lgopath, "pkg", pluginFileName(pkg.Path)))
+	if err != nil {
+		return fmt.Errorf("Failed to load a plugin of %s: %v", pkg.Path, err)
+	}
+	if !pkg.HasEntry {
+		return nil
+	}
+	sym, err := p.Lookup(pluginEntryName)
+	if err != nil {
+		return err
+	}
+	entry, ok := sym.(func())
+	if !ok {
+		return fmt.Errorf("%s of %s has an unexpected type: %T", pluginEntryName, pkg.Path, sym)
+	}
+	return core.ExecLgoEntryPoint(ctx, entry)
+}
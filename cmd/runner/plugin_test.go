@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+type recordPrinter struct {
+	lines []interface{}
+}
+
+func (p *recordPrinter) Println(args ...interface{}) {
+	p.lines = append(p.lines, args...)
+}
+
+func writePkgSrc(t *testing.T, pkgPath, src string) {
+	dir := path.Join(build.Default.GOPATH, "src", pkgPath)
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "src.go"), []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPluginExecutor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Building plugins is slow")
+	}
+	lgopath, err := ioutil.TempDir("", "lgo_plugin_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lgopath)
+	sessID := NewSessionID()
+	defer CleanSession(lgopath, sessID)
+	rn := NewLgoRunner(lgopath, sessID)
+	if err := rn.UseExecutor("plugin"); err != nil {
+		t.Fatal(err)
+	}
+	p := &recordPrinter{}
+	core.RegisterLgoPrinter(p)
+	defer core.UnregisterLgoPrinter(p)
+
+	exec1 := path.Join(rn.sessDir(), "exec1")
+	writePkgSrc(t, exec1, `package exec1
+
+import "github.com/yunabe/lgo/core"
+
+var X int
+
+func lgo_init() {
+	X = 10
+	core.LgoPrintln(X)
+}
+`)
+	// exec2 has no entry point.
+	exec2 := path.Join(rn.sessDir(), "exec2")
+	writePkgSrc(t, exec2, `package exec2
+
+func Double(x int) int {
+	return x * 2
+}
+`)
+	exec3 := path.Join(rn.sessDir(), "exec3")
+	writePkgSrc(t, exec3, `package exec3
+
+import (
+	"github.com/yunabe/lgo/core"
+	exec1 "`+exec1+`"
+	exec2 "`+exec2+`"
+)
+
+func lgo_init() {
+	exec1.X = exec2.Double(exec1.X)
+	core.LgoPrintln(exec1.X)
+}
+`)
+	ctx := core.LgoContext{Context: context.Background()}
+	for _, pkg := range []*Package{
+		{Path: exec1, HasEntry: true},
+		{Path: exec2},
+		{Path: exec3, HasEntry: true},
+	} {
+		if err := rn.executor.Build(ctx, pkg); err != nil {
+			t.Fatal(err)
+		}
+		if err := rn.executor.Load(ctx, pkg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// exec3 prints 20 only if exec1.X is shared between the plugins of exec1 and exec3.
+	want := []interface{}{10, 20}
+	if !reflect.DeepEqual(p.lines, want) {
+		t.Errorf("Got %v; want %v", p.lines, want)
+	}
+}
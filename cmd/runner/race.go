@@ -0,0 +1,7 @@
+// +build race
+
+package runner
+
+// raceEnabled is true if this binary is built with -race.
+// Plugins must be built with -race to be loaded into the binary.
+const raceEnabled = true
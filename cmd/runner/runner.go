@@ -3,6 +3,7 @@ package runner
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"go/build"
 	"go/scanner"
@@ -14,79 +15,164 @@ import (
 	"os/exec"
 	"path"
 	"strings"
-	"unsafe"
+	"sync"
 
 	"github.com/yunabe/lgo/cmd/install"
 	"github.com/yunabe/lgo/converter"
 	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/magic"
 )
 
-/*
-#cgo linux LDFLAGS: -ldl
-#include <dlfcn.h>
-*/
-import "C"
-
-func loadShared(ctx core.LgoContext, buildPkgDir, pkgPath string) error {
-	// This code is implemented based on https://golang.org/src/plugin/plugin_dlopen.go
-	sofile := "lib" + strings.Replace(pkgPath, "/", "-", -1) + ".so"
-	handle := C.dlopen(C.CString(path.Join(buildPkgDir, sofile)), C.RTLD_NOW|C.RTLD_GLOBAL)
-	if handle == nil {
-		panic("Failed to open shared object.")
-	}
-
-	// Initialize freshly loaded modules
-	// c.f. plugin_lastmoduleinit in https://golang.org/src/runtime/plugin.go
-	modulesinit()
-	typelinksinit()
-	itabsinit()
-
-	// Don't forget to call init.
-	// TODO: Write unit tests to confirm this.
-	initFuncPC := C.dlsym(handle, C.CString(pkgPath+".init"))
-	if initFuncPC != nil {
-		// Note: init does not exist if the library does not use external libraries.
-		initFuncP := &initFuncPC
-		initFunc := *(*func())(unsafe.Pointer(&initFuncP))
-		initFunc()
-	}
-
-	lgoInitFuncPC := C.dlsym(handle, C.CString(pkgPath+".lgo_init"))
-	if lgoInitFuncPC == nil {
-		// lgo_init does not exist if lgo source includes only declarations.
-		return nil
-	}
-	lgoInitFuncP := &lgoInitFuncPC
-	lgoInitFunc := *(*func())(unsafe.Pointer(&lgoInitFuncP))
-	return core.ExecLgoEntryPoint(ctx, func() {
-		lgoInitFunc()
-	})
-}
-
-func loadSharedInternal(buildPkgDir, pkgPath string) {
-}
-
 type LgoRunner struct {
 	lgopath   string
 	sessID    *SessionID
 	execCount int64
-	vars      map[string]types.Object
-	imports   map[string]*types.PkgName
+
+	// mu protects vars, imports and decls.
+	// vars and imports are updated only in Run but they are read from other goroutines (e.g. Complete and Inspect).
+	mu      sync.Mutex
+	vars    map[string]types.Object
+	imports map[string]*types.PkgName
+	// decls is the snapshot of vars and imports.
+	decls *declSnapshot
+
+	// cells is the list of the sources of cells with declarations. It is saved to snapshots of the session.
+	cells []string
+	// mod is the module of the session. mod is nil in GOPATH mode.
+	mod *sessionModule
+	// executor builds and executes packages converted from lgo code.
+	executor     Executor
+	executorName string
+	// cache caches packages built from cells to skip builds when unchanged cells are executed again.
+	cache *buildCache
+	// nextLabel is the label of the next cell. See SetCellLabel.
+	nextLabel string
+	// magicLabel is the label of the cell of the running magic command.
+	// Magic commands which run code in the cell (e.g. %time) use it in stack traces.
+	magicLabel string
+	// userPkgs maps packages defined with PackageCommand to their imports.
+	userPkgs map[string][]string
+	// loadedUserPkgs are packages in userPkgs loaded by cells. They can not be redefined.
+	loadedUserPkgs map[string]bool
 }
 
 func NewLgoRunner(lgopath string, sessID *SessionID) *LgoRunner {
-	return &LgoRunner{
+	rn := &LgoRunner{
 		lgopath: lgopath,
 		sessID:  sessID,
 		vars:    make(map[string]types.Object),
 		imports: make(map[string]*types.PkgName),
+		decls:   &declSnapshot{},
+		cache:   newBuildCache(),
+
+		userPkgs:       make(map[string][]string),
+		loadedUserPkgs: make(map[string]bool),
 	}
+	rn.executor = executors[DefaultExecutorName](rn)
+	rn.executorName = DefaultExecutorName
+	core.SetStackFormatter(FormatStack)
+	return rn
+}
+
+// SetCellLabel sets the label of the cell executed by the next Run or RunMagic. The label is shown in stack traces of panics.
+// If the label is not set, In[N] is used where N is the number of cells executed in the session.
+func (rn *LgoRunner) SetCellLabel(label string) {
+	rn.nextLabel = label
+}
+
+// declSnapshot is an immutable snapshot of declarations in the session.
+// Snapshots are passed to the converter so that the converter does not access vars and imports of LgoRunner
+// while they are updated by the execution of a cell in another goroutine.
+type declSnapshot struct {
+	olds       []types.Object
+	oldImports []*types.PkgName
+}
+
+// snapshot returns the snapshot of declarations in the session.
+func (rn *LgoRunner) snapshot() *declSnapshot {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return rn.decls
+}
+
+// updateSnapshotLocked updates the snapshot of declarations with vars and imports. rn.mu must be held.
+func (rn *LgoRunner) updateSnapshotLocked() {
+	decls := &declSnapshot{}
+	for _, obj := range rn.vars {
+		decls.olds = append(decls.olds, obj)
+	}
+	for _, im := range rn.imports {
+		decls.oldImports = append(decls.oldImports, im)
+	}
+	rn.decls = decls
 }
 
 func (rn *LgoRunner) ExecCount() int64 {
 	return rn.execCount
 }
 
+// sessDir returns the package path of the directory where packages of the session are stored.
+func (rn *LgoRunner) sessDir() string {
+	return "github.com/yunabe/lgo/" + rn.sessID.Marshal()
+}
+
+// UseModule switches rn to module mode. Packages used in lgo code are resolved with go.mod of the session.
+// If base is not empty, go.mod of the session inherits requirements and replacements from base (a path to go.mod).
+func (rn *LgoRunner) UseModule(base string) error {
+	sessDir := rn.sessDir()
+	mod, err := newSessionModule(rn.lgopath, path.Join(build.Default.GOPATH, "src", sessDir), sessDir, base)
+	if err != nil {
+		return fmt.Errorf("failed to create go.mod of the session: %v", err)
+	}
+	rn.mod = mod
+	return nil
+}
+
+// ModuleDir returns the root directory of the session module. It returns "" in GOPATH mode.
+func (rn *LgoRunner) ModuleDir() string {
+	if rn.mod == nil {
+		return ""
+	}
+	return rn.mod.dir
+}
+
+var errNotModuleMode = errors.New("lgo is not running in module mode")
+
+// Require adds a requirement of the module path@version to go.mod of the session.
+func (rn *LgoRunner) Require(path, version string) error {
+	if rn.mod == nil {
+		return errNotModuleMode
+	}
+	// Packages built before may depend on other versions of modules.
+	rn.clearBuildCache()
+	return rn.mod.Require(path, version)
+}
+
+// Replace replaces the module old with repl in go.mod of the session.
+// repl is a module path with a version or a path to a local directory.
+func (rn *LgoRunner) Replace(old, repl string) error {
+	if rn.mod == nil {
+		return errNotModuleMode
+	}
+	rn.clearBuildCache()
+	return rn.mod.Replace(old, repl)
+}
+
+// goCommand returns exec.Cmd to run the go command. In module mode, the command runs in the session module.
+func (rn *LgoRunner) goCommand(ctx context.Context, args ...string) *exec.Cmd {
+	if rn.mod != nil {
+		return rn.mod.goCommand(ctx, args...)
+	}
+	return exec.CommandContext(ctx, "go", args...)
+}
+
+func (rn *LgoRunner) newSOInstaller() *install.SOInstaller {
+	if rn.mod != nil {
+		return install.NewModuleSOInstaller(rn.lgopath, rn.mod.dir)
+	}
+	return install.NewSOInstaller(rn.lgopath)
+}
+
 func (rn *LgoRunner) cleanFiles(pkgPath string) {
 	// Delete src files
 	os.RemoveAll(path.Join(build.Default.GOPATH, "src", pkgPath))
@@ -108,6 +194,7 @@ const maxErrLines = 5
 
 // PrintError prints err to w.
 // If err is scanner.ErrorList or convert.ErrorList, it expands internal errors.
+// If err is core.PanicError, it prints the panic with the stack trace.
 func PrintError(w io.Writer, err error) {
 	var length int
 	var get func(int) error
@@ -117,6 +204,10 @@ func PrintError(w io.Writer, err error) {
 	} else if lst, ok := err.(converter.ErrorList); ok {
 		length = len(lst)
 		get = func(i int) error { return lst[i] }
+	} else if p, ok := err.(*core.PanicError); ok {
+		// The stack ends with \n.
+		fmt.Fprintf(w, "panic: %s\n\n%s%s\n", p.Value, p.Stack, p.Message)
+		return
 	} else {
 		fmt.Fprintln(w, err.Error())
 		return
@@ -131,60 +222,106 @@ func PrintError(w io.Writer, err error) {
 }
 
 // installDeps installs .so files for dependencies if .so files are not installed in $LGOPATH.
-func (rn *LgoRunner) installDeps(deps []string) error {
-	var need []string
-	for _, path := range deps {
-		if path == "C" || install.IsStdPkg(path) {
-			continue
-		}
-		if !install.IsSOInstalled(rn.lgopath, path) {
-			need = append(need, path)
-		}
+// In module mode, .so files built from module versions other than the ones in go.mod of the session are reinstalled.
+func (rn *LgoRunner) installDeps(ctx context.Context, deps []string) error {
+	si := rn.newSOInstaller()
+	need, err := si.NotInstalled(deps...)
+	if err != nil {
+		return err
 	}
 	if len(need) == 0 {
 		return nil
 	}
 	fmt.Fprintf(os.Stderr, "found packages not installed in LGOPATH: %v\n", need)
-	return install.NewSOInstaller(rn.lgopath).Install(need...)
+	return si.InstallContext(ctx, need...)
 }
 
 const lgoExportPrefix = "LgoExport_"
 
+// lgoCtypePrefix is the prefix of aliases of C types declared by the converter in cells which import "C".
+const lgoCtypePrefix = "LgoCtype_"
+
 func (rn *LgoRunner) Run(ctx core.LgoContext, src string) error {
+	label := rn.nextLabel
+	rn.nextLabel = ""
+	return rn.runCell(ctx, src, true, label)
+}
+
+// runCell converts src to a package and loads it. If runEntry is false, only declarations in src are loaded.
+// If the package converted from the same src with the same referred declarations was built before,
+// the package is loaded again without building it. src is converted and type-checked even in that case.
+// label is the label of the cell in stack traces. If it is empty, In[N] is used.
+// Cells with declarations are recorded to cells so that sessions can be restored from them.
+func (rn *LgoRunner) runCell(ctx core.LgoContext, src string, runEntry bool, label string) error {
 	rn.execCount++
-	sessDir := "github.com/yunabe/lgo/" + rn.sessID.Marshal()
-	pkgPath := path.Join(sessDir, fmt.Sprintf("exec%d", rn.execCount))
-	var olds []types.Object
-	for _, obj := range rn.vars {
-		olds = append(olds, obj)
-	}
-	var oldImports []*types.PkgName
-	for _, im := range rn.imports {
-		oldImports = append(oldImports, im)
+	pkgPath := path.Join(rn.sessDir(), fmt.Sprintf("exec%d", rn.execCount))
+	if label == "" {
+		label = fmt.Sprintf("In[%d]", rn.execCount)
 	}
+	decls := rn.snapshot()
 	result := converter.Convert(src, &converter.Config{
-		Olds:         olds,
-		OldImports:   oldImports,
-		DefPrefix:    lgoExportPrefix,
-		RefPrefix:    lgoExportPrefix,
-		LgoPkgPath:   pkgPath,
-		AutoExitCode: true,
-		RegisterVars: true,
+		Olds:             decls.olds,
+		OldImports:       decls.oldImports,
+		DefPrefix:        lgoExportPrefix,
+		RefPrefix:        lgoExportPrefix,
+		LgoPkgPath:       pkgPath,
+		AutoExitCode:     true,
+		RegisterVars:     true,
+		RedefinableFuncs: true,
+		LineDirectives:   true,
 	})
 	// converted, pkg, _, err
 	if result.Err != nil {
 		return result.Err
 	}
+	rn.mu.Lock()
+	var key string
+	var cached *cachedPackage
+	if len(result.Src) > 0 {
+		// The cache is looked up after src is converted because the key depends on the declarations
+		// which src refers to and they are resolved by type-checking. Thus, only the build is skipped.
+		// Look up the cache before vars are updated with declarations in src.
+		key = buildCacheKey(src, result)
+		cached = rn.cache.lookup(key, rn.vars)
+	}
+	hasDecls := len(result.Imports) > 0
 	for _, name := range result.Pkg.Scope().Names() {
 		rn.vars[name] = result.Pkg.Scope().Lookup(name)
+		if name != converter.LgoInitFuncName {
+			hasDecls = true
+		}
+	}
+	if cached != nil {
+		// Declarations in the cached package are still the latest ones.
+		for _, obj := range cached.defs {
+			rn.vars[obj.Name()] = obj
+		}
 	}
 	for _, im := range result.Imports {
 		rn.imports[im.Name()] = im
 	}
+	rn.updateSnapshotLocked()
+	rn.mu.Unlock()
 	if len(result.Src) == 0 {
 		// No declarations or expressions in the original source (e.g. only import statements).
+		if hasDecls {
+			rn.cells = append(rn.cells, src)
+		}
 		return nil
 	}
+	hasEntry := runEntry && result.Pkg.Scope().Lookup(converter.LgoInitFuncName) != nil
+	if cached != nil {
+		// Reuse the package built from the same cell before.
+		if hasDecls {
+			rn.cells = append(rn.cells, src)
+		}
+		pkg := *cached.pkg
+		pkg.HasEntry = hasEntry
+		pkg.Label = label
+		setCellLabel(pkg.Path, pkg.Label)
+		rn.markUserPkgsLoaded(pkg.Deps)
+		return rn.executor.Load(ctx, &pkg)
+	}
 	pkgDir := path.Join(build.Default.GOPATH, "src", pkgPath)
 	if err := os.MkdirAll(pkgDir, 0766); err != nil {
 		return err
@@ -194,34 +331,33 @@ func (rn *LgoRunner) Run(ctx core.LgoContext, src string) error {
 	if err != nil {
 		return err
 	}
-	if err := rn.installDeps(result.FinalDeps); err != nil {
+	pkg := &Package{
+		Path:     pkgPath,
+		Deps:     result.FinalDeps,
+		HasEntry: hasEntry,
+		Label:    label,
+	}
+	if err := rn.executor.Build(ctx, pkg); err != nil {
 		return err
 	}
-
-	buildPkgDir := path.Join(rn.lgopath, "pkg")
-	cmd := exec.CommandContext(ctx, "go", "install", "-buildmode=shared", "-linkshared", "-pkgdir", buildPkgDir, pkgPath)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("Failed to build a shared library of %s: %v", pkgPath, err)
+	rn.cache.add(key, pkg, result)
+	if hasDecls {
+		rn.cells = append(rn.cells, src)
 	}
-	return loadShared(ctx, buildPkgDir, pkgPath)
+	setCellLabel(pkg.Path, pkg.Label)
+	rn.markUserPkgsLoaded(pkg.Deps)
+	return rn.executor.Load(ctx, pkg)
 }
 
 func (rn *LgoRunner) Complete(ctx context.Context, src string, index int) (matches []string, start, end int) {
-	var olds []types.Object
-	// TODO: Protect rn.vars and rn.imports with locks to make them goroutine safe.
-	for _, obj := range rn.vars {
-		olds = append(olds, obj)
-	}
-	var oldImports []*types.PkgName
-	for _, im := range rn.imports {
-		oldImports = append(oldImports, im)
+	if cmd, ok := magic.Parse(src); ok {
+		return rn.completeMagic(ctx, src, cmd, index)
 	}
+	// Use the snapshot of declarations because Complete and Inspect are called while a cell is running.
+	decls := rn.snapshot()
 	matches, start, end = converter.Complete(src, token.Pos(index+1), &converter.Config{
-		Olds:       olds,
-		OldImports: oldImports,
+		Olds:       decls.olds,
+		OldImports: decls.oldImports,
 		DefPrefix:  lgoExportPrefix,
 		RefPrefix:  lgoExportPrefix,
 	})
@@ -230,18 +366,14 @@ func (rn *LgoRunner) Complete(ctx context.Context, src string, index int) (match
 
 // Inspect analyzes src and returns the document of an identifier at index (0-based).
 func (rn *LgoRunner) Inspect(ctx context.Context, src string, index int) (string, error) {
-	var olds []types.Object
-	// TODO: Protect rn.vars and rn.imports with locks to make them goroutine safe.
-	for _, obj := range rn.vars {
-		olds = append(olds, obj)
-	}
-	var oldImports []*types.PkgName
-	for _, im := range rn.imports {
-		oldImports = append(oldImports, im)
+	if cmd, ok := magic.Parse(src); ok {
+		return inspectMagic(cmd), nil
 	}
+	// Use the snapshot of declarations because Complete and Inspect are called while a cell is running.
+	decls := rn.snapshot()
 	doc, query := converter.InspectIdent(src, token.Pos(index+1), &converter.Config{
-		Olds:       olds,
-		OldImports: oldImports,
+		Olds:       decls.olds,
+		OldImports: decls.oldImports,
 		DefPrefix:  lgoExportPrefix,
 		RefPrefix:  lgoExportPrefix,
 	})
@@ -251,7 +383,7 @@ func (rn *LgoRunner) Inspect(ctx context.Context, src string, index int) (string
 	if query == "" {
 		return "", nil
 	}
-	cmd := exec.CommandContext(ctx, "go", "doc", query)
+	cmd := rn.goCommand(ctx, "doc", query)
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	if err := cmd.Run(); err != nil {
@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// TestDeclSnapshot checks snapshots of declarations are consistent while declarations are updated.
+// Run this test with -race to detect data races.
+func TestDeclSnapshot(t *testing.T) {
+	rn := NewLgoRunner("/lgopath", &SessionID{})
+	const n = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			pkg := types.NewPackage(fmt.Sprintf("lgo/exec%d", i), "exec")
+			rn.mu.Lock()
+			rn.vars[fmt.Sprintf("x%d", i)] = types.NewVar(token.NoPos, pkg, fmt.Sprintf("x%d", i), types.Typ[types.Int])
+			rn.imports[fmt.Sprintf("pkg%d", i)] = types.NewPkgName(token.NoPos, pkg, fmt.Sprintf("pkg%d", i), pkg)
+			rn.updateSnapshotLocked()
+			rn.mu.Unlock()
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				decls := rn.snapshot()
+				if len(decls.olds) != len(decls.oldImports) {
+					t.Errorf("Inconsistent snapshot: %d vars and %d imports", len(decls.olds), len(decls.oldImports))
+					return
+				}
+				for _, obj := range decls.olds {
+					_ = obj.Name()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if decls := rn.snapshot(); len(decls.olds) != n {
+		t.Errorf("Got %d vars; want %d", len(decls.olds), n)
+	}
+}
+
+// TestCompleteWhileRunning calls Complete and Inspect while cells are converted, built and loaded.
+// Run this test with -race to detect data races between them.
+func TestCompleteWhileRunning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Building plugins is slow")
+	}
+	// The converter imports core with the default importer.
+	// CI sets LGO_TEST_REQUIRE_CORE so that this test is not skipped silently there.
+	if _, err := importer.Default().Import(core.SelfPkgPath); err != nil {
+		if os.Getenv("LGO_TEST_REQUIRE_CORE") != "" {
+			t.Fatalf("core is not installed: %v", err)
+		}
+		t.Skipf("core is not installed: %v", err)
+	}
+	lgopath, err := ioutil.TempDir("", "lgo_runner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lgopath)
+	sessID := NewSessionID()
+	defer CleanSession(lgopath, sessID)
+	rn := NewLgoRunner(lgopath, sessID)
+	if err := rn.UseExecutor("plugin"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := core.LgoContext{Context: context.Background()}
+	if err := rn.Run(ctx, "// myFunc returns x.\nfunc myFunc() int { return x }\nvar x = 10"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			src := "myF"
+			matches, _, _ := rn.Complete(context.Background(), src, len(src))
+			if len(matches) == 0 || matches[0] != "myFunc" {
+				t.Errorf("Unexpected matches: %v", matches)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			doc, err := rn.Inspect(context.Background(), "myFunc()", 1)
+			if err != nil || !strings.Contains(doc, "myFunc") {
+				t.Errorf("Unexpected doc: %q, %v", doc, err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if err := rn.Run(ctx, fmt.Sprintf("y%d := myFunc() + %d", i, i)); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	if names := rn.VarNames(); len(names) != 4 {
+		t.Errorf("Unexpected variables: %v", names)
+	}
+}
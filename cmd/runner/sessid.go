@@ -4,7 +4,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -12,10 +14,41 @@ const idPrefix = "sess"
 
 type SessionID struct {
 	Time int64 `json:"time"`
+	// PID is the ID of the process which owns the session.
+	// PID is 0 in sessions created by old versions of lgo.
+	PID int `json:"pid,omitempty"`
 }
 
 func NewSessionID() *SessionID {
-	return &SessionID{time.Now().UnixNano()}
+	return &SessionID{Time: time.Now().UnixNano(), PID: os.Getpid()}
+}
+
+// StartTime returns the time when the session was created.
+func (s *SessionID) StartTime() time.Time {
+	return time.Unix(0, s.Time)
+}
+
+// pidReuseMargin is the error of the start times of processes allowed in IsAlive.
+// Start times computed from /proc are not exact because the boot time is truncated to seconds
+// and the clock may be adjusted after the boot.
+const pidReuseMargin = time.Second
+
+// IsAlive returns whether the process which owns the session is running.
+// IsAlive returns false if the owner of the session is unknown.
+// If the process with PID started after the session was created, it is not the owner but a process which reused PID.
+func (s *SessionID) IsAlive() bool {
+	if s.PID <= 0 {
+		return false
+	}
+	err := syscall.Kill(s.PID, 0)
+	// EPERM means the process exists but it is owned by another user.
+	if err != nil && err != syscall.EPERM {
+		return false
+	}
+	if start, ok := processStartTime(s.PID); ok && start.After(s.StartTime().Add(pidReuseMargin)) {
+		return false
+	}
+	return true
 }
 
 func (s *SessionID) Marshal() string {
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the unit of the start time in /proc/<pid>/stat (sysconf(_SC_CLK_TCK)).
+// It is 100 on Linux except for very old kernels.
+const clockTicks = 100
+
+// processStartTime returns when the process pid started. It reads the start time from /proc/<pid>/stat and
+// the boot time from /proc/stat. ok is false if the start time is not available.
+func processStartTime(pid int) (start time.Time, ok bool) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, false
+	}
+	ticks, err := parseStartTicks(string(b))
+	if err != nil {
+		return time.Time{}, false
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicks), true
+}
+
+// parseStartTicks returns starttime (the 22nd field) in the content of /proc/<pid>/stat.
+func parseStartTicks(stat string) (int64, error) {
+	// The 2nd field is the name of the command in parentheses, which may contain spaces and parentheses.
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return 0, fmt.Errorf("unexpected stat: %q", stat)
+	}
+	// fields[0] is the 3rd field.
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("unexpected stat: %q", stat)
+	}
+	return strconv.ParseInt(fields[19], 10, 64)
+}
+
+// bootTime returns the boot time of the system in /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if !bytes.HasPrefix(line, []byte("btime ")) {
+			continue
+		}
+		sec, err := strconv.ParseInt(string(bytes.TrimSpace(line[len("btime "):])), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(sec, 0), nil
+	}
+	if err := sc.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("btime is not found in /proc/stat")
+}
@@ -0,0 +1,34 @@
+package runner
+
+import "testing"
+
+func TestParseStartTicks(t *testing.T) {
+	tests := []struct {
+		stat string
+		want int64
+		err  bool
+	}{
+		{
+			stat: "1234 (lgo-internal) S 1 1234 1234 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 8 0 56789 1000 200 18446744073709551615",
+			want: 56789,
+		}, {
+			// The command name contains spaces and parentheses.
+			stat: "1234 (a) b (c) S 1 1234 1234 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 8 0 42 1000 200",
+			want: 42,
+		},
+		{stat: "1234 (lgo) S 1", err: true},
+		{stat: "1234 lgo S", err: true},
+	}
+	for _, tc := range tests {
+		got, err := parseStartTicks(tc.stat)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseStartTicks(%q) succeeded unexpectedly", tc.stat)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseStartTicks(%q) = %d, %v; want %d", tc.stat, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package runner
+
+import "time"
+
+// The start times of processes are not available on this platform. IsAlive only checks that the process exists.
+func processStartTime(pid int) (start time.Time, ok bool) {
+	return time.Time{}, false
+}
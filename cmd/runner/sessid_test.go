@@ -2,7 +2,9 @@ package runner
 
 import (
 	"encoding/json"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestSessID_marshalJSON(t *testing.T) {
@@ -39,3 +41,22 @@ func TestSessID_marshal(t *testing.T) {
 		t.Errorf("Expected %d but got %d", expTime, newID.Time)
 	}
 }
+
+func TestSessID_isAlivePIDReused(t *testing.T) {
+	pid := os.Getpid()
+	if !NewSessionID().IsAlive() {
+		t.Error("The session of the current process is not alive")
+	}
+	start, ok := processStartTime(pid)
+	if !ok {
+		t.Skip("The start times of processes are not available")
+	}
+	if now := time.Now(); start.After(now) || start.Before(now.Add(-time.Hour)) {
+		t.Errorf("Unexpected start time: %v (now: %v)", start, now)
+	}
+	// The process with pid started after the session was created. It is not the owner of the session.
+	old := &SessionID{Time: start.Add(-time.Minute).UnixNano(), PID: pid}
+	if old.IsAlive() {
+		t.Error("The session whose PID was reused is alive")
+	}
+}
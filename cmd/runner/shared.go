@@ -0,0 +1,107 @@
+// +build !lgoplugin
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"unsafe"
+
+	"github.com/yunabe/lgo/core"
+)
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+*/
+import "C"
+
+// loadShared loads the shared library of pkgPath. If runEntry is false, lgo_init of the package is not executed.
+func loadShared(ctx core.LgoContext, buildPkgDir, pkgPath string, runEntry bool) error {
+	// This code is implemented based on https://golang.org/src/plugin/plugin_dlopen.go
+	sofile := "lib" + strings.Replace(pkgPath, "/", "-", -1) + ".so"
+	handle := C.dlopen(C.CString(path.Join(buildPkgDir, sofile)), C.RTLD_NOW|C.RTLD_GLOBAL)
+	if handle == nil {
+		panic("Failed to open shared object.")
+	}
+
+	// Initialize freshly loaded modules
+	// c.f. plugin_lastmoduleinit in https://golang.org/src/runtime/plugin.go
+	modulesinit()
+	typelinksinit()
+	itabsinit()
+
+	// Don't forget to call init.
+	// TODO: Write unit tests to confirm this.
+	initFuncPC := C.dlsym(handle, C.CString(pkgPath+".init"))
+	if initFuncPC != nil {
+		// Note: init does not exist if the library does not use external libraries.
+		initFuncP := &initFuncPC
+		initFunc := *(*func())(unsafe.Pointer(&initFuncP))
+		initFunc()
+	}
+
+	if !runEntry {
+		return nil
+	}
+	lgoInitFuncPC := C.dlsym(handle, C.CString(pkgPath+".lgo_init"))
+	if lgoInitFuncPC == nil {
+		// lgo_init does not exist if lgo source includes only declarations.
+		return nil
+	}
+	lgoInitFuncP := &lgoInitFuncPC
+	lgoInitFunc := *(*func())(unsafe.Pointer(&lgoInitFuncP))
+	return core.ExecLgoEntryPoint(ctx, func() {
+		lgoInitFunc()
+	})
+}
+
+func loadSharedInternal(buildPkgDir, pkgPath string) {
+}
+
+const defaultExecutorName = "shared"
+
+func init() {
+	registerExecutor("shared", func(rn *LgoRunner) Executor { return &sharedExecutor{rn: rn} })
+}
+
+// sharedExecutor builds packages with -buildmode=shared and loads them with dlopen.
+// This executor requires lgo-internal and dependencies built with -linkshared.
+type sharedExecutor struct {
+	rn *LgoRunner
+	// cfg is the import config of $LGOPATH/pkg to build packages without the go command.
+	cfg *importConfig
+}
+
+func (e *sharedExecutor) Build(ctx context.Context, pkg *Package) error {
+	if err := e.rn.installDeps(ctx, pkg.Deps); err != nil {
+		return err
+	}
+	if canBuildDirectly(pkg) {
+		if cfg := e.importConfig(pkg.Deps); cfg != nil {
+			return buildDirectly(ctx, cfg, pkg)
+		}
+	}
+	return e.goInstall(ctx, pkg)
+}
+
+// goInstall builds pkg with go install. It is slower than buildDirectly but it works with any packages.
+func (e *sharedExecutor) goInstall(ctx context.Context, pkg *Package) error {
+	cmd := e.rn.goCommand(ctx, "install", "-buildmode=shared", "-linkshared", "-pkgdir", path.Join(e.rn.lgopath, "pkg"), pkg.Path)
+	stderr := newBuildOutputWriter(os.Stderr, pkg.Path)
+	cmd.Stderr = stderr
+	cmd.Stdout = os.Stdout
+	err := cmd.Run()
+	stderr.Flush()
+	if err != nil {
+		return fmt.Errorf("Failed to build a shared library of %s: %v", pkg.Path, err)
+	}
+	return nil
+}
+
+func (e *sharedExecutor) Load(ctx core.LgoContext, pkg *Package) error {
+	return loadShared(ctx, path.Join(e.rn.lgopath, "pkg"), pkg.Path, pkg.HasEntry)
+}
@@ -0,0 +1,141 @@
+// +build !lgoplugin
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	toolDirOnce sync.Once
+	toolDir     string
+	toolDirErr  error
+)
+
+// goToolDir returns the directory of compile and link of the go command.
+func goToolDir() (string, error) {
+	toolDirOnce.Do(func() {
+		out, err := exec.Command("go", "env", "GOTOOLDIR").Output()
+		if err != nil {
+			toolDirErr = fmt.Errorf("failed to get GOTOOLDIR: %v", err)
+			return
+		}
+		toolDir = string(bytes.TrimSpace(out))
+	})
+	return toolDir, toolDirErr
+}
+
+// importConfig returns the import config of $LGOPATH/pkg which has all deps.
+// It returns nil if deps are not installed as shared libraries or the config is not available.
+func (e *sharedExecutor) importConfig(deps []string) *importConfig {
+	if e.cfg == nil {
+		cfg := newImportConfig(path.Join(e.rn.lgopath, "pkg"))
+		if err := cfg.load(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil
+		}
+		e.cfg = cfg
+	}
+	if len(e.cfg.missing(deps)) == 0 {
+		return e.cfg
+	}
+	// Packages may be installed by the go command after the config was loaded.
+	if err := e.cfg.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	if len(e.cfg.missing(deps)) > 0 {
+		return nil
+	}
+	return e.cfg
+}
+
+// canBuildDirectly returns true if pkg can be built by running compile and link directly.
+func canBuildDirectly(pkg *Package) bool {
+	for _, dep := range pkg.Deps {
+		if dep == "C" {
+			// cgo needs the go command.
+			return false
+		}
+	}
+	return true
+}
+
+// buildDirectly builds the shared library of pkg by running compile and link with cfg.
+// This is much faster than go install because the go command does not need to plan the build of all dependencies.
+func buildDirectly(ctx context.Context, cfg *importConfig, pkg *Package) error {
+	tools, err := goToolDir()
+	if err != nil {
+		return err
+	}
+	// Create the work directory in pkgDir to move outputs with os.Rename.
+	work, err := ioutil.TempDir(cfg.pkgDir, ".lgobuild")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	compileCfg := filepath.Join(work, "importcfg")
+	if err := ioutil.WriteFile(compileCfg, []byte(cfg.compileConfig()), 0666); err != nil {
+		return err
+	}
+	linkCfg := filepath.Join(work, "importcfg.link")
+	if err := ioutil.WriteFile(linkCfg, []byte(cfg.linkConfig()), 0666); err != nil {
+		return err
+	}
+
+	archive := filepath.Join(work, "_pkg_.a")
+	args := []string{"-o", archive, "-p", pkg.Path, "-dynlink", "-linkshared", "-installsuffix", "dynlink", "-nolocalimports", "-importcfg", compileCfg, "-pack"}
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	srcDir := path.Join(build.Default.GOPATH, "src", pkg.Path)
+	files, err := filepath.Glob(filepath.Join(srcDir, "*.go"))
+	if err != nil {
+		return err
+	}
+	if err := runTool(ctx, pkg.Path, filepath.Join(tools, "compile"), append(args, files...)...); err != nil {
+		return fmt.Errorf("Failed to compile %s: %v", pkg.Path, err)
+	}
+
+	lib := "lib" + strings.Replace(pkg.Path, "/", "-", -1) + ".so"
+	// Omit DWARF with -w as go install does. The linker fails to generate DWARF of shared libraries in new versions of Go.
+	args = []string{"-o", filepath.Join(work, lib), "-importcfg", linkCfg, "-installsuffix", "dynlink", "-buildmode=shared", "-linkshared", "-w"}
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	if err := runTool(ctx, pkg.Path, filepath.Join(tools, "link"), append(args, pkg.Path+"="+archive)...); err != nil {
+		return fmt.Errorf("Failed to link a shared library of %s: %v", pkg.Path, err)
+	}
+
+	// Install the archive and the shared library as go install does so that later packages can import pkg.
+	if err := os.MkdirAll(filepath.Dir(cfg.archive(pkg.Path)), 0766); err != nil {
+		return err
+	}
+	if err := os.Rename(archive, cfg.archive(pkg.Path)); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(work, lib), filepath.Join(cfg.pkgDir, lib)); err != nil {
+		return err
+	}
+	return cfg.install(pkg.Path, lib)
+}
+
+// runTool runs a go tool to build pkgPath.
+func runTool(ctx context.Context, pkgPath, name string, args ...string) error {
+	stderr := newBuildOutputWriter(os.Stderr, pkgPath)
+	defer stderr.Flush()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
@@ -0,0 +1,59 @@
+// +build !lgoplugin
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// benchmarkSharedBuild measures the time to build a small package converted from lgo code.
+// This benchmark needs shared libraries installed in $LGOPATH with lgo install.
+func benchmarkSharedBuild(b *testing.B, direct bool) {
+	lgopath := os.Getenv("LGOPATH")
+	if lgopath == "" {
+		b.Skip("LGOPATH is not set")
+	}
+	if _, err := os.Stat(path.Join(lgopath, "pkg", "libstd.so")); err != nil {
+		b.Skip("libstd.so is not installed in LGOPATH")
+	}
+	sessID := NewSessionID()
+	rn := NewLgoRunner(lgopath, sessID)
+	defer CleanSession(lgopath, sessID)
+	e := &sharedExecutor{rn: rn}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pkg := &Package{Path: path.Join(rn.sessDir(), fmt.Sprintf("exec%d", i)), Deps: []string{"fmt"}}
+		dir := path.Join(build.Default.GOPATH, "src", pkg.Path)
+		if err := os.MkdirAll(dir, 0766); err != nil {
+			b.Fatal(err)
+		}
+		src := fmt.Sprintf("package lgo_exec\n\nimport \"fmt\"\n\nfunc F%d() { fmt.Println(%d) }\n", i, i)
+		if err := ioutil.WriteFile(path.Join(dir, "src.go"), []byte(src), 0666); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		var err error
+		if direct {
+			err = e.Build(context.Background(), pkg)
+		} else {
+			err = e.goInstall(context.Background(), pkg)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSharedBuildGoInstall(b *testing.B) {
+	benchmarkSharedBuild(b, false)
+}
+
+func BenchmarkSharedBuildDirect(b *testing.B) {
+	benchmarkSharedBuild(b, true)
+}
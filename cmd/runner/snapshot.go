@@ -0,0 +1,156 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// SaveSessionCommand and RestoreSessionCommand are the commands to save and restore sessions.
+// They take an optional path to the directory of the snapshot.
+const (
+	SaveSessionCommand    = "%save_session"
+	RestoreSessionCommand = "%restore_session"
+)
+
+const (
+	snapshotCellsFile = "cells.json"
+	snapshotVarsFile  = "vars.gob"
+)
+
+// sessionSnapshot is the content of cells.json in snapshot directories.
+type sessionSnapshot struct {
+	// Cells are the sources of cells with declarations in the order of executions.
+	// Cells also have %del commands which deleted variables declared in the cells before them.
+	Cells []string `json:"cells"`
+}
+
+// DefaultSnapshotDir returns the directory where the snapshot of a session is saved by default.
+func DefaultSnapshotDir(lgopath string) string {
+	return filepath.Join(lgopath, "snapshots", "default")
+}
+
+// snapshotImportName is the name of the core package imported in lgo code generated to save and restore variables.
+const snapshotImportName = "lgocore"
+
+// saveVarsCode returns lgo code to save variables to path.
+// Variables are saved and restored by lgo code so that variables in worker processes are handled.
+func saveVarsCode(path string) string {
+	return fmt.Sprintf("import %s %q\n%s.LgoSaveVars(%q)\n", snapshotImportName, core.SelfPkgPath, snapshotImportName, path)
+}
+
+// restoreVarsCode returns lgo code to restore variables in the session from path.
+func (rn *LgoRunner) restoreVarsCode(path string) string {
+	var names []string
+	rn.mu.Lock()
+	for name, obj := range rn.vars {
+		if _, ok := obj.(*types.Var); ok {
+			names = append(names, strings.TrimPrefix(name, lgoExportPrefix))
+		}
+	}
+	rn.mu.Unlock()
+	sort.Strings(names)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "import %s %q\n%s.LgoRestoreVars(%q, map[string]interface{}{\n", snapshotImportName, core.SelfPkgPath, snapshotImportName, path)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "\t%q: &%s,\n", name, name)
+	}
+	buf.WriteString("})\n")
+	return buf.String()
+}
+
+// runInternal runs lgo code generated by lgo without recording it to the history of the session.
+// label is the label of the code in stack traces. If it is empty, In[N] is used.
+func (rn *LgoRunner) runInternal(ctx core.LgoContext, src string, label string) error {
+	n := len(rn.cells)
+	rn.mu.Lock()
+	im, imported := rn.imports[snapshotImportName]
+	rn.mu.Unlock()
+	err := rn.runCell(ctx, src, true, label)
+	rn.cells = rn.cells[:n]
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if imported {
+		rn.imports[snapshotImportName] = im
+	} else {
+		delete(rn.imports, snapshotImportName)
+	}
+	rn.updateSnapshotLocked()
+	return err
+}
+
+// SaveSession saves the snapshot of the session to dir.
+// The snapshot consists of the sources of cells with declarations and the values of variables encodable with encoding/gob.
+// Requirements in go.mod of the session are not saved.
+func (rn *LgoRunner) SaveSession(ctx core.LgoContext, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return fmt.Errorf("failed to create a directory for the snapshot: %v", err)
+	}
+	b, err := json.MarshalIndent(&sessionSnapshot{Cells: rn.cells}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, snapshotCellsFile), b, 0666); err != nil {
+		return err
+	}
+	varsPath := filepath.Join(dir, snapshotVarsFile)
+	if err := os.RemoveAll(varsPath); err != nil {
+		return err
+	}
+	if err := rn.runInternal(ctx, saveVarsCode(varsPath), ""); err != nil {
+		return fmt.Errorf("failed to save variables: %v", err)
+	}
+	if _, err := os.Stat(varsPath); err != nil {
+		return fmt.Errorf("failed to save variables: %v", err)
+	}
+	return nil
+}
+
+// RestoreSession restores the snapshot of a session saved in dir by SaveSession.
+// Declarations in the saved cells are loaded into the current session without running the cells,
+// then variables are restored from the saved values. Variables which were not saved are zero values.
+func (rn *LgoRunner) RestoreSession(ctx core.LgoContext, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, snapshotCellsFile))
+	if err != nil {
+		return fmt.Errorf("failed to read the snapshot: %v", err)
+	}
+	var snapshot sessionSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse the snapshot: %v", err)
+	}
+	for i, src := range snapshot.Cells {
+		if ok, err := rn.restoreDeletion(src); ok {
+			if err != nil {
+				return fmt.Errorf("failed to restore the cell #%d in the snapshot: %v", i+1, err)
+			}
+			continue
+		}
+		if err := rn.runCell(ctx, src, false, ""); err != nil {
+			return fmt.Errorf("failed to restore the cell #%d in the snapshot: %v", i+1, err)
+		}
+	}
+	varsPath := filepath.Join(dir, snapshotVarsFile)
+	if _, err := os.Stat(varsPath); err != nil {
+		return fmt.Errorf("failed to read variables: %v", err)
+	}
+	if err := rn.runInternal(ctx, rn.restoreVarsCode(varsPath), ""); err != nil {
+		return fmt.Errorf("failed to restore variables: %v", err)
+	}
+	return nil
+}
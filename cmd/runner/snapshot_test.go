@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"go/types"
+	"reflect"
+	"testing"
+)
+
+func TestRestoreVarsCode(t *testing.T) {
+	rn := NewLgoRunner("/lgopath", &SessionID{})
+	pkg := types.NewPackage("github.com/yunabe/lgo/sess/exec1", "exec1")
+	rn.vars["LgoExport_x"] = types.NewVar(0, pkg, "LgoExport_x", types.Typ[types.Int])
+	rn.vars["LgoExport_a"] = types.NewVar(0, pkg, "LgoExport_a", types.Typ[types.String])
+	rn.vars["LgoExport_f"] = types.NewFunc(0, pkg, "LgoExport_f", types.NewSignature(nil, nil, nil, false))
+	got := rn.restoreVarsCode("/tmp/vars.gob")
+	want := `import lgocore "github.com/yunabe/lgo/core"
+lgocore.LgoRestoreVars("/tmp/vars.gob", map[string]interface{}{
+	"a": &a,
+	"x": &x,
+})
+`
+	if got != want {
+		t.Errorf("Got %q; want %q", got, want)
+	}
+}
+
+func TestRestoreDeletion(t *testing.T) {
+	rn := NewLgoRunner("/lgopath", &SessionID{})
+	pkg := types.NewPackage("github.com/yunabe/lgo/sess/exec1", "exec1")
+	rn.vars["LgoExport_x"] = types.NewVar(0, pkg, "LgoExport_x", types.Typ[types.Int])
+	rn.vars["LgoExport_y"] = types.NewVar(0, pkg, "LgoExport_y", types.Typ[types.Int])
+	rn.cells = []string{"x, y := 1, 2"}
+	if ok, err := rn.restoreDeletion("z := x + y"); ok || err != nil {
+		t.Errorf("Unexpected result for a cell: %v, %v", ok, err)
+	}
+	if ok, err := rn.restoreDeletion("%del x"); !ok || err != nil {
+		t.Errorf("Failed to restore the deletion: %v, %v", ok, err)
+	}
+	if names := rn.VarNames(); !reflect.DeepEqual(names, []string{"y"}) {
+		t.Errorf("Got %v; want [y]", names)
+	}
+	// The deletion is saved again with the session.
+	if want := []string{"x, y := 1, 2", "%del x"}; !reflect.DeepEqual(rn.cells, want) {
+		t.Errorf("Got %q; want %q", rn.cells, want)
+	}
+	if ok, err := rn.restoreDeletion("%del x"); !ok || err == nil {
+		t.Errorf("Expected an error for a deleted variable: %v, %v", ok, err)
+	}
+}
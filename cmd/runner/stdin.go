@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// How often RedirectStdin checks whether lgo code is waiting for inputs.
+var stdinPollInterval = 50 * time.Millisecond
+
+// RedirectStdin replaces os.Stdin with a pipe. When lgo code is blocked in reading os.Stdin and the pipe is empty,
+// a line is read from input without a prompt and written to the pipe. A line is requested for each blocked read.
+// Thus, readers which read ahead (e.g. bufio.Reader) do not request lines which are not needed yet.
+// If input returns an error (e.g. the execution is cancelled), lgo code gets io.EOF.
+// ctx is the context of the execution. Inputs are not requested after ctx is done or restore is called.
+// restore restores os.Stdin and waits until the pipe is closed.
+//
+// Blocked reads are detected from /proc. On platforms other than Linux, os.Stdin is not redirected.
+// Use core.ReadLine and core.ReadPassword to show a prompt or to read a password.
+func RedirectStdin(ctx context.Context, input core.InputReader) (restore func(), err error) {
+	if !canRedirectStdin() {
+		return func() {}, nil
+	}
+	fds, err := blockingPipe()
+	if err != nil {
+		return nil, err
+	}
+	r := os.NewFile(uintptr(fds[0]), "lgo-stdin")
+	w := os.NewFile(uintptr(fds[1]), "lgo-stdin-writer")
+	orig := os.Stdin
+	os.Stdin = r
+	ctx, cancel := context.WithCancel(ctx)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		// Close r after w so that lgo code blocked in os.Stdin.Read gets io.EOF.
+		defer r.Close()
+		defer w.Close()
+		ticker := time.NewTicker(stdinPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !waitingForInput(fds[0]) {
+				continue
+			}
+			// ReadInput returns an error without requesting an input if ctx is done.
+			line, err := input.ReadInput(ctx, "", false)
+			if err != nil {
+				return
+			}
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		os.Stdin = orig
+		<-finished
+	}, nil
+}
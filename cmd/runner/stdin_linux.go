@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// blockingPipe returns a pipe in the blocking mode.
+// Do not use os.Pipe. Reads from non-blocking pipes do not block threads in read(2).
+func blockingPipe() (fds [2]int, err error) {
+	err = syscall.Pipe2(fds[:], syscall.O_CLOEXEC)
+	return
+}
+
+// isReadBlocked reports whether a thread of the current process is blocked in read(2) on fd.
+// It is detected from /proc/self/task/<tid>/syscall, which shows the syscall number and args of a blocked thread.
+func isReadBlocked(fd uintptr) bool {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return false
+	}
+	num := strconv.Itoa(syscall.SYS_READ)
+	arg := fmt.Sprintf("%#x", fd)
+	for _, task := range tasks {
+		b, err := ioutil.ReadFile(path.Join("/proc/self/task", task.Name(), "syscall"))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(b))
+		if len(fields) >= 2 && fields[0] == num && fields[1] == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// waitingForInput reports whether lgo code is blocked in reading the empty pipe fd.
+func waitingForInput(fd int) bool {
+	// TIOCINQ (a.k.a. FIONREAD) returns the number of bytes in the pipe.
+	if n, err := unix.IoctlGetInt(fd, unix.TIOCINQ); err != nil || n > 0 {
+		return false
+	}
+	return isReadBlocked(uintptr(fd))
+}
+
+// canRedirectStdin reports whether RedirectStdin can detect reads from os.Stdin.
+func canRedirectStdin() bool {
+	_, err := ioutil.ReadFile("/proc/self/syscall")
+	return err == nil
+}
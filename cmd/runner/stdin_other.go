@@ -0,0 +1,19 @@
+// +build !linux
+
+package runner
+
+import "errors"
+
+// Reads from os.Stdin are not detected on this platform. RedirectStdin does not redirect os.Stdin.
+
+func blockingPipe() (fds [2]int, err error) {
+	return fds, errors.New("blocking pipes are not supported")
+}
+
+func waitingForInput(fd int) bool {
+	return false
+}
+
+func canRedirectStdin() bool {
+	return false
+}
@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeInputReader struct {
+	mu     sync.Mutex
+	lines  []string
+	called int
+}
+
+func (r *fakeInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	r.called++
+	if len(r.lines) == 0 {
+		return "", errors.New("no more input")
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return line, nil
+}
+
+func (r *fakeInputReader) calledCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.called
+}
+
+func TestRedirectStdin(t *testing.T) {
+	input := &fakeInputReader{lines: []string{"hello", "42"}}
+	restore, err := RedirectStdin(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+
+	// Inputs are not requested until os.Stdin is read.
+	time.Sleep(3 * stdinPollInterval)
+	if n := input.calledCount(); n != 0 {
+		t.Errorf("ReadInput was called %d times before os.Stdin is read", n)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Errorf("Got %q; want %q", line, "hello\n")
+	}
+	var n int
+	if _, err := fmt.Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 42 {
+		t.Errorf("Got %d; want 42", n)
+	}
+	// os.Stdin reaches EOF when ReadInput fails.
+	var b [16]byte
+	if _, err := os.Stdin.Read(b[:]); err != io.EOF {
+		t.Errorf("Got %v; want io.EOF", err)
+	}
+}
+
+func TestRedirectStdin_bufferedReader(t *testing.T) {
+	input := &fakeInputReader{lines: []string{"first", "second"}}
+	restore, err := RedirectStdin(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+
+	// bufio.Reader reads up to its buffer size from os.Stdin.
+	// Only a line is requested for each read though.
+	r := bufio.NewReaderSize(os.Stdin, 4096)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "first\n" {
+		t.Errorf("Got %q; want %q", line, "first\n")
+	}
+	if n := r.Buffered(); n != 0 {
+		t.Errorf("%d bytes are buffered unexpectedly", n)
+	}
+	time.Sleep(3 * stdinPollInterval)
+	if n := input.calledCount(); n != 1 {
+		t.Errorf("ReadInput was called %d times; want 1", n)
+	}
+	line, err = r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "second\n" {
+		t.Errorf("Got %q; want %q", line, "second\n")
+	}
+	if n := input.calledCount(); n != 2 {
+		t.Errorf("ReadInput was called %d times; want 2", n)
+	}
+}
+
+// blockingInputReader blocks ReadInput until ctx is done.
+type blockingInputReader struct {
+	called chan struct{}
+}
+
+func (r *blockingInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	close(r.called)
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestRedirectStdin_restore(t *testing.T) {
+	orig := os.Stdin
+	input := &blockingInputReader{called: make(chan struct{})}
+	restore, err := RedirectStdin(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Stdin == orig {
+		t.Skip("os.Stdin is not redirected on this platform")
+	}
+	stdin := os.Stdin
+	readErr := make(chan error)
+	go func() {
+		var b [16]byte
+		_, err := stdin.Read(b[:])
+		readErr <- err
+	}()
+	<-input.called
+	// restore cancels ReadInput and returns after the pipe is closed.
+	restore()
+	if os.Stdin != orig {
+		t.Error("os.Stdin is not restored")
+	}
+	if err := <-readErr; err != io.EOF {
+		t.Errorf("Got %v; want io.EOF", err)
+	}
+}
+
+func TestRedirectStdin_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	input := &fakeInputReader{lines: []string{"hello"}}
+	restore, err := RedirectStdin(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore()
+	time.Sleep(3 * stdinPollInterval)
+	if n := input.calledCount(); n != 0 {
+		t.Errorf("ReadInput was called %d times after the execution finished", n)
+	}
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// cellLabels maps package paths of cells to their labels (e.g. In[3]) shown in stack traces.
+// The labels are global because stack traces are formatted in core, which is shared by all runners in the process.
+var cellLabels = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+func setCellLabel(pkgPath, label string) {
+	if label == "" {
+		return
+	}
+	cellLabels.Lock()
+	defer cellLabels.Unlock()
+	cellLabels.m[pkgPath] = label
+}
+
+// clearCellLabels removes the labels of cells in the session sessDir.
+// It is called when packages built before are not reused anymore so that labels do not accumulate.
+// Frames of the packages are shown with their package names after that.
+func clearCellLabels(sessDir string) {
+	cellLabels.Lock()
+	defer cellLabels.Unlock()
+	for pkgPath := range cellLabels.m {
+		if strings.HasPrefix(pkgPath, sessDir+"/") {
+			delete(cellLabels.m, pkgPath)
+		}
+	}
+}
+
+// cellLabel returns the label of the cell of pkgPath. It returns the package name if the label is unknown.
+func cellLabel(pkgPath string) string {
+	cellLabels.Lock()
+	defer cellLabels.Unlock()
+	if label, ok := cellLabels.m[pkgPath]; ok {
+		return label
+	}
+	return path.Base(pkgPath)
+}
+
+// framePkgPath returns the package path of the function in a line of a stack trace
+// (e.g. "github.com/foo/bar.(*T).m(0x1)" or "created by github.com/foo/bar.f in goroutine 1").
+func framePkgPath(fn string) string {
+	fn = strings.TrimPrefix(fn, "created by ")
+	if paren := strings.Index(fn, "("); paren >= 0 {
+		// Drop args. Note that the receiver of a method follows the package path (e.g. pkg.(*T).m).
+		fn = fn[:paren]
+	}
+	slash := strings.LastIndex(fn, "/")
+	dot := strings.Index(fn[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	return fn[:slash+1+dot]
+}
+
+// lgoInternalPkgs are packages of lgo which run cells. Their frames are hidden from stack traces.
+// Frames of packages which lgo code calls directly (e.g. display and widgets) are kept.
+var lgoInternalPkgs = []string{
+	"github.com/yunabe/lgo/core",
+	"github.com/yunabe/lgo/cmd/runner",
+	"github.com/yunabe/lgo/cmd/lgo-internal",
+}
+
+// isLgoInternalPkg returns true if frames of pkg are hidden from stack traces.
+func isLgoInternalPkg(pkg string) bool {
+	if pkg == "main" || pkg == "runtime/debug" {
+		// lgo-internal and debug.Stack() itself.
+		return true
+	}
+	for _, p := range lgoInternalPkgs {
+		if pkg == p || strings.HasPrefix(pkg, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cellFileLineRe matches the location of a frame in a cell. src.go has line directives to map lines to lines in cells.
+var cellFileLineRe = regexp.MustCompile(`^\t\S*/src\.go:(\d+)`)
+
+// FormatStack rewrites a stack trace (e.g. debug.Stack()) of a panic in lgo code to make it readable.
+// Frames of lgo itself (core, runner and lgo-internal) are removed and frames of cells are shown with
+// the labels of cells (e.g. In[3]), line numbers in cells and identifiers in the original code.
+// Frames of other packages are kept as they are.
+func FormatStack(stack []byte) []byte {
+	lines := strings.Split(string(stack), "\n")
+	var buf bytes.Buffer
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// A frame consists of the function line and the location line which starts with a tab.
+		if line == "" || strings.HasPrefix(line, "\t") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "\t") {
+			buf.WriteString(line)
+			if i+1 < len(lines) {
+				buf.WriteByte('\n')
+			}
+			continue
+		}
+		loc := lines[i+1]
+		i++
+		pkg := framePkgPath(line)
+		if isLgoInternalPkg(pkg) {
+			continue
+		}
+		if strings.HasPrefix(pkg, sessPkgPrefix) {
+			prefix := ""
+			if strings.HasPrefix(line, "created by ") {
+				prefix = "created by "
+			}
+			line = prefix + strings.Replace(line[len(prefix)+len(pkg)+1:], lgoExportPrefix, "", -1)
+			if m := cellFileLineRe.FindStringSubmatch(loc); m != nil {
+				loc = "\t" + cellLabel(pkg) + ":" + m[1]
+			}
+		}
+		buf.WriteString(line + "\n" + loc + "\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"testing"
+)
+
+func TestFramePkgPath(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"github.com/yunabe/lgo/core.(*resultCounter).recordResult(0xc0000a6040, {0x4a7c60, 0x4e5f10})", "github.com/yunabe/lgo/core"},
+		{"main.main()", "main"},
+		{"runtime/debug.Stack()", "runtime/debug"},
+		{"panic({0x4a7c60?, 0x4e5f10?})", ""},
+		{"created by github.com/yunabe/lgo/core.startExec in goroutine 1", "github.com/yunabe/lgo/core"},
+		// Dots in the last element of package paths are escaped in symbol names.
+		{"gopkg.in/yaml%2ev2.Unmarshal(...)", "gopkg.in/yaml%2ev2"},
+	}
+	for _, tc := range tests {
+		if got := framePkgPath(tc.line); got != tc.want {
+			t.Errorf("framePkgPath(%q) = %q; want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestFormatStack(t *testing.T) {
+	cell := sessPkgPrefix + "7b22/exec3"
+	setCellLabel(cell, "In[2]")
+	stack := `goroutine 7 [running]:
+runtime/debug.Stack()
+	/usr/local/go/src/runtime/debug/stack.go:26 +0x5e
+github.com/yunabe/lgo/core.(*resultCounter).recordResult(0xc0000a6040, {0x4a7c60, 0x4e5f10})
+	/gopath/src/github.com/yunabe/lgo/core/core.go:140 +0x8d
+panic({0x4a7c60?, 0x4e5f10?})
+	/usr/local/go/src/runtime/panic.go:785 +0x132
+github.com/foo/bar.Do(...)
+	/gopath/src/github.com/foo/bar/bar.go:10
+` + cell + `.(*LgoExport_T).LgoExport_m(0x1)
+	/gopath/src/` + cell + `/src.go:4 +0x1d
+` + cell + `.lgo_init.func1()
+	/gopath/src/` + cell + `/src.go:7 +0x25
+` + sessPkgPrefix + `7b22/exec9.LgoExport_f(...)
+	/gopath/src/` + sessPkgPrefix + `7b22/exec9/src.go:1
+github.com/yunabe/lgo/core.startExec.func1()
+	/gopath/src/github.com/yunabe/lgo/core/core.go:297 +0x5b
+created by github.com/yunabe/lgo/core.startExec in goroutine 1
+	/gopath/src/github.com/yunabe/lgo/core/core.go:294 +0x12c
+`
+	want := `goroutine 7 [running]:
+panic({0x4a7c60?, 0x4e5f10?})
+	/usr/local/go/src/runtime/panic.go:785 +0x132
+github.com/foo/bar.Do(...)
+	/gopath/src/github.com/foo/bar/bar.go:10
+(*T).m(0x1)
+	In[2]:4
+lgo_init.func1()
+	In[2]:7
+f(...)
+	exec9:1
+`
+	if got := string(FormatStack([]byte(stack))); got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIsLgoInternalPkg(t *testing.T) {
+	tests := []struct {
+		pkg  string
+		want bool
+	}{
+		{"main", true},
+		{"runtime/debug", true},
+		{"github.com/yunabe/lgo/core", true},
+		{"github.com/yunabe/lgo/cmd/runner", true},
+		{"github.com/yunabe/lgo/cmd/lgo-internal/liner", true},
+		// Packages which lgo code calls directly.
+		{"github.com/yunabe/lgo/display", false},
+		{"github.com/yunabe/lgo/widgets", false},
+		{"github.com/yunabe/lgo/magic", false},
+		{"github.com/yunabe/lgo/corex", false},
+		{sessPkgPrefix + "7b22/exec1", false},
+		{"runtime", false},
+	}
+	for _, tc := range tests {
+		if got := isLgoInternalPkg(tc.pkg); got != tc.want {
+			t.Errorf("isLgoInternalPkg(%q) = %v; want %v", tc.pkg, got, tc.want)
+		}
+	}
+}
+
+func TestClearCellLabels(t *testing.T) {
+	sess1, sess2 := sessPkgPrefix+"7b31", sessPkgPrefix+"7b32"
+	setCellLabel(sess1+"/exec1", "In[1]")
+	setCellLabel(sess2+"/exec1", "In[2]")
+	clearCellLabels(sess1)
+	if got := cellLabel(sess1 + "/exec1"); got != "exec1" {
+		t.Errorf("The label of a cell in the cleared session is %q; want exec1", got)
+	}
+	if got := cellLabel(sess2 + "/exec1"); got != "In[2]" {
+		t.Errorf("The label of a cell in another session is %q; want In[2]", got)
+	}
+	clearCellLabels(sess2)
+}
@@ -0,0 +1,222 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/yunabe/lgo/cmd/install"
+)
+
+// PackageCommand is the cell magic to define a package in a cell.
+// The first line of the cell is `%%package path` and the rest of the cell is the source of the package.
+// Files of the package are separated by marker lines like `-- name.go --`.
+const PackageCommand = "%%package"
+
+// userPkgMarkerFile is the file written in the directories of packages defined with PackageCommand.
+// It protects directories of other packages from being overwritten.
+const userPkgMarkerFile = ".lgopackage"
+
+// packageFile is a source file of a package defined with PackageCommand.
+type packageFile struct {
+	name string
+	src  string
+}
+
+// parsePackageFileMarker returns the name of the file if line is a marker line (e.g. `-- geom.go --`).
+func parsePackageFileMarker(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) < len("-- x --") || !strings.HasPrefix(line, "-- ") || !strings.HasSuffix(line, " --") {
+		return "", false
+	}
+	return strings.TrimSpace(line[len("-- ") : len(line)-len(" --")]), true
+}
+
+// splitPackageFiles splits src of the package pkgPath into files.
+// The code before the first marker line is the file named after the last element of pkgPath.
+func splitPackageFiles(pkgPath, src string) ([]packageFile, error) {
+	var files []packageFile
+	cur := packageFile{name: path.Base(pkgPath) + ".go"}
+	// The code before the first marker line is ignored if it is empty.
+	implicit := true
+	seen := make(map[string]bool)
+	flush := func() error {
+		if implicit && strings.TrimSpace(cur.src) == "" {
+			return nil
+		}
+		if seen[cur.name] {
+			return fmt.Errorf("duplicate file %s in %s", cur.name, pkgPath)
+		}
+		seen[cur.name] = true
+		files = append(files, cur)
+		return nil
+	}
+	for _, line := range strings.SplitAfter(src, "\n") {
+		name, ok := parsePackageFileMarker(line)
+		if !ok {
+			cur.src += line
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		if !strings.HasSuffix(name, ".go") || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("invalid file name %q in %s: it must be a .go file in the package directory", name, pkgPath)
+		}
+		cur, implicit = packageFile{name: name}, false
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no source of %s", pkgPath)
+	}
+	return files, nil
+}
+
+// invalidPkgPathChars are characters which can not be used in package paths.
+// cf. isValidImport in go/build.
+const invalidPkgPathChars = "!\"#$%&'()*,:;<=>?[\\]^`{|}\uFFFD"
+
+// checkUserPkgPath returns an error if pkgPath can not be defined with PackageCommand.
+func checkUserPkgPath(pkgPath string) error {
+	if pkgPath == "" || path.IsAbs(pkgPath) || path.Clean(pkgPath) != pkgPath || strings.HasPrefix(pkgPath, "..") {
+		return fmt.Errorf("invalid package path: %q", pkgPath)
+	}
+	for _, r := range pkgPath {
+		if !unicode.IsGraphic(r) || unicode.IsSpace(r) || strings.ContainsRune(invalidPkgPathChars, r) {
+			return fmt.Errorf("invalid character %q in package path: %q", r, pkgPath)
+		}
+	}
+	if install.IsStdPkg(pkgPath) {
+		return fmt.Errorf("%s is a standard package", pkgPath)
+	}
+	if pkgPath == lgoModulePath || strings.HasPrefix(pkgPath, lgoModulePath+"/") {
+		return fmt.Errorf("%s is reserved by lgo", pkgPath)
+	}
+	return nil
+}
+
+// userPkgDir returns the directory where sources of the package pkgPath defined with PackageCommand are stored.
+// In GOPATH mode, the package is in GOPATH. In module mode, the package is a module in $LGOPATH/mod
+// which go.mod of the session replaces.
+func (rn *LgoRunner) userPkgDir(pkgPath string) string {
+	if rn.mod != nil {
+		return path.Join(rn.lgopath, "mod", pkgPath)
+	}
+	return path.Join(build.Default.GOPATH, "src", pkgPath)
+}
+
+// packageImports returns the packages imported by files.
+func packageImports(files []packageFile) []string {
+	fset := token.NewFileSet()
+	seen := make(map[string]bool)
+	var imports []string
+	for _, f := range files {
+		// Syntax errors are reported by the go command later.
+		file, _ := parser.ParseFile(fset, f.name, f.src, parser.ImportsOnly)
+		if file == nil {
+			continue
+		}
+		for _, im := range file.Imports {
+			p, err := strconv.Unquote(im.Path.Value)
+			if err != nil || seen[p] {
+				continue
+			}
+			seen[p] = true
+			imports = append(imports, p)
+		}
+	}
+	return imports
+}
+
+// markUserPkgsLoaded records that packages defined with PackageCommand in deps and their dependencies are loaded.
+func (rn *LgoRunner) markUserPkgsLoaded(deps []string) {
+	for _, dep := range deps {
+		imports, ok := rn.userPkgs[dep]
+		if !ok || rn.loadedUserPkgs[dep] {
+			continue
+		}
+		rn.loadedUserPkgs[dep] = true
+		rn.markUserPkgsLoaded(imports)
+	}
+}
+
+// writeUserPkg writes files of pkgPath to dir. Files of the package defined before are removed.
+// Subdirectories are kept because they may be other packages (e.g. mylib/geom in mylib).
+func (rn *LgoRunner) writeUserPkg(dir, pkgPath string, files []packageFile) error {
+	if entries, err := ioutil.ReadDir(dir); err == nil {
+		if _, err := os.Stat(path.Join(dir, userPkgMarkerFile)); err != nil {
+			return fmt.Errorf("%s already exists and it was not created by %s", dir, PackageCommand)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			if err := os.Remove(path.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, userPkgMarkerFile), nil, 0666); err != nil {
+		return err
+	}
+	if rn.mod != nil {
+		if err := ioutil.WriteFile(path.Join(dir, "go.mod"), []byte("module "+pkgPath+"\n"), 0666); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(path.Join(dir, f.name), []byte(f.src), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefinePackage writes src (files separated by marker lines) as the package pkgPath and installs
+// the shared library of the package into $LGOPATH/pkg so that later cells can import pkgPath.
+// A package can not be redefined after it is loaded by a cell because the process can not unload shared libraries.
+func (rn *LgoRunner) DefinePackage(ctx context.Context, pkgPath, src string) error {
+	if err := checkUserPkgPath(pkgPath); err != nil {
+		return err
+	}
+	files, err := splitPackageFiles(pkgPath, src)
+	if err != nil {
+		return err
+	}
+	if rn.loadedUserPkgs[pkgPath] {
+		return fmt.Errorf("%s can not be redefined because it was loaded in this session. Restart the kernel to redefine it", pkgPath)
+	}
+	dir := rn.userPkgDir(pkgPath)
+	if err := rn.writeUserPkg(dir, pkgPath, files); err != nil {
+		return fmt.Errorf("failed to write %s: %v", pkgPath, err)
+	}
+	if rn.mod != nil {
+		if err := rn.Replace(pkgPath, dir); err != nil {
+			return err
+		}
+		if err := rn.Require(pkgPath, "v0.0.0"); err != nil {
+			return err
+		}
+	}
+	imports := packageImports(files)
+	rn.userPkgs[pkgPath] = imports
+	// Cells built before may depend on the old definition.
+	rn.clearBuildCache()
+	if err := rn.installDeps(ctx, imports); err != nil {
+		return err
+	}
+	return rn.newSOInstaller().InstallContext(ctx, pkgPath)
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPackageFiles(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []packageFile
+		err  bool
+	}{
+		{
+			src:  "package geom\n",
+			want: []packageFile{{"geom.go", "package geom\n"}},
+		},
+		{
+			src: "package geom\n\n-- util.go --\npackage geom\n",
+			want: []packageFile{
+				{"geom.go", "package geom\n\n"},
+				{"util.go", "package geom\n"},
+			},
+		},
+		{
+			src: "\n-- a.go --\npackage geom\n-- b.go --\npackage geom\n",
+			want: []packageFile{
+				{"a.go", "package geom\n"},
+				{"b.go", "package geom\n"},
+			},
+		},
+		{src: "-- a.go --\npackage geom\n-- a.go --\npackage geom\n", err: true},
+		{src: "-- a.txt --\nhello\n", err: true},
+		{src: "-- ../a.go --\npackage geom\n", err: true},
+		{src: "\n\n", err: true},
+	}
+	for _, tc := range tests {
+		got, err := splitPackageFiles("mylib/geom", tc.src)
+		if tc.err {
+			if err == nil {
+				t.Errorf("splitPackageFiles(%q) succeeded unexpectedly: %v", tc.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitPackageFiles(%q) failed: %v", tc.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitPackageFiles(%q) = %v; want %v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestCheckUserPkgPath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"mylib/geom", true},
+		{"example.com/geom", true},
+		{"", false},
+		{"/mylib", false},
+		{"../mylib", false},
+		{"mylib//geom", false},
+		{"my lib/geom", false},
+		{"mylib/geom\t", false},
+		{"mylib/ge:om", false},
+		{"os", false},
+		{"github.com/yunabe/lgo/core", false},
+	}
+	for _, tc := range tests {
+		err := checkUserPkgPath(tc.path)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("checkUserPkgPath(%q) = %v; want ok == %v", tc.path, err, tc.ok)
+		}
+	}
+}
+
+func TestMarkUserPkgsLoaded(t *testing.T) {
+	rn := &LgoRunner{
+		userPkgs: map[string][]string{
+			"mylib/a": {"fmt", "mylib/b"},
+			"mylib/b": nil,
+			"mylib/c": nil,
+		},
+		loadedUserPkgs: make(map[string]bool),
+	}
+	rn.markUserPkgsLoaded([]string{"os", "mylib/a"})
+	want := map[string]bool{"mylib/a": true, "mylib/b": true}
+	if !reflect.DeepEqual(rn.loadedUserPkgs, want) {
+		t.Errorf("loadedUserPkgs = %v; want %v", rn.loadedUserPkgs, want)
+	}
+}
@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yunabe/lgo/core"
+	"github.com/yunabe/lgo/magic"
+)
+
+// varTypes returns the types of variables in the session keyed by their names.
+// Types declared in the session are not qualified (e.g. []T, not []exec1.LgoExport_T).
+// C types are shown as they are written in cells (e.g. C.int, not LgoCtype_int).
+func (rn *LgoRunner) varTypes() map[string]string {
+	sessPrefix := rn.sessDir() + "/"
+	qualifier := func(pkg *types.Package) string {
+		if strings.HasPrefix(pkg.Path(), sessPrefix) {
+			return ""
+		}
+		return pkg.Name()
+	}
+	vars := make(map[string]string)
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	for name, obj := range rn.vars {
+		if _, ok := obj.(*types.Var); ok {
+			typ := types.TypeString(obj.Type(), qualifier)
+			typ = strings.Replace(typ, lgoCtypePrefix, "C.", -1)
+			vars[strings.TrimPrefix(name, lgoExportPrefix)] = strings.Replace(typ, lgoExportPrefix, "", -1)
+		}
+	}
+	return vars
+}
+
+// VarNames returns the names of variables in the session in the sorted order.
+func (rn *LgoRunner) VarNames() []string {
+	var names []string
+	for name := range rn.varTypes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// varInfosCode returns lgo code to call fn (LgoVarInfos or LgoSaveVarInfos) in core with args and the types of variables.
+func varInfosCode(fn string, args []string, vars map[string]string) string {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "import %s %q\n%s.%s(", snapshotImportName, core.SelfPkgPath, snapshotImportName, fn)
+	for _, arg := range args {
+		buf.WriteString(arg + ", ")
+	}
+	buf.WriteString("map[string]string{\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "\t%q: %q,\n", name, vars[name])
+	}
+	buf.WriteString("})\n")
+	return buf.String()
+}
+
+// Whos shows the names, the types, the sizes and the values of variables in the session as a table.
+// Values are read by lgo code so that variables in worker processes are handled.
+func (rn *LgoRunner) Whos(ctx core.LgoContext) error {
+	vars := rn.varTypes()
+	if len(vars) == 0 {
+		fmt.Println("No variables are defined")
+		return nil
+	}
+	return rn.runInternal(ctx, varInfosCode("LgoVarInfos", nil, vars), "")
+}
+
+// Variables returns the summaries of variables in the session. It is used to show variables in frontends.
+// Like Whos, Variables runs lgo code to read values. Thus, it must not be called while a cell is running.
+func (rn *LgoRunner) Variables(ctx core.LgoContext) (core.VarInfos, error) {
+	vars := rn.varTypes()
+	if len(vars) == 0 {
+		return nil, nil
+	}
+	dir := filepath.Join(build.Default.GOPATH, "src", rn.sessDir())
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return nil, err
+	}
+	// The path is fixed so that the package built from the code is reused while variables are unchanged.
+	path := filepath.Join(dir, "vars.json")
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+	if err := rn.runInternal(ctx, varInfosCode("LgoSaveVarInfos", []string{fmt.Sprintf("%q", path)}, vars), ""); err != nil {
+		return nil, fmt.Errorf("failed to read variables: %v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read variables: %v", err)
+	}
+	var infos core.VarInfos
+	if err := json.Unmarshal(b, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// varKeys returns the keys of the variables names in rn.vars. It returns an error if a name is not a variable.
+func (rn *LgoRunner) varKeys(names []string) ([]string, error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	var keys []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		// Exported names are not renamed with lgoExportPrefix.
+		key := lgoExportPrefix + name
+		obj := rn.vars[key]
+		if obj == nil {
+			key = name
+			obj = rn.vars[key]
+		}
+		if obj == nil || strings.HasPrefix(name, lgoExportPrefix) {
+			return nil, fmt.Errorf("%s is not defined", name)
+		}
+		if _, ok := obj.(*types.Var); !ok {
+			return nil, fmt.Errorf("%s is not a variable", name)
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// deleteVarsCode returns lgo code to delete variables names and report the freed memory.
+func deleteVarsCode(names []string) string {
+	var args []string
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("%q", name))
+	}
+	return fmt.Sprintf("import %s %q\n%s.LgoDeleteVars(%s)\n", snapshotImportName, core.SelfPkgPath, snapshotImportName, strings.Join(args, ", "))
+}
+
+// deleteVarsCommand is the magic command to delete variables.
+// It is also recorded in the cells of the session so that variables are deleted again when the session is restored.
+const deleteVarsCommand = "%del"
+
+// DeleteVars clears the variables names with zero-values to release memory held by them
+// and removes them from the session so that later cells can not refer to them.
+// Functions declared before still refer to the cleared variables.
+func (rn *LgoRunner) DeleteVars(ctx core.LgoContext, names []string) error {
+	keys, err := rn.varKeys(names)
+	if err != nil {
+		return err
+	}
+	var trimmed []string
+	for _, key := range keys {
+		trimmed = append(trimmed, strings.TrimPrefix(key, lgoExportPrefix))
+	}
+	if err := rn.runInternal(ctx, deleteVarsCode(trimmed), ""); err != nil {
+		return err
+	}
+	rn.removeVars(keys, deleteVarsCommand+" "+strings.Join(trimmed, " "))
+	return nil
+}
+
+// removeVars removes the variables keys from the session and records cmd, which deleted them, to cells.
+func (rn *LgoRunner) removeVars(keys []string, cmd string) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	for _, key := range keys {
+		delete(rn.vars, key)
+	}
+	rn.updateSnapshotLocked()
+	rn.cells = append(rn.cells, cmd)
+}
+
+// restoreDeletion removes the variables deleted by src from the session if src is deleteVarsCommand
+// recorded in a snapshot. The variables are not cleared because they are not restored yet.
+func (rn *LgoRunner) restoreDeletion(src string) (ok bool, err error) {
+	cmd, ok := magic.Parse(src)
+	if !ok || cmd.String() != deleteVarsCommand {
+		return false, nil
+	}
+	keys, err := rn.varKeys(strings.Fields(cmd.Args))
+	if err != nil {
+		return true, err
+	}
+	rn.removeVars(keys, src)
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"go/types"
+	"reflect"
+	"testing"
+)
+
+func TestVarInfosCode(t *testing.T) {
+	rn := NewLgoRunner("/lgopath", &SessionID{})
+	pkg := types.NewPackage(rn.sessDir()+"/exec1", "exec1")
+	named := types.NewNamed(types.NewTypeName(0, pkg, "LgoExport_T", nil), types.NewStruct(nil, nil), nil)
+	bytesPkg := types.NewPackage("bytes", "bytes")
+	buffer := types.NewNamed(types.NewTypeName(0, bytesPkg, "Buffer", nil), types.NewStruct(nil, nil), nil)
+	rn.vars["LgoExport_x"] = types.NewVar(0, pkg, "LgoExport_x", types.Typ[types.Int])
+	rn.vars["LgoExport_ts"] = types.NewVar(0, pkg, "LgoExport_ts", types.NewSlice(named))
+	rn.vars["LgoExport_buf"] = types.NewVar(0, pkg, "LgoExport_buf", types.NewPointer(buffer))
+	rn.vars["LgoExport_T"] = named.Obj()
+	rn.vars["LgoExport_f"] = types.NewFunc(0, pkg, "LgoExport_f", types.NewSignature(nil, nil, nil, false))
+	cint := types.NewNamed(types.NewTypeName(0, pkg, "LgoCtype_int", nil), types.Typ[types.Int32], nil)
+	rn.vars["LgoExport_c"] = types.NewVar(0, pkg, "LgoExport_c", cint)
+
+	vars := rn.varTypes()
+	want := map[string]string{"x": "int", "ts": "[]T", "buf": "*bytes.Buffer", "c": "C.int"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("varTypes() = %v; want %v", vars, want)
+	}
+	if got, want := rn.VarNames(), []string{"buf", "c", "ts", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("VarNames() = %v; want %v", got, want)
+	}
+	got := varInfosCode("LgoSaveVarInfos", []string{`"/tmp/vars.json"`}, vars)
+	wantCode := `import lgocore "github.com/yunabe/lgo/core"
+lgocore.LgoSaveVarInfos("/tmp/vars.json", map[string]string{
+	"buf": "*bytes.Buffer",
+	"c": "C.int",
+	"ts": "[]T",
+	"x": "int",
+})
+`
+	if got != wantCode {
+		t.Errorf("Got %q; want %q", got, wantCode)
+	}
+}
+
+func TestVarKeys(t *testing.T) {
+	rn := NewLgoRunner("/lgopath", &SessionID{})
+	pkg := types.NewPackage(rn.sessDir()+"/exec1", "exec1")
+	rn.vars["LgoExport_x"] = types.NewVar(0, pkg, "LgoExport_x", types.Typ[types.Int])
+	rn.vars["Y"] = types.NewVar(0, pkg, "Y", types.Typ[types.Int])
+	rn.vars["LgoExport_f"] = types.NewFunc(0, pkg, "LgoExport_f", types.NewSignature(nil, nil, nil, false))
+	tests := []struct {
+		names []string
+		want  []string
+		err   bool
+	}{
+		{names: []string{"x", "Y", "x"}, want: []string{"LgoExport_x", "Y"}},
+		{names: []string{"x", "z"}, err: true},
+		{names: []string{"f"}, err: true},
+		{names: []string{"LgoExport_x"}, err: true},
+	}
+	for _, tc := range tests {
+		got, err := rn.varKeys(tc.names)
+		if tc.err {
+			if err == nil {
+				t.Errorf("varKeys(%v) succeeded unexpectedly: %v", tc.names, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("varKeys(%v) failed: %v", tc.names, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("varKeys(%v) = %v; want %v", tc.names, got, tc.want)
+		}
+	}
+	if got, want := deleteVarsCode([]string{"x", "Y"}), "import lgocore \"github.com/yunabe/lgo/core\"\nlgocore.LgoDeleteVars(\"x\", \"Y\")\n"; got != want {
+		t.Errorf("deleteVarsCode() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,623 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// File descriptors of connections passed to worker processes.
+const (
+	// workerConnFD is the connection on which the worker serves requests from the kernel.
+	workerConnFD = 3
+	// kernelConnFD is the connection on which the kernel serves requests from the worker.
+	kernelConnFD = 4
+)
+
+// How long the kernel waits for the worker to stop after a cancel operation before it kills the worker.
+var workerKillTimeout = 5 * time.Second
+
+// How long the kernel waits for the worker to exit after the connection to the worker is lost.
+var workerExitTimeout = 5 * time.Second
+
+// RestartWorkerCommand is the command to restart the worker process.
+const RestartWorkerCommand = "%restart_worker"
+
+var errWorkerNotRunning = errors.New("the worker process is not running. Run " + RestartWorkerCommand + " to restart it")
+
+// WorkerDisplayRequest is a request from the worker to display data in the kernel.
+type WorkerDisplayRequest struct {
+	ContentType string
+	// Value is the JSON representation of the content.
+	Value []byte
+	// UseID is true if the request has a display ID. If ID is empty, the kernel reserves a new ID.
+	UseID bool
+	ID    string
+}
+
+// WorkerBundleRequest is a request from the worker to display a mime bundle in the kernel.
+type WorkerBundleRequest struct {
+	// Bundle is the JSON representation of the mime bundle.
+	Bundle []byte
+}
+
+// WorkerStreamRequest is a request from the worker to write Text to stdout or stderr in the kernel.
+type WorkerStreamRequest struct {
+	// Name is "stdout" or "stderr".
+	Name string
+	Text string
+}
+
+// WorkerInputRequest is a request from the worker to read an input from users.
+type WorkerInputRequest struct {
+	Prompt   string
+	Password bool
+}
+
+// WorkerRunReply is the reply of Load and callbacks of comms in the worker.
+type WorkerRunReply struct {
+	// Panic is set if the main routine of the loaded package or the callback panics.
+	// It is sent as a reply rather than an error so that the kernel can show the panic value and the stack.
+	Panic *core.PanicError
+}
+
+// socketPair returns a pair of connected unix sockets.
+func socketPair() (local net.Conn, remote *os.File, err error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	f := os.NewFile(uintptr(fds[0]), "lgo-local")
+	defer f.Close()
+	local, err = net.FileConn(f)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return local, os.NewFile(uintptr(fds[1]), "lgo-remote"), nil
+}
+
+// stdFileWriter writes data to the current value of *file.
+// os.Stdout and os.Stderr are replaced on every execution in the kernel.
+type stdFileWriter struct {
+	file **os.File
+}
+
+func (w stdFileWriter) Write(p []byte) (n int, err error) {
+	return (*w.file).Write(p)
+}
+
+// workerHost is the RPC service served in the kernel for the worker.
+type workerHost struct {
+	// callWorker calls a method of workerService in the worker.
+	callWorker func(method string, args interface{}, reply interface{}) error
+
+	mu sync.Mutex
+	// ctx is the context of the current execution.
+	ctx core.LgoContext
+	// comm is the last CommManager passed with ctx. Comms outlive executions.
+	comm core.CommManager
+	// comms are comms used in the worker keyed by their IDs.
+	comms map[string]core.Comm
+}
+
+func (h *workerHost) setContext(ctx core.LgoContext) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ctx = ctx
+	if ctx.Comm != nil {
+		h.comm = ctx.Comm
+	}
+}
+
+func (h *workerHost) context() core.LgoContext {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ctx
+}
+
+func (h *workerHost) Display(req *WorkerDisplayRequest, id *string) error {
+	d := h.context().Display
+	if d == nil {
+		return errors.New("Display is not available")
+	}
+	var idp *string
+	if req.UseID {
+		*id = req.ID
+		idp = id
+	}
+	return d.Raw(req.ContentType, json.RawMessage(req.Value), idp)
+}
+
+// decodeBundle decodes a mime bundle encoded in the worker. Values of the bundle are json.RawMessage.
+func decodeBundle(b []byte) (map[string]interface{}, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	bundle := make(map[string]interface{})
+	for typ, v := range raw {
+		bundle[typ] = v
+	}
+	return bundle, nil
+}
+
+func (h *workerHost) DisplayResult(req *WorkerBundleRequest, _ *bool) error {
+	bundle, err := decodeBundle(req.Bundle)
+	if err != nil {
+		return err
+	}
+	if d, ok := h.context().Display.(core.ResultDisplayer); ok {
+		d.DisplayResult(bundle)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(bundle["text/plain"].(json.RawMessage), &text); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, text)
+	return err
+}
+
+func (h *workerHost) DisplayBundle(req *WorkerBundleRequest, _ *bool) error {
+	bundle, err := decodeBundle(req.Bundle)
+	if err != nil {
+		return err
+	}
+	return core.DisplayBundle(h.context().Display, bundle)
+}
+
+func (h *workerHost) ReadInput(req *WorkerInputRequest, value *string) error {
+	in := h.context().Input
+	if in == nil {
+		return errors.New("Input is not available")
+	}
+	v, err := in.ReadInput(h.context(), req.Prompt, req.Password)
+	*value = v
+	return err
+}
+
+func (h *workerHost) Stream(req *WorkerStreamRequest, _ *bool) error {
+	w := os.Stdout
+	if req.Name == "stderr" {
+		w = os.Stderr
+	}
+	_, err := io.WriteString(w, req.Text)
+	return err
+}
+
+// workerProcess is a running worker process.
+type workerProcess struct {
+	cmd    *exec.Cmd
+	client *rpc.Client
+	// exited is closed when the process exits. waitErr is the result of cmd.Wait.
+	exited  chan struct{}
+	waitErr error
+}
+
+func startWorkerProcess(name string, args []string, host *workerHost) (*workerProcess, error) {
+	workerLocal, workerRemote, err := socketPair()
+	if err != nil {
+		return nil, err
+	}
+	defer workerRemote.Close()
+	kernelLocal, kernelRemote, err := socketPair()
+	if err != nil {
+		workerLocal.Close()
+		return nil, err
+	}
+	defer kernelRemote.Close()
+
+	cmd := exec.Command(name, args...)
+	// Outputs written to the file descriptors directly (e.g. crash reports) are forwarded to the current outputs.
+	cmd.Stdout = stdFileWriter{&os.Stdout}
+	cmd.Stderr = stdFileWriter{&os.Stderr}
+	// ExtraFiles[i] becomes the file descriptor 3+i.
+	cmd.ExtraFiles = []*os.File{workerRemote, kernelRemote}
+	if err := cmd.Start(); err != nil {
+		workerLocal.Close()
+		kernelLocal.Close()
+		return nil, fmt.Errorf("failed to start the worker process: %v", err)
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Kernel", host); err != nil {
+		panic(err)
+	}
+	go server.ServeConn(kernelLocal)
+	p := &workerProcess{
+		cmd:    cmd,
+		client: rpc.NewClient(workerLocal),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		p.waitErr = cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+func (p *workerProcess) isExited() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *workerProcess) kill() {
+	p.client.Close()
+	p.cmd.Process.Kill()
+	<-p.exited
+}
+
+// exitError returns an error which describes why the worker process exited.
+func (p *workerProcess) exitError() error {
+	if p.waitErr != nil {
+		return fmt.Errorf("the worker process crashed: %v. Run %s to restart it", p.waitErr, RestartWorkerCommand)
+	}
+	return fmt.Errorf("the worker process exited. Run %s to restart it", RestartWorkerCommand)
+}
+
+// workerExecutor builds packages in the current process and executes them in a worker process
+// so that the kernel survives crashes of lgo code.
+// Packages are loaded in the worker process by the executor with the same name in the worker.
+type workerExecutor struct {
+	// builder builds packages in the kernel.
+	builder Executor
+	name    string
+	args    []string
+	host    *workerHost
+
+	mu   sync.Mutex
+	proc *workerProcess
+	// loaded is the list of packages loaded in the worker. Declarations in them are reloaded on restart.
+	loaded []*Package
+}
+
+func (e *workerExecutor) Build(ctx context.Context, pkg *Package) error {
+	return e.builder.Build(ctx, pkg)
+}
+
+func (e *workerExecutor) Load(ctx core.LgoContext, pkg *Package) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.proc == nil || e.proc.isExited() {
+		return errWorkerNotRunning
+	}
+	e.loaded = append(e.loaded, &Package{Path: pkg.Path, Deps: pkg.Deps})
+	e.host.setContext(ctx)
+	defer e.host.setContext(core.LgoContext{})
+	return e.load(ctx, pkg)
+}
+
+func (e *workerExecutor) load(ctx context.Context, pkg *Package) error {
+	p := e.proc
+	reply := new(WorkerRunReply)
+	call := p.client.Go("Worker.Load", pkg, reply, nil)
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		p.client.Go("Worker.Cancel", 0, new(bool), nil)
+		select {
+		case <-call.Done:
+		case <-time.After(workerKillTimeout):
+			p.kill()
+			return fmt.Errorf("the worker process was killed because it did not stop after cancellation. Run %s to restart it", RestartWorkerCommand)
+		}
+	}
+	if call.Error == nil && reply.Panic != nil {
+		return reply.Panic
+	}
+	if _, ok := call.Error.(rpc.ServerError); ok || call.Error == nil {
+		return call.Error
+	}
+	// The connection to the worker was lost.
+	select {
+	case <-p.exited:
+	case <-time.After(workerExitTimeout):
+		p.kill()
+	}
+	return p.exitError()
+}
+
+// callWorker calls method of the worker with args. It is used to call callbacks of comms in the worker.
+func (e *workerExecutor) callWorker(method string, args interface{}, reply interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.proc == nil || e.proc.isExited() {
+		return errWorkerNotRunning
+	}
+	return e.proc.client.Call(method, args, reply)
+}
+
+// restart restarts the worker process and reloads declarations in the packages loaded before.
+// Variables declared in the packages are reset to zero values.
+func (e *workerExecutor) restart(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.proc != nil && !e.proc.isExited() {
+		e.proc.kill()
+	}
+	proc, err := startWorkerProcess(e.name, e.args, e.host)
+	if err != nil {
+		e.proc = nil
+		return err
+	}
+	e.proc = proc
+	for _, pkg := range e.loaded {
+		if err := e.load(ctx, pkg); err != nil {
+			return fmt.Errorf("failed to reload %s: %v", pkg.Path, err)
+		}
+	}
+	return nil
+}
+
+// UseWorker switches rn to worker mode. In worker mode, packages are built in the current process and
+// executed in a worker process started with the command name and args.
+// The worker process must call ServeWorker with a runner which uses the same executor as rn.
+func (rn *LgoRunner) UseWorker(name string, args ...string) error {
+	e := &workerExecutor{
+		builder: rn.executor,
+		name:    name,
+		args:    args,
+		host:    &workerHost{},
+	}
+	e.host.callWorker = e.callWorker
+	if err := e.restart(context.Background()); err != nil {
+		return err
+	}
+	rn.executor = e
+	rn.clearBuildCache()
+	return nil
+}
+
+// ExecutorName returns the name of the executor which builds and loads packages.
+func (rn *LgoRunner) ExecutorName() string {
+	return rn.executorName
+}
+
+// RestartWorker restarts the worker process. Functions and types declared before are reloaded.
+// Variables declared before are available after the restart but their values are reset to zero values.
+func (rn *LgoRunner) RestartWorker(ctx context.Context) error {
+	e, ok := rn.executor.(*workerExecutor)
+	if !ok {
+		return errors.New("lgo is not running in worker mode")
+	}
+	return e.restart(ctx)
+}
+
+// workerService is the RPC service served in the worker for the kernel.
+type workerService struct {
+	executor Executor
+	kernel   *rpc.Client
+	comms    *workerCommManager
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// Load loads pkg into the worker process.
+// Outputs to os.Stdout and os.Stderr during the execution are forwarded to the kernel before Load returns.
+func (s *workerService) Load(pkg *Package, reply *WorkerRunReply) error {
+	err := s.run(func(ctx core.LgoContext) error {
+		setCellLabel(pkg.Path, pkg.Label)
+		return s.executor.Load(ctx, pkg)
+	})
+	return setPanicReply(err, reply)
+}
+
+// setPanicReply sets err to reply if err is a panic. Otherwise, it returns err.
+func setPanicReply(err error, reply *WorkerRunReply) error {
+	if p, ok := err.(*core.PanicError); ok {
+		reply.Panic = p
+		return nil
+	}
+	return err
+}
+
+// run runs f with the context whose Display, Input and Comm are forwarded to the kernel.
+// Outputs to os.Stdout and os.Stderr in f are forwarded to the kernel before run returns.
+func (s *workerService) run(f func(ctx core.LgoContext) error) (err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	soClose, err := s.forwardOutput("stdout", &os.Stdout, done)
+	if err != nil {
+		return err
+	}
+	seClose, err := s.forwardOutput("stderr", &os.Stderr, done)
+	if err != nil {
+		soClose()
+		<-done
+		return err
+	}
+	defer func() {
+		soClose()
+		seClose()
+		<-done
+		<-done
+	}()
+	input := &workerInputReader{s.kernel}
+	restoreStdin, err := RedirectStdin(ctx, input)
+	if err != nil {
+		return err
+	}
+	defer restoreStdin()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n\n%s", p, FormatStack(debug.Stack()))
+		}
+	}()
+	return f(core.LgoContext{
+		Context: ctx,
+		Display: &workerDisplayer{s.kernel},
+		Input:   input,
+		Comm:    s.comms,
+	})
+}
+
+// Cancel cancels the running Load.
+func (s *workerService) Cancel(_ int, _ *bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// forwardOutput replaces *file with a pipe whose outputs are sent to the kernel.
+func (s *workerService) forwardOutput(name string, file **os.File, done chan<- struct{}) (close func() error, err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	orig := *file
+	*file = w
+	go func() {
+		var buf [4096]byte
+		for {
+			n, err := r.Read(buf[:])
+			if n > 0 {
+				s.kernel.Call("Kernel.Stream", &WorkerStreamRequest{Name: name, Text: string(buf[:n])}, new(bool))
+			}
+			if err != nil {
+				break
+			}
+		}
+		r.Close()
+		done <- struct{}{}
+	}()
+	return func() error {
+		*file = orig
+		return w.Close()
+	}, nil
+}
+
+// workerDisplayer is DataDisplayer in the worker. It sends data to the kernel.
+type workerDisplayer struct {
+	kernel *rpc.Client
+}
+
+func (d *workerDisplayer) Raw(contentType string, v interface{}, id *string) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req := &WorkerDisplayRequest{ContentType: contentType, Value: b}
+	if id != nil {
+		req.UseID = true
+		req.ID = *id
+	}
+	var newID string
+	if err := d.kernel.Call("Kernel.Display", req, &newID); err != nil {
+		return err
+	}
+	if id != nil {
+		*id = newID
+	}
+	return nil
+}
+
+func (d *workerDisplayer) DisplayResult(bundle map[string]interface{}) {
+	b, err := json.Marshal(bundle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode the result: %v\n", err)
+		return
+	}
+	if err := d.kernel.Call("Kernel.DisplayResult", &WorkerBundleRequest{Bundle: b}, new(bool)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to display the result: %v\n", err)
+	}
+}
+
+func (d *workerDisplayer) DisplayBundle(bundle map[string]interface{}) error {
+	b, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+	return d.kernel.Call("Kernel.DisplayBundle", &WorkerBundleRequest{Bundle: b}, new(bool))
+}
+
+func (d *workerDisplayer) JavaScript(s string, id *string) { d.Raw("application/javascript", s, id) }
+func (d *workerDisplayer) HTML(s string, id *string)       { d.Raw("text/html", s, id) }
+func (d *workerDisplayer) Markdown(s string, id *string)   { d.Raw("text/markdown", s, id) }
+func (d *workerDisplayer) Latex(s string, id *string)      { d.Raw("text/latex", s, id) }
+func (d *workerDisplayer) SVG(s string, id *string)        { d.Raw("image/svg+xml", s, id) }
+func (d *workerDisplayer) PNG(b []byte, id *string)        { d.Raw("image/png", b, id) }
+func (d *workerDisplayer) JPEG(b []byte, id *string)       { d.Raw("image/jpeg", b, id) }
+func (d *workerDisplayer) GIF(b []byte, id *string)        { d.Raw("image/gif", b, id) }
+func (d *workerDisplayer) PDF(b []byte, id *string)        { d.Raw("application/pdf", b, id) }
+func (d *workerDisplayer) Text(s string, id *string)       { d.Raw("text/plain", s, id) }
+
+// workerInputReader is InputReader in the worker. It reads inputs in the kernel.
+type workerInputReader struct {
+	kernel *rpc.Client
+}
+
+func (r *workerInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	var value string
+	call := r.kernel.Go("Kernel.ReadInput", &WorkerInputRequest{Prompt: prompt, Password: password}, &value, nil)
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return "", call.Error
+		}
+		return value, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func fileConn(fd uintptr, name string) (net.Conn, error) {
+	f := os.NewFile(fd, name)
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// ServeWorker serves requests from the kernel in a worker process started by UseWorker.
+// ServeWorker returns when ctx is done or the connection to the kernel is closed.
+func (rn *LgoRunner) ServeWorker(ctx context.Context) error {
+	conn, err := fileConn(workerConnFD, "lgo-worker")
+	if err != nil {
+		return fmt.Errorf("failed to open the worker connection: %v", err)
+	}
+	kernelConn, err := fileConn(kernelConnFD, "lgo-kernel")
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open the kernel connection: %v", err)
+	}
+	kernel := rpc.NewClient(kernelConn)
+	defer kernel.Close()
+	server := rpc.NewServer()
+	if err := server.RegisterName("Worker", &workerService{executor: rn.executor, kernel: kernel, comms: newWorkerCommManager(kernel)}); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(conn)
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		conn.Close()
+	case <-done:
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// testWorkerEnv is set when the test binary is started as a worker process by TestWorkerExecutor.
+const testWorkerEnv = "LGO_TEST_WORKER"
+
+type stdoutPrinter struct{}
+
+func (stdoutPrinter) Println(args ...interface{}) {
+	fmt.Fprintln(os.Stdout, args...)
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testWorkerEnv) == "" {
+		os.Exit(m.Run())
+	}
+	sessID := &SessionID{}
+	sessID.Unmarshal(os.Getenv(testWorkerEnv))
+	rn := NewLgoRunner(os.Getenv("LGOPATH"), sessID)
+	if err := rn.UseExecutor("plugin"); err != nil {
+		panic(err)
+	}
+	core.RegisterLgoPrinter(stdoutPrinter{})
+	if err := rn.ServeWorker(context.Background()); err != nil {
+		panic(err)
+	}
+	os.Exit(0)
+}
+
+type recordDisplayer struct {
+	core.DataDisplayer
+	raws    []string
+	results []string
+}
+
+func (d *recordDisplayer) DisplayResult(bundle map[string]interface{}) {
+	b, _ := json.Marshal(bundle)
+	d.results = append(d.results, string(b))
+}
+
+func (d *recordDisplayer) Raw(contentType string, v interface{}, id *string) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	d.raws = append(d.raws, contentType+":"+s)
+	return nil
+}
+
+// captureStdout runs f and returns outputs to os.Stdout in f.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+	func() {
+		defer func() {
+			os.Stdout = orig
+			w.Close()
+		}()
+		f()
+	}()
+	return <-out
+}
+
+func TestWorkerExecutor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Building plugins is slow")
+	}
+	lgopath, err := ioutil.TempDir("", "lgo_worker_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lgopath)
+	sessID := NewSessionID()
+	defer CleanSession(lgopath, sessID)
+	rn := NewLgoRunner(lgopath, sessID)
+	if err := rn.UseExecutor("plugin"); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(testWorkerEnv, sessID.Marshal())
+	os.Setenv("LGOPATH", lgopath)
+	defer os.Unsetenv(testWorkerEnv)
+	if err := rn.UseWorker(os.Args[0]); err != nil {
+		t.Fatal(err)
+	}
+	defer rn.executor.(*workerExecutor).proc.kill()
+
+	exec1 := path.Join(rn.sessDir(), "exec1")
+	writePkgSrc(t, exec1, `package exec1
+
+import "github.com/yunabe/lgo/core"
+
+var X int
+
+func lgo_init() {
+	X = 10
+	core.GetExecContext().Display.HTML("<b>hello</b>", nil)
+	core.LgoPrintln(X)
+	core.GetExecContext().Display.(core.ResultDisplayer).DisplayResult(core.RenderMimeBundle(X))
+}
+`)
+	exec2 := path.Join(rn.sessDir(), "exec2")
+	writePkgSrc(t, exec2, `package exec2
+
+import "os"
+
+func lgo_init() {
+	os.Exit(3)
+}
+`)
+	exec3 := path.Join(rn.sessDir(), "exec3")
+	writePkgSrc(t, exec3, `package exec3
+
+import (
+	"github.com/yunabe/lgo/core"
+	exec1 "`+exec1+`"
+)
+
+func lgo_init() {
+	core.LgoPrintln(exec1.X)
+}
+`)
+	exec4 := path.Join(rn.sessDir(), "exec4")
+	writePkgSrc(t, exec4, `package exec4
+
+func lgo_init() {
+	panic("boom")
+}
+`)
+	pkgs := map[string]*Package{
+		exec1: {Path: exec1, HasEntry: true},
+		exec2: {Path: exec2, HasEntry: true},
+		exec3: {Path: exec3, HasEntry: true},
+		exec4: {Path: exec4, HasEntry: true},
+	}
+	disp := &recordDisplayer{}
+	ctx := core.LgoContext{Context: context.Background(), Display: disp}
+	for _, pkg := range pkgs {
+		if err := rn.executor.Build(ctx, pkg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := captureStdout(t, func() {
+		if err := rn.executor.Load(ctx, pkgs[exec1]); err != nil {
+			t.Error(err)
+		}
+	})
+	if out != "10\n" {
+		t.Errorf("Got %q; want %q", out, "10\n")
+	}
+	if want := []string{"text/html:<b>hello</b>"}; !reflect.DeepEqual(disp.raws, want) {
+		t.Errorf("Got %q; want %q", disp.raws, want)
+	}
+	if want := []string{`{"text/plain":"10"}`}; !reflect.DeepEqual(disp.results, want) {
+		t.Errorf("Got %q; want %q", disp.results, want)
+	}
+
+	err = rn.executor.Load(ctx, pkgs[exec2])
+	if err == nil || !strings.Contains(err.Error(), "crashed: exit status 3") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := rn.executor.Load(ctx, pkgs[exec3]); err != errWorkerNotRunning {
+		t.Errorf("Got %v; want %v", err, errWorkerNotRunning)
+	}
+
+	if err := rn.RestartWorker(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// exec1 is reloaded but X is reset.
+	out = captureStdout(t, func() {
+		if err := rn.executor.Load(ctx, pkgs[exec3]); err != nil {
+			t.Error(err)
+		}
+	})
+	if out != "0\n" {
+		t.Errorf("Got %q; want %q", out, "0\n")
+	}
+
+	// The panic in the worker is returned with the stack.
+	err = rn.executor.Load(ctx, pkgs[exec4])
+	if p, ok := err.(*core.PanicError); !ok {
+		t.Errorf("Expected *core.PanicError but got %#v", err)
+	} else if p.Value != "boom" || p.Message != "main routine failed" || !strings.Contains(p.Stack, "lgo_init()\n\texec4:4\n") {
+		t.Errorf("Unexpected panic: %#v", p)
+	}
+}
+
+// fakeComm is Comm which records data sent to the frontend.
+type fakeComm struct {
+	id, targetName string
+	sent           []string
+	closed         bool
+	onMsg, onClose func(data map[string]interface{})
+}
+
+func (c *fakeComm) ID() string         { return c.id }
+func (c *fakeComm) TargetName() string { return c.targetName }
+
+func (c *fakeComm) Send(data interface{}) error {
+	b, err := json.Marshal(data)
+	c.sent = append(c.sent, string(b))
+	return err
+}
+
+func (c *fakeComm) Close(data interface{}) error {
+	c.closed = true
+	return nil
+}
+
+func (c *fakeComm) OnMsg(f func(data map[string]interface{}))   { c.onMsg = f }
+func (c *fakeComm) OnClose(f func(data map[string]interface{})) { c.onClose = f }
+
+// fakeCommManager is CommManager which records opened comms and registered targets.
+type fakeCommManager struct {
+	opened  []*fakeComm
+	targets map[string]func(c core.Comm, data map[string]interface{})
+}
+
+func (m *fakeCommManager) Open(targetName string, data interface{}, metadata map[string]interface{}) (core.Comm, error) {
+	c := &fakeComm{id: fmt.Sprintf("comm%d", len(m.opened)), targetName: targetName}
+	if err := c.Send(data); err != nil {
+		return nil, err
+	}
+	m.opened = append(m.opened, c)
+	return c, nil
+}
+
+func (m *fakeCommManager) RegisterTarget(targetName string, onOpen func(c core.Comm, data map[string]interface{})) {
+	m.targets[targetName] = onOpen
+}
+
+func TestWorkerExecutor_comm(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Building plugins is slow")
+	}
+	lgopath, err := ioutil.TempDir("", "lgo_worker_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lgopath)
+	sessID := NewSessionID()
+	defer CleanSession(lgopath, sessID)
+	rn := NewLgoRunner(lgopath, sessID)
+	if err := rn.UseExecutor("plugin"); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(testWorkerEnv, sessID.Marshal())
+	os.Setenv("LGOPATH", lgopath)
+	defer os.Unsetenv(testWorkerEnv)
+	if err := rn.UseWorker(os.Args[0]); err != nil {
+		t.Fatal(err)
+	}
+	defer rn.executor.(*workerExecutor).proc.kill()
+
+	exec1 := path.Join(rn.sessDir(), "exec1")
+	writePkgSrc(t, exec1, `package exec1
+
+import "github.com/yunabe/lgo/core"
+
+func lgo_init() {
+	comm := core.GetExecContext().Comm
+	c, err := comm.Open("echo", map[string]interface{}{"x": 1}, nil)
+	if err != nil {
+		panic(err)
+	}
+	c.OnMsg(func(data map[string]interface{}) {
+		if data["v"] == "panic" {
+			panic("bad message")
+		}
+		core.LgoPrintln("msg", data["v"])
+		core.GetExecContext().Display.Text("displayed", nil)
+		c.Send(map[string]interface{}{"echo": data["v"]})
+	})
+	comm.RegisterTarget("target", func(c core.Comm, data map[string]interface{}) {
+		core.LgoPrintln("open", c.ID(), data["a"])
+		c.Close(nil)
+	})
+}
+`)
+	pkg := &Package{Path: exec1, HasEntry: true}
+	comms := &fakeCommManager{targets: make(map[string]func(c core.Comm, data map[string]interface{}))}
+	disp := &recordDisplayer{}
+	ctx := core.LgoContext{Context: context.Background(), Display: disp, Comm: comms}
+	if err := rn.executor.Build(ctx, pkg); err != nil {
+		t.Fatal(err)
+	}
+	if err := rn.executor.Load(ctx, pkg); err != nil {
+		t.Fatal(err)
+	}
+	if len(comms.opened) != 1 || comms.targets["target"] == nil {
+		t.Fatalf("Unexpected comms: %#v", comms)
+	}
+	echo := comms.opened[0]
+	if want := []string{`{"x":1}`}; echo.targetName != "echo" || !reflect.DeepEqual(echo.sent, want) {
+		t.Errorf("Unexpected comm_open: %#v", echo)
+	}
+
+	// Callbacks are called as lgo code in the kernel.
+	out := captureStdout(t, func() {
+		if err := core.ExecLgoEntryPoint(ctx, func() { echo.onMsg(map[string]interface{}{"v": "hello"}) }); err != nil {
+			t.Error(err)
+		}
+	})
+	if want := "msg hello\n"; out != want {
+		t.Errorf("Got %q; want %q", out, want)
+	}
+	if want := []string{`{"x":1}`, `{"echo":"hello"}`}; !reflect.DeepEqual(echo.sent, want) {
+		t.Errorf("Got %q; want %q", echo.sent, want)
+	}
+	// Callbacks display data with Display of the kernel.
+	if want := []string{"text/plain:displayed"}; !reflect.DeepEqual(disp.raws, want) {
+		t.Errorf("Got %q; want %q", disp.raws, want)
+	}
+	// Panics in callbacks are reported with the stack in the worker.
+	err = core.ExecLgoEntryPoint(ctx, func() { echo.onMsg(map[string]interface{}{"v": "panic"}) })
+	if p, ok := err.(*core.PanicError); !ok {
+		t.Errorf("Expected *core.PanicError but got %#v", err)
+	} else if p.Value != "bad message" || !strings.Contains(p.Stack, "exec1:") {
+		t.Errorf("Unexpected panic: %#v", p)
+	}
+
+	opened := &fakeComm{id: "frontend1", targetName: "target"}
+	out = captureStdout(t, func() {
+		if err := core.ExecLgoEntryPoint(ctx, func() { comms.targets["target"](opened, map[string]interface{}{"a": 2}) }); err != nil {
+			t.Error(err)
+		}
+	})
+	if want := "open frontend1 2\n"; out != want {
+		t.Errorf("Got %q; want %q", out, want)
+	}
+	if !opened.closed {
+		t.Error("The comm opened by the frontend is not closed in the worker")
+	}
+}
@@ -0,0 +1,292 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"os"
+	"sync"
+
+	"github.com/yunabe/lgo/core"
+)
+
+// WorkerCommOpenRequest is a request from the worker to open a comm with the frontend.
+type WorkerCommOpenRequest struct {
+	TargetName string
+	// Data and Metadata are the JSON representations of data and metadata of comm_open.
+	Data     []byte
+	Metadata []byte
+}
+
+// WorkerCommRequest is a request from the worker to send data to a comm (Send or Close).
+// It is also used for callbacks of comms called in the worker.
+type WorkerCommRequest struct {
+	ID string
+	// TargetName is set only in callbacks of RegisterTarget.
+	TargetName string
+	// Data is the JSON representation of the data.
+	Data []byte
+}
+
+// commManager returns the CommManager of the kernel.
+func (h *workerHost) commManager() (core.CommManager, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.comm == nil {
+		return nil, errors.New("Comm is not available")
+	}
+	return h.comm, nil
+}
+
+// addComm registers c so that the worker can use it and forwards callbacks of c to the worker.
+func (h *workerHost) addComm(c core.Comm) {
+	h.mu.Lock()
+	if h.comms == nil {
+		h.comms = make(map[string]core.Comm)
+	}
+	h.comms[c.ID()] = c
+	h.mu.Unlock()
+	c.OnMsg(func(data map[string]interface{}) {
+		h.callbackWorker("Worker.CommMsg", c, "", data)
+	})
+	c.OnClose(func(data map[string]interface{}) {
+		h.mu.Lock()
+		delete(h.comms, c.ID())
+		h.mu.Unlock()
+		h.callbackWorker("Worker.CommClose", c, "", data)
+	})
+}
+
+func (h *workerHost) lookupComm(id string) (core.Comm, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.comms[id]
+	if c == nil {
+		return nil, fmt.Errorf("comm %s is not found", id)
+	}
+	return c, nil
+}
+
+// callbackWorker calls a callback of c in the worker. It is called in the kernel as a callback of comms
+// which runs in the execution context of the kernel. Thus, outputs of the callback are forwarded to the context.
+func (h *workerHost) callbackWorker(method string, c core.Comm, targetName string, data map[string]interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	h.setContext(core.GetExecContext())
+	defer h.setContext(core.LgoContext{})
+	reply := new(WorkerRunReply)
+	if err := h.callWorker(method, &WorkerCommRequest{ID: c.ID(), TargetName: targetName, Data: b}, reply); err != nil {
+		panic(err)
+	}
+	if reply.Panic != nil {
+		// Rethrow the panic in the worker so that the kernel reports it with the stack in the worker.
+		panic(reply.Panic)
+	}
+}
+
+func (h *workerHost) OpenComm(req *WorkerCommOpenRequest, id *string) error {
+	m, err := h.commManager()
+	if err != nil {
+		return err
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(req.Metadata, &metadata); err != nil {
+		return err
+	}
+	c, err := m.Open(req.TargetName, json.RawMessage(req.Data), metadata)
+	if err != nil {
+		return err
+	}
+	h.addComm(c)
+	*id = c.ID()
+	return nil
+}
+
+func (h *workerHost) RegisterCommTarget(targetName string, _ *bool) error {
+	m, err := h.commManager()
+	if err != nil {
+		return err
+	}
+	m.RegisterTarget(targetName, func(c core.Comm, data map[string]interface{}) {
+		h.addComm(c)
+		h.callbackWorker("Worker.CommOpen", c, targetName, data)
+	})
+	return nil
+}
+
+func (h *workerHost) CommSend(req *WorkerCommRequest, _ *bool) error {
+	c, err := h.lookupComm(req.ID)
+	if err != nil {
+		return err
+	}
+	return c.Send(json.RawMessage(req.Data))
+}
+
+func (h *workerHost) CommClose(req *WorkerCommRequest, _ *bool) error {
+	c, err := h.lookupComm(req.ID)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	delete(h.comms, req.ID)
+	h.mu.Unlock()
+	return c.Close(json.RawMessage(req.Data))
+}
+
+// workerCommManager is CommManager in the worker. Comms are opened in the kernel and
+// callbacks of comms are called from the kernel through workerService.
+type workerCommManager struct {
+	kernel *rpc.Client
+
+	mu      sync.Mutex
+	comms   map[string]*workerComm
+	targets map[string]func(c core.Comm, data map[string]interface{})
+}
+
+func newWorkerCommManager(kernel *rpc.Client) *workerCommManager {
+	return &workerCommManager{
+		kernel:  kernel,
+		comms:   make(map[string]*workerComm),
+		targets: make(map[string]func(c core.Comm, data map[string]interface{})),
+	}
+}
+
+func (m *workerCommManager) addComm(id, targetName string) *workerComm {
+	c := &workerComm{id: id, targetName: targetName, manager: m}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.comms[id] = c
+	return c
+}
+
+func (m *workerCommManager) Open(targetName string, data interface{}, metadata map[string]interface{}) (core.Comm, error) {
+	req := &WorkerCommOpenRequest{TargetName: targetName}
+	var err error
+	if req.Data, err = json.Marshal(data); err != nil {
+		return nil, err
+	}
+	if req.Metadata, err = json.Marshal(metadata); err != nil {
+		return nil, err
+	}
+	var id string
+	if err := m.kernel.Call("Kernel.OpenComm", req, &id); err != nil {
+		return nil, err
+	}
+	return m.addComm(id, targetName), nil
+}
+
+func (m *workerCommManager) RegisterTarget(targetName string, onOpen func(c core.Comm, data map[string]interface{})) {
+	m.mu.Lock()
+	m.targets[targetName] = onOpen
+	m.mu.Unlock()
+	if err := m.kernel.Call("Kernel.RegisterCommTarget", targetName, new(bool)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to register the comm target %s: %v\n", targetName, err)
+	}
+}
+
+// callback returns the function to handle a callback from the kernel. It returns nil if no callback is set.
+func (m *workerCommManager) callback(method string, req *WorkerCommRequest) (func(), error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(req.Data, &data); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if method == "CommOpen" {
+		onOpen := m.targets[req.TargetName]
+		if onOpen == nil {
+			return nil, nil
+		}
+		c := &workerComm{id: req.ID, targetName: req.TargetName, manager: m}
+		m.comms[req.ID] = c
+		return func() { onOpen(c, data) }, nil
+	}
+	c := m.comms[req.ID]
+	if c == nil {
+		return nil, nil
+	}
+	var f func(data map[string]interface{})
+	if method == "CommMsg" {
+		f = c.onMsg
+	} else {
+		delete(m.comms, req.ID)
+		f = c.onClose
+	}
+	if f == nil {
+		return nil, nil
+	}
+	return func() { f(data) }, nil
+}
+
+// workerComm is Comm in the worker.
+type workerComm struct {
+	id         string
+	targetName string
+	manager    *workerCommManager
+	// onMsg and onClose are guarded by manager.mu.
+	onMsg   func(data map[string]interface{})
+	onClose func(data map[string]interface{})
+}
+
+func (c *workerComm) ID() string         { return c.id }
+func (c *workerComm) TargetName() string { return c.targetName }
+
+func (c *workerComm) call(method string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.manager.kernel.Call(method, &WorkerCommRequest{ID: c.id, Data: b}, new(bool))
+}
+
+func (c *workerComm) Send(data interface{}) error {
+	return c.call("Kernel.CommSend", data)
+}
+
+func (c *workerComm) Close(data interface{}) error {
+	c.manager.mu.Lock()
+	delete(c.manager.comms, c.id)
+	c.manager.mu.Unlock()
+	return c.call("Kernel.CommClose", data)
+}
+
+func (c *workerComm) OnMsg(f func(data map[string]interface{})) {
+	c.manager.mu.Lock()
+	defer c.manager.mu.Unlock()
+	c.onMsg = f
+}
+
+func (c *workerComm) OnClose(f func(data map[string]interface{})) {
+	c.manager.mu.Lock()
+	defer c.manager.mu.Unlock()
+	c.onClose = f
+}
+
+// runCommCallback runs a callback of a comm called from the kernel as lgo code.
+func (s *workerService) runCommCallback(method string, req *WorkerCommRequest, reply *WorkerRunReply) error {
+	f, err := s.comms.callback(method, req)
+	if err != nil || f == nil {
+		return err
+	}
+	return setPanicReply(s.run(func(ctx core.LgoContext) error {
+		return core.ExecLgoEntryPoint(ctx, f)
+	}), reply)
+}
+
+// CommOpen calls the callback of RegisterTarget when the frontend opens a comm.
+func (s *workerService) CommOpen(req *WorkerCommRequest, reply *WorkerRunReply) error {
+	return s.runCommCallback("CommOpen", req, reply)
+}
+
+// CommMsg calls the callback of OnMsg of a comm.
+func (s *workerService) CommMsg(req *WorkerCommRequest, reply *WorkerRunReply) error {
+	return s.runCommCallback("CommMsg", req, reply)
+}
+
+// CommClose calls the callback of OnClose of a comm.
+func (s *workerService) CommClose(req *WorkerCommRequest, reply *WorkerRunReply) error {
+	return s.runCommCallback("CommClose", req, reply)
+}
@@ -0,0 +1,273 @@
+package converter
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgoPkgPath is the import path of the pseudo package of cgo.
+const cgoPkgPath = "C"
+
+// cgoExportPrefix is the prefix of //export comments of cgo.
+const cgoExportPrefix = "//export "
+
+// cgoTypePrefix is the prefix of aliases of C types declared in cells which import "C".
+// Later cells refer to C types through the aliases because "C" is available only in the cell which imports it.
+const cgoTypePrefix = "LgoCtype_"
+
+// cgoNumericTypes returns the numeric types of cgo (e.g. C.int) and their underlying Go types.
+// The types are listed in https://golang.org/cmd/cgo/#hdr-Go_references_to_C.
+func cgoNumericTypes() []*types.TypeName {
+	char := types.Int8
+	switch runtime.GOARCH {
+	case "arm", "arm64", "ppc64", "ppc64le", "s390x":
+		// char is unsigned on these architectures.
+		char = types.Uint8
+	}
+	long, ulong := types.Int64, types.Uint64
+	if strconv.IntSize == 32 {
+		long, ulong = types.Int32, types.Uint32
+	}
+	kinds := []struct {
+		name string
+		kind types.BasicKind
+	}{
+		{"char", char},
+		{"schar", types.Int8},
+		{"uchar", types.Uint8},
+		{"short", types.Int16},
+		{"ushort", types.Uint16},
+		{"int", types.Int32},
+		{"uint", types.Uint32},
+		{"long", long},
+		{"ulong", ulong},
+		{"longlong", types.Int64},
+		{"ulonglong", types.Uint64},
+		{"float", types.Float32},
+		{"double", types.Float64},
+	}
+	var names []*types.TypeName
+	for _, k := range kinds {
+		names = append(names, types.NewTypeName(token.NoPos, nil, k.name, types.Typ[k.kind]))
+	}
+	return names
+}
+
+// declareCgoTypes declares the numeric types of cgo in fake, the empty "C" package created by the type checker.
+// C types are named types declared in pkg with cgoTypePrefix so that values of C types are passed to later cells
+// as values of the aliases declared by cgoTypeAliases.
+func declareCgoTypes(pkg, fake *types.Package) {
+	for _, t := range cgoNumericTypes() {
+		named := types.NewNamed(types.NewTypeName(token.NoPos, pkg, cgoTypePrefix+t.Name(), nil), t.Type(), nil)
+		fake.Scope().Insert(types.NewTypeName(token.NoPos, fake, t.Name(), named))
+	}
+}
+
+// cgoTypeAliases returns the declaration of the aliases of the numeric types of cgo (e.g. LgoCtype_int = C.int)
+// if imports contains "C". Otherwise, it returns nil.
+func cgoTypeAliases(imports []*ast.ImportSpec) ast.Decl {
+	var found bool
+	for _, spec := range imports {
+		if isCgoImport(spec) {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	var specs []ast.Spec
+	for _, t := range cgoNumericTypes() {
+		specs = append(specs, &ast.TypeSpec{
+			Name: ast.NewIdent(cgoTypePrefix + t.Name()),
+			// Assign must be valid to declare an alias.
+			Assign: 1,
+			Type: &ast.SelectorExpr{
+				X:   ast.NewIdent("C"),
+				Sel: ast.NewIdent(t.Name()),
+			},
+		})
+	}
+	return &ast.GenDecl{
+		// go/printer prints multiple specs only when Lparen is set.
+		Lparen: 1,
+		Rparen: 2,
+		Tok:    token.TYPE,
+		Specs:  specs,
+	}
+}
+
+// rewriteOldCgoTypes rewrites C types in a cell which refers to "C" imported in a previous cell
+// with the aliases declared in the previous cell (e.g. C.int to exec1.LgoCtype_int).
+// It returns an error if other names in "C" are used because the preamble of cgo is available only in the previous cell.
+func rewriteOldCgoTypes(file *ast.File, fset *token.FileSet, checker *types.Checker, oldImports []*types.PkgName, immg *importManager) error {
+	isOldC := make(map[types.Object]bool)
+	for _, im := range oldImports {
+		if im.Imported().Path() == cgoPkgPath {
+			isOldC[im] = true
+		}
+	}
+	if len(isOldC) == 0 {
+		return nil
+	}
+	var errs ErrorList
+	rewriteExpr(file, func(expr ast.Expr) ast.Expr {
+		sel, ok := expr.(*ast.SelectorExpr)
+		if !ok {
+			return expr
+		}
+		if x, ok := sel.X.(*ast.Ident); !ok || !isOldC[checker.Uses[x]] {
+			return expr
+		}
+		if tname, ok := checker.Uses[sel.Sel].(*types.TypeName); ok {
+			if named, ok := tname.Type().(*types.Named); ok {
+				return &ast.SelectorExpr{
+					X:   &ast.Ident{Name: immg.shortName(named.Obj().Pkg())},
+					Sel: &ast.Ident{Name: named.Obj().Name()},
+				}
+			}
+		}
+		errs.Add(types.Error{
+			Fset: fset,
+			Pos:  sel.Pos(),
+			Msg:  fmt.Sprintf("C.%s is not available in this cell; import \"C\" with the preamble in this cell to use it", sel.Sel.Name),
+		})
+		return expr
+	})
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errs
+}
+
+// refersToC returns true if node refers to a name in the pseudo package "C" (e.g. C.int).
+func refersToC(node ast.Node, checker *types.Checker) bool {
+	var found bool
+	ast.Inspect(node, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok {
+			if pname, ok := checker.Uses[id].(*types.PkgName); ok && pname.Imported().Path() == cgoPkgPath {
+				found = true
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// checkCgoVarTypes returns an error if the type of a variable defined in stmts is unknown.
+// The types checker does not know types of names in "C" (e.g. the result of C.f()).
+// Thus, variables defined from values of C without types can not be declared in the converted code.
+func checkCgoVarTypes(stmts []ast.Stmt, fset *token.FileSet, checker *types.Checker) error {
+	var vars []*ast.Ident
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.AssignStmt:
+			if stmt.Tok != token.DEFINE {
+				continue
+			}
+			for _, lhs := range stmt.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok {
+					vars = append(vars, id)
+				}
+			}
+		case *ast.DeclStmt:
+			gen, ok := stmt.Decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				if spec := spec.(*ast.ValueSpec); spec.Type == nil {
+					vars = append(vars, spec.Names...)
+				}
+			}
+		}
+	}
+	var errs ErrorList
+	for _, id := range vars {
+		obj := checker.Defs[id]
+		if obj == nil || isValidTypeObject(obj) {
+			continue
+		}
+		errs.Add(types.Error{
+			Fset: fset,
+			Pos:  id.Pos(),
+			Msg:  fmt.Sprintf("can not infer the type of %s from C; declare it with the type (e.g. var %s C.int = ...)", id.Name, id.Name),
+		})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errs
+}
+
+// cgoExportName returns the name in the //export comment of fdecl if exists.
+func cgoExportName(fdecl *ast.FuncDecl) string {
+	if fdecl.Doc == nil {
+		return ""
+	}
+	for _, c := range fdecl.Doc.List {
+		if strings.HasPrefix(c.Text, cgoExportPrefix) {
+			return strings.TrimSpace(c.Text[len(cgoExportPrefix):])
+		}
+	}
+	return ""
+}
+
+// exportCgoFuncs adds wrappers of functions with //export comments.
+// Functions in cells are renamed (e.g. f to LgoExport_f) to refer them from other cells though cgo requires
+// the name of an exported function is same as the name in the //export comment.
+// Thus, exportCgoFuncs moves the //export comment to a wrapper function with the original name which calls the renamed function.
+func exportCgoFuncs(file *ast.File, checker *types.Checker) {
+	picker := newNamePicker(checker.Defs)
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		decls = append(decls, decl)
+		fdecl, ok := decl.(*ast.FuncDecl)
+		if !ok || fdecl.Recv != nil {
+			continue
+		}
+		name := cgoExportName(fdecl)
+		if name == "" || name == fdecl.Name.Name {
+			continue
+		}
+		// cgo rejects //export comments with names different from functions.
+		pos := fdecl.Pos()
+		fdecl.Doc = nil
+		typ := cloneNode(fdecl.Type).(*ast.FuncType)
+		// The printer prints a comment before the node which follows it. Thus, the positions are necessary.
+		typ.Func = pos
+		call := &ast.CallExpr{
+			Fun:  &ast.Ident{Name: fdecl.Name.Name},
+			Args: nameParams(typ.Params, picker),
+		}
+		var body ast.Stmt = &ast.ExprStmt{X: call}
+		if typ.Results != nil && len(typ.Results.List) > 0 {
+			body = &ast.ReturnStmt{Results: []ast.Expr{call}}
+		}
+		decls = append(decls, &ast.FuncDecl{
+			Doc:  &ast.CommentGroup{List: []*ast.Comment{{Slash: pos - 1, Text: cgoExportPrefix + name}}},
+			Name: &ast.Ident{Name: name},
+			Type: typ,
+			Body: &ast.BlockStmt{List: []ast.Stmt{body}},
+		})
+	}
+	file.Decls = decls
+}
+
+// isCgoImport returns true if spec imports "C".
+func isCgoImport(spec *ast.ImportSpec) bool {
+	return spec.Path.Value == `"`+cgoPkgPath+`"`
+}
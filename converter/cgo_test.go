@@ -0,0 +1,244 @@
+package converter
+
+import (
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func TestConvert_cgo(t *testing.T) {
+	result := Convert(`
+// #include <stdio.h>
+// int twice(int x) { return 2 * x; }
+import "C"
+
+//export callback
+func callback(x C.int) C.int {
+	return C.twice(x)
+}
+
+var y C.int = C.twice(3)
+`, &Config{LgoPkgPath: "lgo/pkg0", DefPrefix: "LgoExport_", RefPrefix: "LgoExport_", RedefinableFuncs: true, LineDirectives: true})
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	for _, want := range []string{
+		"// #include <stdio.h>\n// int twice(int x) { return 2 * x; }\nimport \"C\"\n",
+		"//export callback\nfunc callback(x C.int) C.int { return LgoExport_callback(x) }\n",
+		"/*line :11:5*/LgoExport_y /*line :11:7*/C.int\n",
+	} {
+		if !strings.Contains(string(result.Src), want) {
+			t.Errorf("%q is not found in\n%s", want, result.Src)
+		}
+	}
+	checkCgoExportDoc(t, string(result.Src), "callback")
+	var hasC bool
+	for _, im := range result.Imports {
+		if im.Imported().Path() == "C" {
+			hasC = true
+		}
+	}
+	if !hasC {
+		t.Errorf("C must be passed to later cells: %v", result.Imports)
+	}
+}
+
+func TestConvert_cgoTypesInLaterCells(t *testing.T) {
+	result := Convert(`
+// double half(double x) { return x / 2; }
+import "C"
+
+x := C.double(3)
+`, &Config{LgoPkgPath: "lgo/exec1", DefPrefix: "LgoExport_", RefPrefix: "LgoExport_"})
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	checkGolden(t, result.Src, "testdata/cgo_types0.golden")
+	var olds []types.Object
+	for _, name := range result.Pkg.Scope().Names() {
+		olds = append(olds, result.Pkg.Scope().Lookup(name))
+	}
+	conf := &Config{LgoPkgPath: "lgo/exec2", Olds: olds, OldImports: result.Imports, DefPrefix: "LgoExport_", RefPrefix: "LgoExport_"}
+	result = Convert(`
+var y C.double = x * 2
+z := C.int(y)
+`, conf)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	checkGolden(t, result.Src, "testdata/cgo_types1.golden")
+
+	result = Convert(`var w C.double = C.half(x)`, conf)
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "C.half is not available in this cell") {
+		t.Errorf("Unexpected error: %v", result.Err)
+	}
+}
+
+// checkCgoExportDoc checks that cgo can read the //export comment of the function name in src.
+func checkCgoExportDoc(t *testing.T, src, name string) {
+	f, err := goparser.ParseFile(token.NewFileSet(), "src.go", src, goparser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range f.Decls {
+		if fdecl, ok := decl.(*ast.FuncDecl); ok && fdecl.Name.Name == name {
+			if got := cgoExportName(fdecl); got != name {
+				t.Errorf("//export comment of %s is not found in the doc: %#v\n%s", name, fdecl.Doc, src)
+			}
+			return
+		}
+	}
+	t.Errorf("%s is not found in\n%s", name, src)
+}
+
+func TestAddLineDirectives_cgoExport(t *testing.T) {
+	file, fset, _, checker := checkTestFile(t, "lgo/exec1", `package p
+
+import "C"
+
+//export f
+func f(x C.int) C.int {
+	return  2 * x
+}
+
+//export G
+func G() {
+	f(3)
+}
+`, nil)
+	for _, decl := range file.Decls {
+		if fdecl, ok := decl.(*ast.FuncDecl); ok && fdecl.Name.Name == "f" {
+			fdecl.Name.Name = "LgoExport_f"
+		}
+	}
+	exportCgoFuncs(file, checker)
+	src, err := printFinalResult(file, fset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := addLineDirectives(src, file, fset)
+	for _, name := range []string{"f", "G"} {
+		checkCgoExportDoc(t, got, name)
+	}
+	if !strings.Contains(got, "return /*line :7:10*/2 * x") {
+		t.Errorf("Directives are not inserted into functions:\n%s", got)
+	}
+}
+
+func TestConvert_cgoVarWithoutType(t *testing.T) {
+	result := Convert(`
+// int twice(int x) { return 2 * x; }
+import "C"
+
+x := C.twice(3)
+`, &Config{LgoPkgPath: "lgo/pkg0"})
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "can not infer the type of x from C") {
+		t.Errorf("Unexpected error: %v", result.Err)
+	}
+}
+
+func TestExportCgoFuncs(t *testing.T) {
+	file, fset, _, checker := checkTestFile(t, "lgo/exec1", `package p
+
+import "C"
+
+// f doubles x.
+//export f
+func f(x C.int, _ C.int) C.int {
+	return 2 * x
+}
+
+//export g
+func g() {}
+
+//export H
+func H() {}
+`, nil)
+	for _, decl := range file.Decls {
+		if fdecl, ok := decl.(*ast.FuncDecl); ok && fdecl.Name.Name != "H" {
+			fdecl.Name.Name = "LgoExport_" + fdecl.Name.Name
+		}
+	}
+	exportCgoFuncs(file, checker)
+	got, err := printFinalResult(file, fset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package p
+
+import "C"
+func LgoExport_f(x C.int, _ C.int) C.int {
+	return 2 * x
+}
+//export f
+func f(x C.int, arg C.int) C.int { return LgoExport_f(x, arg) }
+func LgoExport_g() {}
+//export g
+func g() { LgoExport_g() }
+//export H
+func H() {}
+`
+	if got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCheckCgoVarTypes(t *testing.T) {
+	file, fset, _, checker := checkTestFile(t, "lgo/exec1", `package p
+
+import "C"
+
+func init() {
+	x := C.int(3)
+	var y = C.f()
+	var z C.int = C.f()
+	w := 3
+	_, _, _, _ = x, y, z, w
+}
+`, nil)
+	body := file.Decls[1].(*ast.FuncDecl).Body.List
+	err := checkCgoVarTypes(body, fset, checker)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, name := range []string{"x", "y"} {
+		if msg := errs[i].Error(); !strings.Contains(msg, "can not infer the type of "+name+" from C") {
+			t.Errorf("Unexpected error: %s", msg)
+		}
+	}
+	if err := checkCgoVarTypes(body[2:], fset, checker); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// TestFakeCHook checks the changes to the vendored go/types which declare C types in the fake "C" package.
+// See vendor/README.md.
+func TestFakeCHook(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := goparser.ParseFile(fset, "p.go", "package p\n\nimport \"C\"\n\nvar x C.double\nvar y = C.int(1)\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	conf := &types.Config{
+		FakeImportC: true,
+		InitFakeC:   declareCgoTypes,
+		Error:       func(err error) { errs = append(errs, err) },
+	}
+	pkg, _ := conf.Check("lgo/p", fset, []*ast.File{f}, nil)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	for name, want := range map[string]string{
+		"x": "lgo/p.LgoCtype_double",
+		"y": "lgo/p.LgoCtype_int",
+	} {
+		if got := pkg.Scope().Lookup(name).Type().String(); got != want {
+			t.Errorf("The type of %s is %s; want %s", name, got, want)
+		}
+	}
+}
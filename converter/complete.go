@@ -297,6 +297,8 @@ func completeWithChecker(target completeTarget, checker *types.Checker, pkg *typ
 
 // Complete returns a list of candidates of code completion.
 func Complete(src string, pos token.Pos, conf *Config) ([]string, int, int) {
+	checkMu.Lock()
+	defer checkMu.Unlock()
 	match, start, end := removeGoAndDeferKeywordsAndComplete(src, pos, conf)
 
 	// case-insensitive sort
@@ -374,6 +376,8 @@ func complete(src string, pos token.Pos, conf *Config) ([]string, int, int) {
 
 	chConf := &types.Config{
 		Importer:          lgoImporter,
+		FakeImportC:       true,
+		InitFakeC:         declareCgoTypes,
 		Error:             func(err error) {},
 		IgnoreFuncBodies:  true,
 		DontIgnoreLgoInit: true,
@@ -395,7 +399,9 @@ func complete(src string, pos token.Pos, conf *Config) ([]string, int, int) {
 	convertToPhase2(phase1, pkg, checker, conf)
 	{
 		chConf := &types.Config{
-			Importer: newImporterWithOlds(conf.Olds),
+			Importer:    newImporterWithOlds(conf.Olds),
+			FakeImportC: true,
+			InitFakeC:   declareCgoTypes,
 			Error: func(err error) {
 				// Ignore errors.
 				// It is necessary to set this noop func because checker stops analyzing code
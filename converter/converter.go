@@ -19,15 +19,23 @@ import (
 	"github.com/yunabe/lgo/parser"
 )
 
-const lgoInitFuncName = "lgo_init"
+// LgoInitFuncName is the name of the function which runs statements in lgo code.
+const LgoInitFuncName = "lgo_init"
 const lgoPackageName = "lgo_exec" // TODO: Set a proper name.
 const runCtxName = "_ctx"
 
 var lgoImporter = importer.Default()
 
+// checkMu serializes type checking in Convert, Complete and InspectIdent, which may be called from multiple goroutines
+// (e.g. code completion while a cell is running). lgoImporter is not goroutine-safe and
+// it updates packages imported before lazily when their dependencies are imported.
+var checkMu sync.Mutex
+
 // SetLGOImporter sets a global types.Importer used in this package.
 // This method is used in cmd/lgo-internal to install missing .a files to the system.
 func SetLGOImporter(im types.Importer) {
+	checkMu.Lock()
+	defer checkMu.Unlock()
 	lgoImporter = im
 }
 
@@ -185,13 +193,16 @@ func convertToPhase1(blk *parser.LGOBlock) (out phase1Out) {
 	}
 
 	out.initFunc = &ast.FuncDecl{
-		Name: ast.NewIdent(lgoInitFuncName),
+		Name: ast.NewIdent(LgoInitFuncName),
 		Type: &ast.FuncType{},
 		Body: &ast.BlockStmt{
 			List: initBody,
 		},
 	}
 	decls = append(decls, out.initFunc)
+	if aliases := cgoTypeAliases(blk.Imports); aliases != nil {
+		decls = append(decls, aliases)
+	}
 	out.file = &ast.File{
 		Package:    token.NoPos,
 		Name:       ast.NewIdent(lgoPackageName),
@@ -243,6 +254,14 @@ func convertToPhase2(ph1 phase1Out, pkg *types.Package, checker *types.Checker,
 				for _, spec := range gen.Specs {
 					spec := spec.(*ast.ValueSpec)
 					for i, name := range spec.Names {
+						if spec.Type != nil && !isValidTypeObject(checker.Defs[name]) && refersToC(spec.Type, checker) {
+							// The type checker does not know types in C. Reuse spec.Type as is.
+							varSpecs = append(varSpecs, &ast.ValueSpec{
+								Names: []*ast.Ident{name},
+								Type:  spec.Type,
+							})
+							continue
+						}
 						if i == 0 && spec.Type != nil {
 							// Reuses spec.Type so that we can keep original nodes as far as possible.
 							// TODO: Reuse spec for all `i` if spec.Type != nil.
@@ -456,6 +475,11 @@ type Config struct {
 	LgoPkgPath   string
 	AutoExitCode bool
 	RegisterVars bool
+	// If RedefinableFuncs is true, top-level functions redefined in later cells are replaced with new definitions.
+	RedefinableFuncs bool
+	// If LineDirectives is true, line directives are inserted into the converted code
+	// so that the compiler reports errors with lines and columns in the lgo source.
+	LineDirectives bool
 }
 
 // A ConvertResult is a result of code conversion by Convert.
@@ -524,6 +548,8 @@ func (v *findIdentVisitor) Visit(node ast.Node) ast.Visitor {
 
 // InspectIdent shows a document or a query for go doc command for the identifier at pos.
 func InspectIdent(src string, pos token.Pos, conf *Config) (doc, query string) {
+	checkMu.Lock()
+	defer checkMu.Unlock()
 	obj, local := inspectObject(src, pos, conf)
 	if obj == nil {
 		return
@@ -590,7 +616,9 @@ func inspectObject(src string, pos token.Pos, conf *Config) (obj types.Object, i
 
 	// var errs []error
 	chConf := &types.Config{
-		Importer: lgoImporter,
+		Importer:    lgoImporter,
+		FakeImportC: true,
+		InitFakeC:   declareCgoTypes,
 		Error: func(err error) {
 			//	errs = append(errs, err)
 		},
@@ -610,7 +638,9 @@ func inspectObject(src string, pos token.Pos, conf *Config) (obj types.Object, i
 	convertToPhase2(phase1, pkg, checker, conf)
 	{
 		chConf := &types.Config{
-			Importer: newImporterWithOlds(conf.Olds),
+			Importer:    newImporterWithOlds(conf.Olds),
+			FakeImportC: true,
+			InitFakeC:   declareCgoTypes,
 			Error: func(err error) {
 				// Ignore errors.
 				// It is necessary to set this noop func because checker stops analyzing code
@@ -792,6 +822,8 @@ func Convert(src string, conf *Config) *ConvertResult {
 		return &ConvertResult{Err: err}
 	}
 	maybeInstallPackageArchives(blk.Imports)
+	checkMu.Lock()
+	defer checkMu.Unlock()
 	phase1 := convertToPhase1(blk)
 
 	// TODO: Add a proper name to the package though it's not used at this moment.
@@ -806,7 +838,9 @@ func Convert(src string, conf *Config) *ConvertResult {
 
 	var errs []error
 	chConf := &types.Config{
-		Importer: lgoImporter,
+		Importer:    lgoImporter,
+		FakeImportC: true,
+		InitFakeC:   declareCgoTypes,
 		Error: func(err error) {
 			errs = append(errs, err)
 		},
@@ -822,6 +856,7 @@ func Convert(src string, conf *Config) *ConvertResult {
 	checker := types.NewChecker(chConf, fset, pkg, &info)
 	checker.Files([]*ast.File{phase1.file})
 	if len(errs) > 0 {
+		annotateStaleTypeErrors(errs, pkg.Scope(), conf.Olds)
 		var err error
 		if len(errs) > 1 {
 			err = ErrorList(errs)
@@ -830,6 +865,9 @@ func Convert(src string, conf *Config) *ConvertResult {
 		}
 		return &ConvertResult{Err: err}
 	}
+	if err := checkCgoVarTypes(phase1.initFunc.Body.List, fset, checker); err != nil {
+		return &ConvertResult{Err: err}
+	}
 	convertToPhase2(phase1, pkg, checker, conf)
 
 	fsrc, fpkg, fcheck, finalDeps, err := finalCheckAndRename(phase1.file, fset, conf)
@@ -841,10 +879,20 @@ func Convert(src string, conf *Config) *ConvertResult {
 	fscope := checker.Scopes[phase1.file]
 	for _, name := range fscope.Names() {
 		obj := fscope.Lookup(name)
-		if pname, ok := obj.(*types.PkgName); ok {
+		if pname, ok := obj.(*types.PkgName); ok && pname.Imported().Path() != cgoPkgPath {
 			imports = append(imports, pname)
 		}
 	}
+	// "C" is passed to later cells from the final check because C types in "C" are declared in fpkg.
+	// Later cells can use only C types in "C" because the preamble of cgo is available only in this cell.
+	for i := 0; i < fpkg.Scope().NumChildren(); i++ {
+		fscope := fpkg.Scope().Child(i)
+		for _, name := range fscope.Names() {
+			if pname, ok := fscope.Lookup(name).(*types.PkgName); ok && pname.Imported().Path() == cgoPkgPath {
+				imports = append(imports, pname)
+			}
+		}
+	}
 
 	return &ConvertResult{
 		Src:       fsrc,
@@ -946,7 +994,9 @@ func prependPrefixToID(indent *ast.Ident, prefix string) {
 func checkFileInPhase2(conf *Config, file *ast.File, fset *token.FileSet) (checker *types.Checker, pkg *types.Package, runctx types.Object, oldImports []*types.PkgName, err error) {
 	var errs []error
 	chConf := &types.Config{
-		Importer: newImporterWithOlds(conf.Olds),
+		Importer:    newImporterWithOlds(conf.Olds),
+		FakeImportC: true,
+		InitFakeC:   declareCgoTypes,
 		Error: func(err error) {
 			errs = append(errs, err)
 		},
@@ -971,6 +1021,7 @@ func checkFileInPhase2(conf *Config, file *ast.File, fset *token.FileSet) (check
 	checker = types.NewChecker(chConf, fset, pkg, info)
 	checker.Files([]*ast.File{file})
 	if errs != nil {
+		annotateStaleTypeErrors(errs, pkg.Scope(), conf.Olds)
 		// TODO: Return all errors.
 		err = errs[0]
 		return
@@ -1059,7 +1110,7 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 	}
 
 	for ident, obj := range checker.Defs {
-		if ast.IsExported(ident.Name) || ident.Name == lgoInitFuncName {
+		if ast.IsExported(ident.Name) || ident.Name == LgoInitFuncName {
 			continue
 		}
 		if obj == nil {
@@ -1081,6 +1132,14 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 	}
 	immg := newImportManager(pkg, file, checker)
 	prependPkgToOlds(conf, checker, file, immg)
+	if err := rewriteOldCgoTypes(file, fset, checker, oldImports, immg); err != nil {
+		return "", nil, nil, nil, err
+	}
+	if conf.RedefinableFuncs {
+		if init := redefinitionInit(file, checker, conf, immg); init != nil {
+			file.Decls = append(file.Decls, init)
+		}
+	}
 	rewriteExpr(file, func(expr ast.Expr) ast.Expr {
 		// Rewrite _ctx with core.GetExecContext().
 		id, ok := expr.(*ast.Ident)
@@ -1115,7 +1174,8 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 	}
 	// Import old imports.
 	for _, im := range oldImports {
-		if !im.Used() {
+		if !im.Used() || im.Imported().Path() == cgoPkgPath {
+			// C types in "C" of old cells are rewritten by rewriteOldCgoTypes.
 			continue
 		}
 		newDecls = append(newDecls, &ast.GenDecl{
@@ -1150,7 +1210,7 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 			if pname == nil {
 				panic(fmt.Sprintf("*types.PkgName for %v not found", spec))
 			}
-			if !pname.Used() {
+			if !pname.Used() && !isCgoImport(spec) {
 				spec.Name = ast.NewIdent("_")
 			}
 			specs = append(specs, spec)
@@ -1183,6 +1243,10 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 			prependPrefixToID(ident, conf.RefPrefix)
 		}
 	}
+	if conf.RedefinableFuncs {
+		injectRedefinitionHooks(file, checker)
+	}
+	exportCgoFuncs(file, checker)
 
 	var deps []string
 	for _, decl := range file.Decls {
@@ -1205,6 +1269,9 @@ func finalCheckAndRename(file *ast.File, fset *token.FileSet, conf *Config) (str
 	if err != nil {
 		return "", nil, nil, nil, err
 	}
+	if conf.LineDirectives {
+		finalSrc = addLineDirectives(finalSrc, file, fset)
+	}
 	return finalSrc, pkg, checker, deps, nil
 }
 
@@ -0,0 +1,101 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+
+	"github.com/yunabe/lgo/parser"
+)
+
+// nodesInOrder returns nodes in file in the depth-first order.
+// Empty field lists are skipped because the converter and the parser represent them differently (nil or empty).
+func nodesInOrder(file *ast.File) []ast.Node {
+	var nodes []ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if l, ok := n.(*ast.FieldList); ok && len(l.List) == 0 {
+			return false
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+		return true
+	})
+	return nodes
+}
+
+// addLineDirectives inserts /*line :line:col*/ directives into src printed from file
+// so that errors reported by the compiler refer to lines and columns in the lgo source parsed with fset.
+// The filename is omitted in directives to keep the name of the file of src.
+//
+// To find the positions of nodes of file in src, addLineDirectives parses src again and
+// matches nodes of the two ASTs in the depth-first order.
+// If the structures of the ASTs are different, src is returned as is.
+func addLineDirectives(src string, file *ast.File, fset *token.FileSet) string {
+	pfset := token.NewFileSet()
+	printed, err := parser.ParseFile(pfset, "src.go", src, parser.ParseComments)
+	if err != nil {
+		return src
+	}
+	orig, nodes := nodesInOrder(file), nodesInOrder(printed)
+	if len(orig) != len(nodes) {
+		return src
+	}
+	var buf bytes.Buffer
+	// The position in src where the last directive is inserted and the position in the lgo source mapped to it.
+	var dirPos, dirOrig token.Position
+	var last, skipUntil int
+	// The position of the func keyword of a function with a //export comment.
+	exportFunc := -1
+	for i, n := range nodes {
+		if reflect.TypeOf(n) != reflect.TypeOf(orig[i]) {
+			return src
+		}
+		if pfset.Position(n.Pos()).Offset < skipUntil {
+			continue
+		}
+		if gen, ok := n.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			// Do not insert directives into imports. A directive before import "C" would be a part of the cgo preamble.
+			skipUntil = pfset.Position(n.End()).Offset
+			continue
+		}
+		switch n.(type) {
+		case *ast.CommentGroup, *ast.Comment:
+			continue
+		}
+		if fdecl, ok := n.(*ast.FuncDecl); ok && cgoExportName(fdecl) != "" {
+			// cgo reads //export comments from the doc comments of functions.
+			// A directive before func would be a part of the comment group and the function would lose the doc.
+			exportFunc = pfset.Position(n.Pos()).Offset
+			continue
+		}
+		if pfset.Position(n.Pos()).Offset == exportFunc {
+			continue
+		}
+		if orig[i] == file || orig[i] == file.Name || !orig[i].Pos().IsValid() || !n.Pos().IsValid() {
+			continue
+		}
+		target := fset.Position(orig[i].Pos())
+		pos := pfset.Position(n.Pos())
+		if dirPos.IsValid() && pos.Offset <= dirPos.Offset {
+			// Keep the directive of the outer node.
+			continue
+		}
+		// The position which the compiler computes from the last directive.
+		implied := token.Position{Line: dirOrig.Line + pos.Line - dirPos.Line, Column: pos.Column}
+		if pos.Line == dirPos.Line {
+			implied.Column = dirOrig.Column + pos.Column - dirPos.Column
+		}
+		if dirPos.IsValid() && implied.Line == target.Line && implied.Column == target.Column {
+			continue
+		}
+		buf.WriteString(src[last:pos.Offset])
+		fmt.Fprintf(&buf, "/*line :%d:%d*/", target.Line, target.Column)
+		last = pos.Offset
+		dirPos, dirOrig = pos, target
+	}
+	buf.WriteString(src[last:])
+	return buf.String()
+}
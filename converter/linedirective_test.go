@@ -0,0 +1,40 @@
+package converter
+
+import (
+	"testing"
+)
+
+func TestAddLineDirectives(t *testing.T) {
+	file, fset, _, checker := checkTestFile(t, "lgo/exec1", `package p
+
+func f(x int) int {
+	y := x   *  2
+	return y
+}
+
+
+
+var z = f(3)
+`, nil)
+	injectRedefinitionHooks(file, checker)
+	src, err := printFinalResult(file, fset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := addLineDirectives(src, file, fset)
+	want := `package p
+
+var LgoRedef_f func(x int) int
+/*line :3:1*/func f(x int) int {
+	if LgoRedef_f != nil {
+		return LgoRedef_f(x)
+	}
+	/*line :4:2*/y := x * /*line :4:14*/2
+	return y
+}
+/*line :10:1*/var z = f(3)
+`
+	if got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,290 @@
+package converter
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path"
+	"reflect"
+	"regexp"
+)
+
+// redefHookPrefix is the prefix of package-level variables that hold the latest definitions of functions.
+//
+// When Config.RedefinableFuncs is true, a top-level function
+//
+// func f(x int) int { ... }
+//
+// is converted to
+//
+// var LgoRedef_f func(x int) int
+//
+// func f(x int) int {
+//   if LgoRedef_f != nil {
+//     return LgoRedef_f(x)
+//   }
+//   ...
+// }
+//
+// When a later cell redefines f with the identical signature, the later cell sets the hook of the old f
+// in its init function. Thus, callers of f compiled in older cells (and old func values of f) call the new f.
+const redefHookPrefix = "LgoRedef_"
+
+// isRedefinableFunc returns true if decl is a top-level function which can be redefined in later cells.
+func isRedefinableFunc(decl *ast.FuncDecl, checker *types.Checker) bool {
+	if decl.Recv != nil || decl.Body == nil {
+		return false
+	}
+	obj, ok := checker.Defs[decl.Name].(*types.Func)
+	if !ok {
+		return false
+	}
+	switch obj.Name() {
+	case "_", "init", LgoInitFuncName:
+		return false
+	}
+	return true
+}
+
+// redefinitionInit returns the init function to set the hooks of old functions redefined in file.
+// It returns nil if file does not redefine functions.
+// This must be called after top-level declarations are renamed so that the new definitions are referred with their final names.
+func redefinitionInit(file *ast.File, checker *types.Checker, conf *Config, immg *importManager) *ast.FuncDecl {
+	olds := make(map[string]*types.Func)
+	for _, old := range conf.Olds {
+		if f, ok := old.(*types.Func); ok && f.Pkg() != nil && f.Pkg().IsLgo {
+			olds[f.Name()] = f
+		}
+	}
+	var body []ast.Stmt
+	for _, decl := range file.Decls {
+		fdecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !isRedefinableFunc(fdecl, checker) {
+			continue
+		}
+		obj := checker.Defs[fdecl.Name]
+		old := olds[obj.Name()]
+		if old == nil || !types.Identical(old.Type(), obj.Type()) {
+			// The old function is not updated if the signature is changed because old callers can not call the new function.
+			continue
+		}
+		body = append(body, &ast.AssignStmt{
+			Lhs: []ast.Expr{&ast.SelectorExpr{
+				X:   &ast.Ident{Name: immg.shortName(old.Pkg())},
+				Sel: &ast.Ident{Name: redefHookPrefix + old.Name()},
+			}},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{&ast.Ident{Name: fdecl.Name.Name}},
+		})
+	}
+	if body == nil {
+		return nil
+	}
+	return &ast.FuncDecl{
+		Name: &ast.Ident{Name: "init"},
+		Type: &ast.FuncType{Params: &ast.FieldList{}},
+		Body: &ast.BlockStmt{List: body},
+	}
+}
+
+// injectRedefinitionHooks declares the hook of each top-level function in file and
+// injects code to forward calls to the hook if it is set.
+// This must be called after all identifiers in file are renamed because the types of hooks are copied from functions.
+func injectRedefinitionHooks(file *ast.File, checker *types.Checker) {
+	picker := newNamePicker(checker.Defs)
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		fdecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !isRedefinableFunc(fdecl, checker) {
+			decls = append(decls, decl)
+			continue
+		}
+		hook := redefHookPrefix + checker.Defs[fdecl.Name].Name()
+		args := nameParams(fdecl.Type.Params, picker)
+		call := &ast.CallExpr{
+			Fun:  &ast.Ident{Name: hook},
+			Args: args,
+		}
+		if params := fdecl.Type.Params.List; len(params) > 0 {
+			if _, ok := params[len(params)-1].Type.(*ast.Ellipsis); ok {
+				call.Ellipsis = token.Pos(1)
+			}
+		}
+		var forward []ast.Stmt
+		if fdecl.Type.Results == nil || len(fdecl.Type.Results.List) == 0 {
+			forward = []ast.Stmt{&ast.ExprStmt{X: call}, &ast.ReturnStmt{}}
+		} else {
+			forward = []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{call}}}
+		}
+		fdecl.Body.List = append([]ast.Stmt{&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  &ast.Ident{Name: hook},
+				Op: token.NEQ,
+				Y:  &ast.Ident{Name: "nil"},
+			},
+			Body: &ast.BlockStmt{List: forward},
+		}}, fdecl.Body.List...)
+		decls = append(decls, &ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{
+				Names: []*ast.Ident{{Name: hook}},
+				Type:  cloneNode(fdecl.Type).(*ast.FuncType),
+			}},
+		}, fdecl)
+	}
+	file.Decls = decls
+}
+
+// nameParams names unnamed and blank parameters in params and returns the identifiers of parameters.
+func nameParams(params *ast.FieldList, picker *namePicker) []ast.Expr {
+	var args []ast.Expr
+	for _, field := range params.List {
+		if len(field.Names) == 0 {
+			field.Names = []*ast.Ident{{Name: "_"}}
+		}
+		for i, name := range field.Names {
+			if name.Name == "_" {
+				field.Names[i] = &ast.Ident{Name: picker.NewName("arg")}
+			}
+			args = append(args, &ast.Ident{Name: field.Names[i].Name})
+		}
+	}
+	return args
+}
+
+var (
+	posType       = reflect.TypeOf(token.NoPos)
+	objectPtrType = reflect.TypeOf((*ast.Object)(nil))
+	commentsType  = reflect.TypeOf((*ast.CommentGroup)(nil))
+)
+
+// cloneNode returns a deep copy of node without positions, comments and objects.
+func cloneNode(node ast.Node) ast.Node {
+	return cloneValue(reflect.ValueOf(node)).Interface().(ast.Node)
+}
+
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == objectPtrType || v.Type() == commentsType {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(cloneValue(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(cloneValue(v.Elem()))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := c.Field(i); f.CanSet() && f.Type() != posType {
+				f.Set(cloneValue(v.Field(i)))
+			}
+		}
+		return c
+	}
+	return v
+}
+
+// annotateStaleTypeErrors adds notes to type errors that refer to types redefined in later cells.
+// Values created before a type is redefined keep the old type, which is incompatible with the new type with the same name.
+func annotateStaleTypeErrors(errs []error, scope *types.Scope, olds []types.Object) {
+	stale := staleTypes(scope, olds)
+	if len(stale) == 0 {
+		return
+	}
+	for i, err := range errs {
+		terr, ok := err.(types.Error)
+		if !ok {
+			continue
+		}
+		noted := make(map[string]bool)
+		for _, qname := range findQualifiedNames(terr.Msg) {
+			name, ok := stale[qname]
+			if !ok || noted[qname] {
+				continue
+			}
+			noted[qname] = true
+			terr.Msg += fmt.Sprintf(" (note: %s is redefined and %s is the stale type of values created before the redefinition)", name, qname)
+		}
+		errs[i] = terr
+	}
+}
+
+var qualifiedNameRegexp = regexp.MustCompile(`[\pL_][\pL\pN_]*\.[\pL_][\pL\pN_]*`)
+
+func findQualifiedNames(msg string) []string {
+	return qualifiedNameRegexp.FindAllString(msg, -1)
+}
+
+// staleTypes returns a map from qualified names of stale types in error messages to their names.
+// A type is stale if a type with the same name is defined in a later cell (or in scope).
+func staleTypes(scope *types.Scope, olds []types.Object) map[string]string {
+	latest := make(map[string]types.Object)
+	for _, old := range olds {
+		if tn, ok := old.(*types.TypeName); ok {
+			latest[tn.Name()] = tn
+		}
+	}
+	if scope != nil {
+		for _, name := range scope.Names() {
+			if tn, ok := scope.Lookup(name).(*types.TypeName); ok {
+				latest[name] = tn
+			}
+		}
+	}
+	stale := make(map[string]string)
+	visited := make(map[types.Type]bool)
+	var visit func(t types.Type)
+	visit = func(t types.Type) {
+		if t == nil || visited[t] {
+			return
+		}
+		visited[t] = true
+		switch t := t.(type) {
+		case *types.Named:
+			obj := t.Obj()
+			if obj.Pkg() != nil && obj.Pkg().IsLgo {
+				if l := latest[obj.Name()]; l != nil && l != obj {
+					stale[path.Base(obj.Pkg().Path())+"."+obj.Name()] = obj.Name()
+				}
+			}
+		case *types.Pointer:
+			visit(t.Elem())
+		case *types.Slice:
+			visit(t.Elem())
+		case *types.Array:
+			visit(t.Elem())
+		case *types.Chan:
+			visit(t.Elem())
+		case *types.Map:
+			visit(t.Key())
+			visit(t.Elem())
+		case *types.Signature:
+			for _, tup := range []*types.Tuple{t.Params(), t.Results()} {
+				for i := 0; i < tup.Len(); i++ {
+					visit(tup.At(i).Type())
+				}
+			}
+		}
+	}
+	for _, old := range olds {
+		visit(old.Type())
+	}
+	return stale
+}
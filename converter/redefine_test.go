@@ -0,0 +1,138 @@
+package converter
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func checkTestFile(t *testing.T, path, src string, olds *types.Package) (*ast.File, *token.FileSet, *types.Package, *types.Checker) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "src.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Defs:   make(map[*ast.Ident]types.Object),
+		Uses:   make(map[*ast.Ident]types.Object),
+		Scopes: make(map[ast.Node]*types.Scope),
+	}
+	conf := &types.Config{Importer: importer.Default(), FakeImportC: true}
+	if olds != nil {
+		conf.Importer = &importerWithOlds{map[string]*types.Package{olds.Path(): olds}}
+	}
+	pkg := types.NewPackage(path, file.Name.Name)
+	pkg.IsLgo = true
+	checker := types.NewChecker(conf, fset, pkg, info)
+	if err := checker.Files([]*ast.File{file}); err != nil {
+		t.Fatal(err)
+	}
+	return file, fset, pkg, checker
+}
+
+func TestInjectRedefinitionHooks(t *testing.T) {
+	file, fset, _, checker := checkTestFile(t, "lgo/exec1", `package p
+
+func f(_ int, s ...string) (int, error) {
+	return len(s), nil
+}
+
+func g(int, string) {}
+
+func init() {}
+
+type T struct{}
+
+func (T) m() {}
+`, nil)
+	injectRedefinitionHooks(file, checker)
+	got, err := printFinalResult(file, fset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package p
+
+var LgoRedef_f func(arg int, s ...string) (int, error)
+func f(arg int, s ...string) (int, error) {
+	if LgoRedef_f != nil {
+		return LgoRedef_f(arg, s...)
+	}
+
+	return len(s), nil
+}
+var LgoRedef_g func(arg0 int, arg1 string)
+func g(arg0 int, arg1 string) {
+	if LgoRedef_g != nil {
+		LgoRedef_g(arg0, arg1)
+		return
+	}
+}
+func init() {}
+type T struct{}
+func (T) m() {}
+`
+	if got != want {
+		t.Errorf("Got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRedefinitionInit(t *testing.T) {
+	_, _, old, _ := checkTestFile(t, "lgo/exec1", `package exec1
+func f(x int) int { return x }
+func g(x int) int { return x }
+`, nil)
+	file, _, pkg, checker := checkTestFile(t, "lgo/exec2", `package exec2
+import "lgo/exec1"
+var _ = exec1.f
+func f(y int) int { return y * 2 }
+func g(x string) int { return len(x) }
+func h() {}
+`, old)
+	conf := &Config{Olds: []types.Object{old.Scope().Lookup("f"), old.Scope().Lookup("g")}}
+	init := redefinitionInit(file, checker, conf, newImportManager(pkg, file, checker))
+	if init == nil {
+		t.Fatal("init function is not generated")
+	}
+	// g is not updated because the signature is changed.
+	if len(init.Body.List) != 1 {
+		t.Fatalf("Unexpected init body: %#v", init.Body.List)
+	}
+	assign := init.Body.List[0].(*ast.AssignStmt)
+	lhs := assign.Lhs[0].(*ast.SelectorExpr)
+	if x, sel, rhs := lhs.X.(*ast.Ident).Name, lhs.Sel.Name, assign.Rhs[0].(*ast.Ident).Name; x != "exec1" || sel != "LgoRedef_f" || rhs != "f" {
+		t.Errorf("Got %s.%s = %s; want exec1.LgoRedef_f = f", x, sel, rhs)
+	}
+}
+
+func TestAnnotateStaleTypeErrors(t *testing.T) {
+	_, _, old, _ := checkTestFile(t, "lgo/exec1", `package exec1
+type T struct{}
+var v T
+`, nil)
+	pkg := types.NewPackage("lgo/exec2", "p")
+	pkg.Scope().Insert(types.NewTypeName(token.NoPos, pkg, "T", types.NewStruct(nil, nil)))
+
+	olds := []types.Object{old.Scope().Lookup("T"), old.Scope().Lookup("v")}
+	errs := []error{
+		types.Error{Msg: "cannot use v (variable of type exec1.T) as T value in assignment"},
+		types.Error{Msg: "undeclared name: x"},
+	}
+	annotateStaleTypeErrors(errs, pkg.Scope(), olds)
+	if msg := errs[0].(types.Error).Msg; !strings.Contains(msg, "(note: T is redefined and exec1.T is the stale type") {
+		t.Errorf("The note is not added: %s", msg)
+	}
+	if msg := errs[1].(types.Error).Msg; msg != "undeclared name: x" {
+		t.Errorf("Unexpected note: %s", msg)
+	}
+
+	// exec1.T is not stale without redefinition.
+	errs = []error{types.Error{Msg: "cannot use v (variable of type exec1.T) as int value in assignment"}}
+	annotateStaleTypeErrors(errs, types.NewPackage("lgo/exec2", "p").Scope(), olds)
+	if msg := errs[0].(types.Error).Msg; strings.Contains(msg, "note") {
+		t.Errorf("Unexpected note: %s", msg)
+	}
+}
@@ -1,13 +1,18 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -31,11 +36,90 @@ type LgoContext struct {
 	context.Context
 	// Display displays non-text content in Jupyter Notebook.
 	Display DataDisplayer
+	// Input reads inputs from users. Input is nil if the frontend does not accept inputs.
+	Input InputReader
+	// Comm opens comms to the frontend. Comm is nil if the kernel does not support comms.
+	Comm CommManager
 }
 
 func lgoCtxWithCancel(ctx LgoContext) (LgoContext, context.CancelFunc) {
 	goctx, cancel := context.WithCancel(ctx.Context)
-	return LgoContext{goctx, ctx.Display}, cancel
+	return LgoContext{goctx, ctx.Display, ctx.Input, ctx.Comm}, cancel
+}
+
+// CommManager is the interface to open comms of Jupyter Notebook[1].
+// Comms are used to communicate with JavaScript in the frontend (e.g. ipywidgets).
+//
+// References:
+// [1] http://jupyter-client.readthedocs.io/en/latest/messaging.html#custom-messages
+type CommManager interface {
+	// Open opens a new comm with targetName in the frontend.
+	// data and metadata are sent to the frontend with comm_open. metadata can be nil.
+	Open(targetName string, data interface{}, metadata map[string]interface{}) (Comm, error)
+	// RegisterTarget registers a function which is called when the frontend opens a comm with targetName.
+	RegisterTarget(targetName string, onOpen func(c Comm, data map[string]interface{}))
+}
+
+// Comm is a channel of custom messages between lgo code and the frontend.
+// Callbacks set by OnMsg and OnClose are called in the kernel sequentially with code executions.
+type Comm interface {
+	ID() string
+	TargetName() string
+	// Send sends data to the frontend. data must be encodable to a JSON object.
+	Send(data interface{}) error
+	// Close closes the comm. data is sent to the frontend with comm_close.
+	Close(data interface{}) error
+	OnMsg(f func(data map[string]interface{}))
+	OnClose(f func(data map[string]interface{}))
+}
+
+// InputReader is the interface that wraps Jupyter Notebook input_request protocol[1].
+// In Jupyter Notebook, reads from os.Stdin are also forwarded to InputReader.
+//
+// References:
+// [1] http://jupyter-client.readthedocs.io/en/latest/messaging.html#messages-on-the-stdin-router-dealer-sockets
+type InputReader interface {
+	// ReadInput shows prompt and returns a line entered by users without a newline.
+	// If password is true, the input is not echoed.
+	// ReadInput returns ctx.Err() without waiting for the input once ctx is done.
+	ReadInput(ctx context.Context, prompt string, password bool) (string, error)
+}
+
+// ReadLine shows prompt and returns a line entered by users without a newline.
+// It reads the line with Input of the current execution. If Input is not available (e.g. lgo run),
+// ReadLine prints prompt to os.Stdout and reads a line from os.Stdin.
+func ReadLine(prompt string) (string, error) {
+	return readInput(prompt, false)
+}
+
+// ReadPassword is like ReadLine but the entered text is not echoed in Jupyter Notebook.
+func ReadPassword(prompt string) (string, error) {
+	return readInput(prompt, true)
+}
+
+func readInput(prompt string, password bool) (string, error) {
+	if ctx := GetExecContext(); ctx.Input != nil {
+		return ctx.Input.ReadInput(ctx, prompt, password)
+	}
+	fmt.Fprint(os.Stdout, prompt)
+	// Read a byte at a time not to consume bytes after the line.
+	var line []byte
+	var b [1]byte
+	for {
+		n, err := os.Stdin.Read(b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
 }
 
 // DataDisplayer is the interface that wraps Jupyter Notebook display_data protocol.
@@ -64,11 +148,38 @@ type DataDisplayer interface {
 	Raw(contentType string, v interface{}, id *string) error
 }
 
+// stackFormatter formats stack traces printed when lgo code panics.
+var stackFormatter = func(stack []byte) []byte { return stack }
+
+// SetStackFormatter sets the function to format stack traces printed when lgo code panics
+// (e.g. to hide frames of lgo itself).
+func SetStackFormatter(f func(stack []byte) []byte) {
+	stackFormatter = f
+}
+
+// PanicError is the error returned by ExecLgoEntryPoint when the main routine panics.
+// The panic is not printed to stderr. Callers show Value and Stack to users instead.
+type PanicError struct {
+	// Value is the value passed to panic formatted with fmt.Sprint.
+	Value string
+	// Stack is the stack trace of the panic formatted by the function set with SetStackFormatter.
+	Stack string
+	// Message summarizes the results of the routines (e.g. "main routine failed, 1 goroutine canceled").
+	Message string
+}
+
+func (e *PanicError) Error() string {
+	return e.Message
+}
+
 type resultCounter struct {
 	active uint
 	fail   uint
 	cancel uint
 	mu     sync.Mutex
+	// panic is the first panic in the routines. It is recorded only if recordPanic is true.
+	recordPanic bool
+	panic       *PanicError
 }
 
 func (c *resultCounter) add() {
@@ -78,6 +189,7 @@ func (c *resultCounter) add() {
 }
 
 // recordResult records a result of a routine based on the value of recover().
+// A panic is printed to stderr unless c.recordPanic is true.
 func (c *resultCounter) recordResult(r interface{}) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -92,8 +204,19 @@ func (c *resultCounter) recordResult(r interface{}) {
 		c.cancel++
 		return
 	}
-	fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", r, debug.Stack())
 	c.fail++
+	// A panic with *PanicError (e.g. a panic forwarded from a worker process) is recorded as it is.
+	p, ok := r.(*PanicError)
+	if !ok {
+		p = &PanicError{Value: fmt.Sprint(r), Stack: string(stackFormatter(debug.Stack()))}
+	}
+	if c.recordPanic {
+		if c.panic == nil {
+			c.panic = p
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "panic: %s\n\n%s", p.Value, p.Stack)
 }
 
 func (c *resultCounter) recordResultInDefer() {
@@ -118,6 +241,7 @@ func newExecutionState(parent LgoContext) *ExecutionState {
 		Context:   ctx,
 		cancelCtx: cancel,
 	}
+	e.mainCounter.recordPanic = true
 	go func() {
 		<-parent.Done()
 		e.cancel()
@@ -261,10 +385,18 @@ func startExec(parent LgoContext, main func()) *ExecutionState {
 func finalizeExec(e *ExecutionState) error {
 	e.waitRoutines()
 	resetExecState(e)
-	if msg := e.counterMessage(); msg != "" {
-		return errors.New(msg)
+	msg := e.counterMessage()
+	if msg == "" {
+		return nil
+	}
+	e.mainCounter.mu.Lock()
+	p := e.mainCounter.panic
+	e.mainCounter.mu.Unlock()
+	if p != nil {
+		p.Message = msg
+		return p
 	}
-	return nil
+	return errors.New(msg)
 }
 
 // InitGoroutine is called internally before lgo starts a new goroutine
@@ -344,8 +476,7 @@ var AllVars = make(map[string][]interface{})
 func ZeroClearAllVars() {
 	for _, vars := range AllVars {
 		for _, p := range vars {
-			v := reflect.ValueOf(p)
-			v.Elem().Set(reflect.New(v.Type().Elem()).Elem())
+			zeroClear(p)
 		}
 	}
 	// Return memory to OS.
@@ -353,6 +484,26 @@ func ZeroClearAllVars() {
 	runtime.GC()
 }
 
+// zeroClear sets the zero-value to the variable p points to.
+func zeroClear(p interface{}) {
+	v := reflect.ValueOf(p)
+	v.Elem().Set(reflect.New(v.Type().Elem()).Elem())
+}
+
+// DeleteVar clears variables named name (including shadowed ones) with zero-values and removes them from AllVars.
+// Memory held by the variables is released by the next GC. DeleteVar returns false if AllVars does not have name.
+func DeleteVar(name string) bool {
+	vars, ok := AllVars[name]
+	if !ok {
+		return false
+	}
+	for _, p := range vars {
+		zeroClear(p)
+	}
+	delete(AllVars, name)
+	return true
+}
+
 // LgoRegisterVar is used to register a variable to AllVars internally.
 func LgoRegisterVar(name string, p interface{}) {
 	v := reflect.ValueOf(p)
@@ -361,3 +512,66 @@ func LgoRegisterVar(name string, p interface{}) {
 	}
 	AllVars[name] = append(AllVars[name], p)
 }
+
+// LgoSaveVars saves the current values of variables in AllVars to a file at path with encoding/gob.
+// Variables which can not be encoded with gob (e.g. funcs and channels) are skipped with warnings.
+// LgoSaveVars is used to save lgo sessions internally.
+func LgoSaveVars(path string) {
+	vars := make(map[string][]byte)
+	var skipped []string
+	for name, ptrs := range AllVars {
+		// The last one is the variable which is visible now.
+		p := ptrs[len(ptrs)-1]
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).EncodeValue(reflect.ValueOf(p).Elem()); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", name, err))
+			continue
+		}
+		vars[name] = buf.Bytes()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(vars); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode variables: %v\n", err)
+		return
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save variables: %v\n", err)
+		return
+	}
+	sort.Strings(skipped)
+	for _, s := range skipped {
+		fmt.Fprintf(os.Stderr, "Skipped variable: %s\n", s)
+	}
+}
+
+// LgoRestoreVars registers variables to AllVars and restores their values from a file saved by LgoSaveVars.
+// vars is keyed by variable names and its values are pointers to variables.
+// LgoRestoreVars is used to restore lgo sessions internally.
+func LgoRestoreVars(path string, vars map[string]interface{}) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read variables: %v\n", err)
+		return
+	}
+	var saved map[string][]byte
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&saved); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to decode variables: %v\n", err)
+		return
+	}
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := vars[name]
+		LgoRegisterVar(name, p)
+		data, ok := saved[name]
+		if !ok {
+			continue
+		}
+		if err := gob.NewDecoder(bytes.NewReader(data)).DecodeValue(reflect.ValueOf(p)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to restore %s: %v\n", name, err)
+		}
+	}
+}
@@ -2,6 +2,12 @@ package core
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -151,6 +157,30 @@ func TestMainCounters(t *testing.T) {
 	}
 }
 
+func TestExecLgoEntryPoint_panic(t *testing.T) {
+	err := ExecLgoEntryPoint(LgoContext{Context: context.Background()}, func() {
+		state := InitGoroutine()
+		go func() {
+			defer FinalizeGoroutine(state)
+			panic(Bailout)
+		}()
+		panic(fmt.Errorf("error %d", 10))
+	})
+	p, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("Expected *PanicError but got %#v", err)
+	}
+	if p.Value != "error 10" {
+		t.Errorf("Got %q; want %q", p.Value, "error 10")
+	}
+	if want := "main routine failed, 1 goroutine canceled"; p.Error() != want {
+		t.Errorf("Got %q; want %q", p.Error(), want)
+	}
+	if !strings.Contains(p.Stack, "TestExecLgoEntryPoint_panic") {
+		t.Errorf("The stack does not contain the test function: %s", p.Stack)
+	}
+}
+
 func TestFinalizeExecTimeout(t *testing.T) {
 	execWaitDuration = 10 * time.Millisecond
 
@@ -172,3 +202,73 @@ func TestFinalizeExecTimeout(t *testing.T) {
 		t.Errorf("Unexpected err: %v", err)
 	}
 }
+
+func TestSaveAndRestoreVars(t *testing.T) {
+	orig := AllVars
+	defer func() { AllVars = orig }()
+	AllVars = make(map[string][]interface{})
+
+	type point struct{ X, Y int }
+	n, s, p, f := 10, "hello", point{1, 2}, func() {}
+	LgoRegisterVar("n", &n)
+	LgoRegisterVar("s", &s)
+	LgoRegisterVar("p", &p)
+	LgoRegisterVar("f", &f)
+	// n is shadowed by another n.
+	n2 := 20
+	LgoRegisterVar("n", &n2)
+
+	dir, err := ioutil.TempDir("", "lgo_core_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vars.gob")
+	LgoSaveVars(path)
+
+	AllVars = make(map[string][]interface{})
+	var rn int
+	var rs string
+	var rp point
+	var rf func()
+	var missing int
+	LgoRestoreVars(path, map[string]interface{}{
+		"n": &rn, "s": &rs, "p": &rp, "f": &rf, "missing": &missing,
+	})
+	if rn != 20 || rs != "hello" || rp != (point{1, 2}) || rf != nil || missing != 0 {
+		t.Errorf("Unexpected values: %v, %q, %v, %v, %v", rn, rs, rp, rf != nil, missing)
+	}
+	if len(AllVars) != 5 {
+		t.Errorf("Restored variables are not registered: %v", AllVars)
+	}
+}
+
+type recordInputReader struct {
+	prompts []string
+}
+
+func (r *recordInputReader) ReadInput(ctx context.Context, prompt string, password bool) (string, error) {
+	r.prompts = append(r.prompts, fmt.Sprintf("%s:%v", prompt, password))
+	return "value", nil
+}
+
+func TestReadLine(t *testing.T) {
+	in := &recordInputReader{}
+	var line, password string
+	var lineErr, passwordErr error
+	if err := ExecLgoEntryPoint(LgoContext{Context: context.Background(), Input: in}, func() {
+		line, lineErr = ReadLine("Name: ")
+		password, passwordErr = ReadPassword("Password: ")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if lineErr != nil || passwordErr != nil {
+		t.Fatalf("Unexpected errors: %v, %v", lineErr, passwordErr)
+	}
+	if line != "value" || password != "value" {
+		t.Errorf("Got %q and %q; want value", line, password)
+	}
+	if want := []string{"Name: :false", "Password: :true"}; !reflect.DeepEqual(in.prompts, want) {
+		t.Errorf("Got %q; want %q", in.prompts, want)
+	}
+}
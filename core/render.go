@@ -0,0 +1,185 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A Renderer renders v to data of a content type (e.g. text/html).
+// Renderer returns false if it does not support v.
+type Renderer func(v interface{}) (data interface{}, ok bool)
+
+// MimeBundler is the interface implemented by values that render themselves to a mime bundle,
+// a map from content types (e.g. "text/html") to data.
+// If a mime bundle does not have "text/plain", the text representation is rendered by registered renderers.
+type MimeBundler interface {
+	LgoMimeBundle() map[string]interface{}
+}
+
+// HTMLValue is the interface implemented by values that render themselves as HTML.
+type HTMLValue interface {
+	LgoHTML() string
+}
+
+// MarkdownValue is the interface implemented by values that render themselves as Markdown.
+type MarkdownValue interface {
+	LgoMarkdown() string
+}
+
+// SVGValue is the interface implemented by values that render themselves as SVG images.
+type SVGValue interface {
+	LgoSVG() string
+}
+
+// PNGValue is the interface implemented by values that render themselves as PNG images.
+type PNGValue interface {
+	LgoPNG() []byte
+}
+
+// JPEGValue is the interface implemented by values that render themselves as JPEG images.
+type JPEGValue interface {
+	LgoJPEG() []byte
+}
+
+// BundleDisplayer is implemented by DataDisplayers that display a mime bundle at once.
+// The frontend chooses the richest representation in the bundle.
+type BundleDisplayer interface {
+	DisplayBundle(bundle map[string]interface{}) error
+}
+
+// ResultDisplayer is implemented by DataDisplayers that display the value of the last expression
+// as the result of the execution (e.g. execute_result in Jupyter Notebook).
+type ResultDisplayer interface {
+	DisplayResult(bundle map[string]interface{})
+}
+
+type rendererEntry struct {
+	contentType string
+	render      Renderer
+}
+
+var (
+	renderersMu sync.Mutex
+	renderers   []rendererEntry
+)
+
+func init() {
+	RegisterRenderer("text/plain", func(v interface{}) (interface{}, bool) {
+		return fmt.Sprint(v), true
+	})
+	RegisterRenderer("text/plain", func(v interface{}) (interface{}, bool) {
+		if raw, ok := v.(json.RawMessage); ok {
+			return string(raw), true
+		}
+		return nil, false
+	})
+	RegisterRenderer("application/json", func(v interface{}) (interface{}, bool) {
+		if raw, ok := v.(json.RawMessage); ok && json.Valid(raw) {
+			return raw, true
+		}
+		return nil, false
+	})
+	RegisterRenderer("text/html", func(v interface{}) (interface{}, bool) {
+		if h, ok := v.(HTMLValue); ok {
+			return h.LgoHTML(), true
+		}
+		return nil, false
+	})
+	RegisterRenderer("text/markdown", func(v interface{}) (interface{}, bool) {
+		if m, ok := v.(MarkdownValue); ok {
+			return m.LgoMarkdown(), true
+		}
+		return nil, false
+	})
+	RegisterRenderer("image/svg+xml", func(v interface{}) (interface{}, bool) {
+		if i, ok := v.(SVGValue); ok {
+			return i.LgoSVG(), true
+		}
+		return nil, false
+	})
+	RegisterRenderer("image/png", func(v interface{}) (interface{}, bool) {
+		if i, ok := v.(PNGValue); ok {
+			return i.LgoPNG(), true
+		}
+		return nil, false
+	})
+	RegisterRenderer("image/jpeg", func(v interface{}) (interface{}, bool) {
+		if i, ok := v.(JPEGValue); ok {
+			return i.LgoJPEG(), true
+		}
+		return nil, false
+	})
+}
+
+// RegisterRenderer registers a Renderer of contentType.
+// If multiple renderers of the same content type support a value, the last registered one is used.
+func RegisterRenderer(contentType string, r Renderer) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	renderers = append(renderers, rendererEntry{contentType, r})
+}
+
+// RenderMimeBundle renders v to a mime bundle with MimeBundler and registered renderers.
+// The returned mime bundle always has "text/plain".
+func RenderMimeBundle(v interface{}) map[string]interface{} {
+	bundle := make(map[string]interface{})
+	if b, ok := v.(MimeBundler); ok {
+		for typ, data := range b.LgoMimeBundle() {
+			bundle[typ] = data
+		}
+	}
+	renderersMu.Lock()
+	entries := make([]rendererEntry, len(renderers))
+	copy(entries, renderers)
+	renderersMu.Unlock()
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if _, ok := bundle[e.contentType]; ok {
+			continue
+		}
+		if data, ok := e.render(v); ok {
+			bundle[e.contentType] = data
+		}
+	}
+	return bundle
+}
+
+// displayPreference is the order of content types used when a DataDisplayer can not display mime bundles.
+var displayPreference = []string{
+	"text/html",
+	"image/svg+xml",
+	"image/png",
+	"image/jpeg",
+	"text/markdown",
+	"text/latex",
+	"application/json",
+	"text/plain",
+}
+
+// DisplayBundle displays a mime bundle with d.
+// If d is not a BundleDisplayer, the richest representation in bundle is displayed with d.Raw.
+func DisplayBundle(d DataDisplayer, bundle map[string]interface{}) error {
+	if d == nil {
+		return errors.New("display is not available")
+	}
+	if bd, ok := d.(BundleDisplayer); ok {
+		return bd.DisplayBundle(bundle)
+	}
+	for _, typ := range displayPreference {
+		if data, ok := bundle[typ]; ok {
+			return d.Raw(typ, data, nil)
+		}
+	}
+	for typ, data := range bundle {
+		return d.Raw(typ, data, nil)
+	}
+	return nil
+}
+
+// Display renders v with RenderMimeBundle and displays it in the output of the current execution.
+// Values implementing LgoHTML, LgoPNG, LgoMimeBundle, etc. are shown in rich representations.
+func Display(v interface{}) error {
+	return DisplayBundle(GetExecContext().Display, RenderMimeBundle(v))
+}
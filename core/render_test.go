@@ -0,0 +1,110 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type htmlValue string
+
+func (v htmlValue) LgoMimeBundle() map[string]interface{} {
+	return map[string]interface{}{"text/html": "<b>" + string(v) + "</b>"}
+}
+
+type celsius float64
+
+type image struct{}
+
+func (image) LgoPNG() []byte      { return []byte{1, 2, 3} }
+func (image) LgoHTML() string     { return "<img>" }
+func (image) LgoMarkdown() string { return "![image]" }
+
+// rawDisplayer is a DataDisplayer which does not implement BundleDisplayer.
+type rawDisplayer struct {
+	DataDisplayer
+	types []string
+}
+
+func (d *rawDisplayer) Raw(contentType string, v interface{}, id *string) error {
+	d.types = append(d.types, contentType)
+	return nil
+}
+
+type bundleDisplayer struct {
+	rawDisplayer
+	bundles []map[string]interface{}
+}
+
+func (d *bundleDisplayer) DisplayBundle(bundle map[string]interface{}) error {
+	d.bundles = append(d.bundles, bundle)
+	return nil
+}
+
+func TestRenderMimeBundle(t *testing.T) {
+	RegisterRenderer("text/plain", func(v interface{}) (interface{}, bool) {
+		if c, ok := v.(celsius); ok {
+			return fmt.Sprintf("%.1f℃", float64(c)), true
+		}
+		return nil, false
+	})
+	tests := []struct {
+		v    interface{}
+		want map[string]interface{}
+	}{
+		{10, map[string]interface{}{"text/plain": "10"}},
+		{celsius(36.5), map[string]interface{}{"text/plain": "36.5℃"}},
+		{htmlValue("hello"), map[string]interface{}{
+			"text/plain": "hello",
+			"text/html":  "<b>hello</b>",
+		}},
+		{image{}, map[string]interface{}{
+			"text/plain":    "{}",
+			"text/html":     "<img>",
+			"text/markdown": "![image]",
+			"image/png":     []byte{1, 2, 3},
+		}},
+		{json.RawMessage(`{"a": 1}`), map[string]interface{}{
+			"text/plain":       `{"a": 1}`,
+			"application/json": json.RawMessage(`{"a": 1}`),
+		}},
+	}
+	for _, tc := range tests {
+		got := RenderMimeBundle(tc.v)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("RenderMimeBundle(%#v) = %#v; want %#v", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	bd := &bundleDisplayer{}
+	if err := ExecLgoEntryPoint(LgoContext{Context: context.Background(), Display: bd}, func() {
+		if err := Display(htmlValue("x")); err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{{"text/plain": "x", "text/html": "<b>x</b>"}}
+	if !reflect.DeepEqual(bd.bundles, want) {
+		t.Errorf("Got %v; want %v", bd.bundles, want)
+	}
+
+	// The richest representation is displayed if the displayer does not support mime bundles.
+	rd := &rawDisplayer{}
+	if err := DisplayBundle(rd, RenderMimeBundle(image{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := DisplayBundle(rd, RenderMimeBundle(10)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"text/html", "text/plain"}; !reflect.DeepEqual(rd.types, want) {
+		t.Errorf("Got %v; want %v", rd.types, want)
+	}
+	if err := DisplayBundle(nil, RenderMimeBundle(10)); err == nil {
+		t.Error("DisplayBundle with nil succeeded unexpectedly")
+	}
+}
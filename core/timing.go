@@ -0,0 +1,191 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// TimeResult is the result of LgoTime.
+type TimeResult struct {
+	// Wall is the elapsed time.
+	Wall time.Duration
+	// User and System are the CPU time of the process in the user mode and the kernel mode.
+	// They include the CPU time of other goroutines (e.g. GC).
+	User, System time.Duration
+	// Allocs and Bytes are the number of heap allocations and the total size of allocated memory.
+	Allocs, Bytes uint64
+}
+
+// CPU returns the total CPU time.
+func (r *TimeResult) CPU() time.Duration {
+	return r.User + r.System
+}
+
+func (r *TimeResult) String() string {
+	return fmt.Sprintf("Wall time: %v, CPU time: %v (user: %v, sys: %v), %d allocs, %d B",
+		r.Wall, r.CPU(), r.User, r.System, r.Allocs, r.Bytes)
+}
+
+// LgoHTML renders r as an HTML table.
+func (r *TimeResult) LgoHTML() string {
+	return timingTable([][2]string{
+		{"Wall time", r.Wall.String()},
+		{"CPU time", fmt.Sprintf("%v (user: %v, sys: %v)", r.CPU(), r.User, r.System)},
+		{"Allocs", fmt.Sprintf("%d (%d B)", r.Allocs, r.Bytes)},
+	})
+}
+
+// BenchmarkResult is the result of LgoTimeit. It is similar to testing.BenchmarkResult.
+type BenchmarkResult struct {
+	// N is the number of iterations.
+	N int
+	// T is the total time of iterations.
+	T time.Duration
+	// Allocs and Bytes are the total number of heap allocations and the total size of allocated memory.
+	Allocs, Bytes uint64
+}
+
+// NsPerOp returns the average time of an iteration in nanoseconds.
+func (r *BenchmarkResult) NsPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return r.T.Nanoseconds() / int64(r.N)
+}
+
+// AllocsPerOp returns the average number of heap allocations in an iteration.
+func (r *BenchmarkResult) AllocsPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return int64(r.Allocs) / int64(r.N)
+}
+
+// BytesPerOp returns the average size of allocated memory in an iteration.
+func (r *BenchmarkResult) BytesPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return int64(r.Bytes) / int64(r.N)
+}
+
+func (r *BenchmarkResult) String() string {
+	return fmt.Sprintf("%8d\t%v/op\t%d B/op\t%d allocs/op",
+		r.N, time.Duration(r.NsPerOp()), r.BytesPerOp(), r.AllocsPerOp())
+}
+
+// LgoHTML renders r as an HTML table.
+func (r *BenchmarkResult) LgoHTML() string {
+	return timingTable([][2]string{
+		{"Time", fmt.Sprintf("%v/op", time.Duration(r.NsPerOp()))},
+		{"Memory", fmt.Sprintf("%d B/op", r.BytesPerOp())},
+		{"Allocs", fmt.Sprintf("%d allocs/op", r.AllocsPerOp())},
+		{"Iterations", fmt.Sprintf("%d (total: %v)", r.N, r.T)},
+	})
+}
+
+func timingTable(rows [][2]string) string {
+	var buf bytes.Buffer
+	buf.WriteString("<table>\n")
+	for _, row := range rows {
+		fmt.Fprintf(&buf, "<tr><th>%s</th><td>%s</td></tr>\n", html.EscapeString(row[0]), html.EscapeString(row[1]))
+	}
+	buf.WriteString("</table>\n")
+	return buf.String()
+}
+
+// cpuTime returns the CPU time of the process.
+func cpuTime() (user, system time.Duration) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0
+	}
+	return time.Duration(ru.Utime.Nano()), time.Duration(ru.Stime.Nano())
+}
+
+// LgoTime runs f once and returns the elapsed time, the CPU time and the allocations.
+// LgoTime is used to implement %time internally.
+func LgoTime(f func()) *TimeResult {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	user, sys := cpuTime()
+	start := time.Now()
+	f()
+	wall := time.Since(start)
+	user2, sys2 := cpuTime()
+	runtime.ReadMemStats(&after)
+	return &TimeResult{
+		Wall:   wall,
+		User:   user2 - user,
+		System: sys2 - sys,
+		Allocs: after.Mallocs - before.Mallocs,
+		Bytes:  after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+// benchTime is the time LgoTimeit runs f at least. It is same as the default of go test -benchtime.
+var benchTime = time.Second
+
+// maxBenchN is the max number of iterations of LgoTimeit.
+const maxBenchN = 1e9
+
+// runBenchmark runs f n times and returns the result.
+func runBenchmark(f func(), n int) *BenchmarkResult {
+	// Collect garbage of previous runs so that they do not affect the result.
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		f()
+	}
+	t := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return &BenchmarkResult{
+		N:      n,
+		T:      t,
+		Allocs: after.Mallocs - before.Mallocs,
+		Bytes:  after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+// predictN predicts the number of iterations to run for goal from the last result.
+// This is based on predictN in testing.
+func predictN(goal time.Duration, last *BenchmarkResult) int {
+	prevns := last.T.Nanoseconds()
+	if prevns <= 0 {
+		prevns = 1
+	}
+	n := int64(last.N) * goal.Nanoseconds() / prevns
+	// Run more iterations than we think we'll need (1.2x).
+	n += n / 5
+	// Don't grow too fast in case we had timing errors previously.
+	if max := 100 * int64(last.N); n > max {
+		n = max
+	}
+	// Be sure to run at least one more than last time.
+	if n <= int64(last.N) {
+		n = int64(last.N) + 1
+	}
+	if n > maxBenchN {
+		n = maxBenchN
+	}
+	return int(n)
+}
+
+// LgoTimeit runs f repeatedly like testing.Benchmark and returns the result.
+// The number of iterations is increased until f runs for 1 second in total.
+// LgoTimeit is used to implement %timeit internally.
+func LgoTimeit(f func()) *BenchmarkResult {
+	r := runBenchmark(f, 1)
+	for r.T < benchTime && r.N < maxBenchN {
+		// Stop if the execution is canceled.
+		ExitIfCtxDone()
+		r = runBenchmark(f, predictN(benchTime, r))
+	}
+	return r
+}
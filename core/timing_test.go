@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+var timingSink []byte
+
+func TestLgoTime(t *testing.T) {
+	r := LgoTime(func() {
+		time.Sleep(10 * time.Millisecond)
+		timingSink = make([]byte, 1<<20)
+	})
+	if r.Wall < 10*time.Millisecond {
+		t.Errorf("Wall is too short: %v", r.Wall)
+	}
+	if r.Allocs == 0 || r.Bytes < 1<<20 {
+		t.Errorf("Allocations are not measured: %d allocs, %d B", r.Allocs, r.Bytes)
+	}
+	if html := r.LgoHTML(); !strings.Contains(html, "<th>Wall time</th>") {
+		t.Errorf("Unexpected HTML: %s", html)
+	}
+}
+
+func TestLgoTimeit(t *testing.T) {
+	defer func(d time.Duration) { benchTime = d }(benchTime)
+	benchTime = 50 * time.Millisecond
+	var n int
+	var r *BenchmarkResult
+	// LgoTimeit checks cancellation of the execution.
+	err := ExecLgoEntryPoint(LgoContext{Context: context.Background()}, func() {
+		r = LgoTimeit(func() {
+			n++
+			timingSink = make([]byte, 64)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.T < benchTime {
+		t.Errorf("T must be longer than %v: %v", benchTime, r.T)
+	}
+	if r.N <= 1 || n < r.N {
+		t.Errorf("Unexpected N: %d (called %d times)", r.N, n)
+	}
+	if r.AllocsPerOp() != 1 || r.BytesPerOp() < 64 {
+		t.Errorf("Unexpected allocations: %d allocs/op, %d B/op", r.AllocsPerOp(), r.BytesPerOp())
+	}
+}
+
+func TestPredictN(t *testing.T) {
+	tests := []struct {
+		last *BenchmarkResult
+		want int
+	}{
+		{&BenchmarkResult{N: 1, T: time.Millisecond}, 100},
+		{&BenchmarkResult{N: 100, T: 100 * time.Millisecond}, 1200},
+		{&BenchmarkResult{N: 100, T: 2 * time.Second}, 101},
+		{&BenchmarkResult{N: 1, T: 0}, 100},
+		{&BenchmarkResult{N: 5e8, T: time.Millisecond}, 1e9},
+	}
+	for _, tc := range tests {
+		if got := predictN(time.Second, tc.last); got != tc.want {
+			t.Errorf("predictN(%+v) = %d; want %d", *tc.last, got, tc.want)
+		}
+	}
+}
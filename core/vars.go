@@ -0,0 +1,258 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// VarInfo is the summary of a variable in AllVars.
+type VarInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Size is the approximate size of memory held by the variable in bytes.
+	// It includes memory referenced from the variable (e.g. elements of slices).
+	Size int64 `json:"size"`
+	// Value is the preview of the value. Long values are truncated.
+	Value string `json:"value"`
+}
+
+// VarInfos is the list of VarInfo returned by LgoVarInfos.
+type VarInfos []VarInfo
+
+func (vs VarInfos) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Name\tType\tSize\tValue")
+	for _, v := range vs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Name, v.Type, formatBytes(v.Size), v.Value)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// LgoHTML renders vs as an HTML table.
+func (vs VarInfos) LgoHTML() string {
+	var buf bytes.Buffer
+	buf.WriteString("<table>\n<tr><th>Name</th><th>Type</th><th>Size</th><th>Value</th></tr>\n")
+	for _, v := range vs {
+		fmt.Fprintf(&buf, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(v.Name), html.EscapeString(v.Type), formatBytes(v.Size), html.EscapeString(v.Value))
+	}
+	buf.WriteString("</table>\n")
+	return buf.String()
+}
+
+// formatBytes formats the size of memory in a human-readable form (e.g. 1.5 KiB).
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		f /= 1024
+		if f < 1024 || unit == "GiB" {
+			return fmt.Sprintf("%.1f %s", f, unit)
+		}
+	}
+	panic("unreachable")
+}
+
+// sizer computes approximate sizes of values. Memory referenced from multiple places is counted once.
+type sizer struct {
+	seen map[uintptr]bool
+}
+
+// visit returns false if p was visited before.
+func (s *sizer) visit(p uintptr) bool {
+	if p == 0 || s.seen[p] {
+		return false
+	}
+	s.seen[p] = true
+	return true
+}
+
+// hasRefs returns true if values of t may reference other memory.
+func hasRefs(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.String, reflect.Map, reflect.Interface, reflect.Chan:
+		return true
+	case reflect.Array:
+		return t.Len() > 0 && hasRefs(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasRefs(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// refSize returns the size of memory referenced from v. The size of v itself is not included.
+func (s *sizer) refSize(v reflect.Value) int64 {
+	if !hasRefs(v.Type()) {
+		return 0
+	}
+	var n int64
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || !s.visit(v.Pointer()) {
+			return 0
+		}
+		return int64(v.Type().Elem().Size()) + s.refSize(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() || !s.visit(v.Pointer()) {
+			return 0
+		}
+		n = int64(v.Cap()) * int64(v.Type().Elem().Size())
+		if hasRefs(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				n += s.refSize(v.Index(i))
+			}
+		}
+	case reflect.String:
+		n = int64(v.Len())
+	case reflect.Map:
+		if v.IsNil() || !s.visit(v.Pointer()) {
+			return 0
+		}
+		entry := int64(v.Type().Key().Size() + v.Type().Elem().Size())
+		for _, key := range v.MapKeys() {
+			n += entry + s.refSize(key) + s.refSize(v.MapIndex(key))
+		}
+	case reflect.Chan:
+		if v.IsNil() || !s.visit(v.Pointer()) {
+			return 0
+		}
+		n = int64(v.Cap()) * int64(v.Type().Elem().Size())
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		switch e.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			// Pointer-shaped values are stored in interfaces directly.
+		default:
+			n = int64(e.Type().Size())
+		}
+		n += s.refSize(e)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			n += s.refSize(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			n += s.refSize(v.Field(i))
+		}
+	}
+	return n
+}
+
+// sizeOf returns the approximate size of memory held by the variable p points to.
+func sizeOf(p interface{}) int64 {
+	v := reflect.ValueOf(p).Elem()
+	s := &sizer{seen: make(map[uintptr]bool)}
+	return int64(v.Type().Size()) + s.refSize(v)
+}
+
+const (
+	// maxPreviewLen is the max number of characters in previews of values.
+	maxPreviewLen = 80
+	// maxPreviewElems is the max number of elements of slices formatted in previews.
+	maxPreviewElems = 32
+)
+
+// preview returns a short string representation of v.
+func preview(v reflect.Value) string {
+	var s string
+	switch {
+	case v.Kind() == reflect.Slice && v.Len() > maxPreviewElems:
+		// Do not format all elements of large slices.
+		s = strings.TrimSuffix(fmt.Sprintf("%v", v.Slice(0, maxPreviewElems).Interface()), "]") + " ...]"
+	case v.Kind() == reflect.Map && v.Len() > maxPreviewElems:
+		s = fmt.Sprintf("map with %d entries", v.Len())
+	default:
+		s = fmt.Sprintf("%v", v.Interface())
+	}
+	if utf8.RuneCountInString(s) > maxPreviewLen {
+		s = string([]rune(s)[:maxPreviewLen-3]) + "..."
+	}
+	return s
+}
+
+// LgoVarInfos returns the summaries of variables in AllVars in the order of names.
+// types maps the names of variables to their types. Variables not in types are not included.
+// If the type of a variable is empty, the type of the value is used.
+// LgoVarInfos is used to implement %whos internally.
+func LgoVarInfos(types map[string]string) VarInfos {
+	var names []string
+	for name := range types {
+		if len(AllVars[name]) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	infos := make(VarInfos, 0, len(names))
+	for _, name := range names {
+		ptrs := AllVars[name]
+		// The last one is the variable which is visible now.
+		p := ptrs[len(ptrs)-1]
+		v := reflect.ValueOf(p).Elem()
+		typ := types[name]
+		if typ == "" {
+			typ = v.Type().String()
+		}
+		infos = append(infos, VarInfo{
+			Name:  name,
+			Type:  typ,
+			Size:  sizeOf(p),
+			Value: preview(v),
+		})
+	}
+	return infos
+}
+
+// LgoSaveVarInfos writes the result of LgoVarInfos to a file at path in JSON.
+// LgoSaveVarInfos is used to pass variables in worker processes to the kernel internally.
+func LgoSaveVarInfos(path string, types map[string]string) {
+	b, err := json.Marshal(LgoVarInfos(types))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode variables: %v\n", err)
+		return
+	}
+	if err := ioutil.WriteFile(path, b, 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save variables: %v\n", err)
+	}
+}
+
+// LgoDeleteVars deletes variables with DeleteVar and prints the size of memory freed by GC.
+// LgoDeleteVars is used to implement %del internally.
+func LgoDeleteVars(names ...string) {
+	var before, after runtime.MemStats
+	// Collect garbage of previous executions so that it is not counted.
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for _, name := range names {
+		DeleteVar(name)
+	}
+	// Run GC and return memory to OS.
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&after)
+	var freed int64
+	if after.HeapAlloc < before.HeapAlloc {
+		freed = int64(before.HeapAlloc - after.HeapAlloc)
+	}
+	fmt.Printf("Deleted %s (freed %s)\n", strings.Join(names, ", "), formatBytes(freed))
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestSizeOf(t *testing.T) {
+	type node struct {
+		next *node
+		data []byte
+	}
+	cycle := &node{data: make([]byte, 100)}
+	cycle.next = cycle
+	n := 10
+	s := "hello"
+	ints := make([]int, 3, 10)
+	strs := []string{"ab", "cde"}
+	var iface interface{} = [4]int64{}
+	tests := []struct {
+		name string
+		p    interface{}
+		want int64
+	}{
+		{"int", &n, 8},
+		{"string", &s, int64(unsafe.Sizeof(s)) + 5},
+		{"slice", &ints, int64(unsafe.Sizeof(ints)) + 10*8},
+		{"strings", &strs, int64(unsafe.Sizeof(strs)) + 2*int64(unsafe.Sizeof(s)) + 5},
+		{"interface", &iface, int64(unsafe.Sizeof(iface)) + 32},
+		{"cycle", &cycle, 8 + int64(unsafe.Sizeof(*cycle)) + 100},
+	}
+	for _, tc := range tests {
+		if got := sizeOf(tc.p); got != tc.want {
+			t.Errorf("sizeOf(%s) = %d; want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	long := make([]int, 1000)
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{10, "10"},
+		{"hello", "hello"},
+		{[]int{1, 2, 3}, "[1 2 3]"},
+		{strings.Repeat("a", 100), strings.Repeat("a", maxPreviewLen-3) + "..."},
+		{long, "[" + strings.Repeat("0 ", maxPreviewElems) + "...]"},
+	}
+	for _, tc := range tests {
+		if got := preview(reflect.ValueOf(tc.v)); got != tc.want {
+			t.Errorf("preview(%v) = %q; want %q", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{3 << 20, "3.0 MiB"},
+		{5 << 40, "5120.0 GiB"},
+	}
+	for _, tc := range tests {
+		if got := formatBytes(tc.n); got != tc.want {
+			t.Errorf("formatBytes(%d) = %q; want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestLgoVarInfos(t *testing.T) {
+	orig := AllVars
+	defer func() { AllVars = orig }()
+	AllVars = make(map[string][]interface{})
+
+	n, s := 10, "hello"
+	LgoRegisterVar("n", &n)
+	LgoRegisterVar("s", &s)
+	// n is shadowed by another n.
+	n2 := int64(20)
+	LgoRegisterVar("n", &n2)
+
+	want := VarInfos{
+		{Name: "n", Type: "int64", Size: 8, Value: "20"},
+		{Name: "s", Type: "mystring", Size: int64(unsafe.Sizeof(s)) + 5, Value: "hello"},
+	}
+	types := map[string]string{"n": "", "s": "mystring", "missing": "int"}
+	if got := LgoVarInfos(types); !reflect.DeepEqual(got, want) {
+		t.Errorf("LgoVarInfos() = %#v; want %#v", got, want)
+	}
+	html := LgoVarInfos(types).LgoHTML()
+	if !strings.Contains(html, "<tr><td>n</td><td>int64</td><td>8 B</td><td>20</td></tr>") {
+		t.Errorf("Unexpected HTML: %s", html)
+	}
+
+	dir, err := ioutil.TempDir("", "lgo_core_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vars.json")
+	LgoSaveVarInfos(path, types)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved VarInfos
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, want) {
+		t.Errorf("Saved %#v; want %#v", saved, want)
+	}
+}
+
+func TestDeleteVar(t *testing.T) {
+	orig := AllVars
+	defer func() { AllVars = orig }()
+	AllVars = make(map[string][]interface{})
+
+	b1, b2 := make([]byte, 10), make([]byte, 20)
+	n := 10
+	LgoRegisterVar("b", &b1)
+	LgoRegisterVar("b", &b2)
+	LgoRegisterVar("n", &n)
+	if !DeleteVar("b") {
+		t.Error("DeleteVar(b) returned false")
+	}
+	if b1 != nil || b2 != nil {
+		t.Errorf("Variables are not cleared: %v, %v", b1, b2)
+	}
+	if _, ok := AllVars["b"]; ok {
+		t.Error("b is not removed from AllVars")
+	}
+	if DeleteVar("b") {
+		t.Error("DeleteVar(b) returned true for a deleted variable")
+	}
+	if n != 10 || len(AllVars["n"]) != 1 {
+		t.Errorf("n is changed unexpectedly: %d, %v", n, AllVars)
+	}
+}
@@ -0,0 +1,97 @@
+// Package display provides helpers to display Go values (images, tables, JSON and errors) in Jupyter Notebook.
+//
+// All helpers display data in the output of the current execution through core.DataDisplayer.Raw.
+// Like methods of core.DataDisplayer, helpers receive a display id. If id is nil, a new output is created.
+// If id points an empty string, a new display ID is reserved and stored to id.
+// If id points a non-empty string, the output with the same ID is overwritten.
+//
+// Importing this package also registers renderers to core so that image.Image values are shown as images
+// when they are the last expressions of cells.
+package display
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html"
+	"image"
+	"image/png"
+
+	"github.com/yunabe/lgo/core"
+)
+
+func init() {
+	core.RegisterRenderer("image/png", func(v interface{}) (interface{}, bool) {
+		img, ok := v.(image.Image)
+		if !ok {
+			return nil, false
+		}
+		b, err := encodePNG(img)
+		if err != nil {
+			return nil, false
+		}
+		return b, true
+	})
+}
+
+// displayer returns the DataDisplayer of the current execution.
+func displayer() (core.DataDisplayer, error) {
+	d := core.GetExecContext().Display
+	if d == nil {
+		return nil, errors.New("display is not available in this environment")
+	}
+	return d, nil
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Image displays img as a PNG image.
+func Image(img image.Image, id *string) error {
+	d, err := displayer()
+	if err != nil {
+		return err
+	}
+	b, err := encodePNG(img)
+	if err != nil {
+		return err
+	}
+	return d.Raw("image/png", b, id)
+}
+
+// JSON displays v as application/json. v must be encodable with encoding/json.
+// JupyterLab shows JSON in a collapsible tree view.
+func JSON(v interface{}, id *string) error {
+	d, err := displayer()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.Raw("application/json", json.RawMessage(b), id)
+}
+
+// errorHTML renders err as HTML.
+func errorHTML(err error) string {
+	return `<pre style="color:#a94442;background-color:#f2dede;border:1px solid #ebccd1;border-radius:4px;padding:8px">` +
+		html.EscapeString(err.Error()) + `</pre>`
+}
+
+// Error displays err with a style for errors. Nothing is displayed if err is nil.
+func Error(err error, id *string) error {
+	if err == nil {
+		return nil
+	}
+	d, derr := displayer()
+	if derr != nil {
+		return derr
+	}
+	return d.Raw("text/html", errorHTML(err), id)
+}
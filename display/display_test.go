@@ -0,0 +1,204 @@
+package display
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+type rawRecord struct {
+	contentType string
+	value       interface{}
+	id          string
+}
+
+type recordDisplayer struct {
+	core.DataDisplayer
+	records []rawRecord
+	nextID  int
+}
+
+func (d *recordDisplayer) Raw(contentType string, v interface{}, id *string) error {
+	r := rawRecord{contentType: contentType, value: v}
+	if id != nil {
+		if *id == "" {
+			d.nextID++
+			*id = string(rune('0' + d.nextID))
+		}
+		r.id = *id
+	}
+	d.records = append(d.records, r)
+	return nil
+}
+
+func runWithDisplay(t *testing.T, f func()) *recordDisplayer {
+	d := &recordDisplayer{}
+	if err := core.ExecLgoEntryPoint(core.LgoContext{Context: context.Background(), Display: d}, f); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	d := runWithDisplay(t, func() {
+		if err := Image(img, nil); err != nil {
+			t.Error(err)
+		}
+	})
+	if len(d.records) != 1 || d.records[0].contentType != "image/png" {
+		t.Fatalf("Unexpected records: %v", d.records)
+	}
+	b := d.records[0].value.([]byte)
+	if !strings.HasPrefix(string(b), "\x89PNG") {
+		t.Errorf("Not a PNG image: %q", b[:8])
+	}
+	// image.Image is rendered as PNG when it is the last expression.
+	if _, ok := core.RenderMimeBundle(img)["image/png"]; !ok {
+		t.Error("image/png is not rendered")
+	}
+}
+
+func TestJSONAndError(t *testing.T) {
+	d := runWithDisplay(t, func() {
+		if err := JSON(map[string]int{"a": 1}, nil); err != nil {
+			t.Error(err)
+		}
+		if err := JSON(func() {}, nil); err == nil {
+			t.Error("JSON with a func succeeded unexpectedly")
+		}
+		if err := Error(errors.New("<failed>"), nil); err != nil {
+			t.Error(err)
+		}
+		if err := Error(nil, nil); err != nil {
+			t.Error(err)
+		}
+	})
+	if len(d.records) != 2 {
+		t.Fatalf("Unexpected records: %v", d.records)
+	}
+	if got := string(d.records[0].value.(json.RawMessage)); got != `{"a":1}` {
+		t.Errorf("Got %s", got)
+	}
+	if d.records[1].contentType != "text/html" || !strings.Contains(d.records[1].value.(string), "&lt;failed&gt;") {
+		t.Errorf("Unexpected error output: %v", d.records[1])
+	}
+}
+
+type person struct {
+	Name   string
+	Age    int
+	secret string
+}
+
+func TestTableRows(t *testing.T) {
+	tests := []struct {
+		v      interface{}
+		header []string
+		rows   [][]string
+	}{
+		{
+			[]person{{"Alice", 20, "x"}, {"Bob", 30, "y"}},
+			[]string{"Name", "Age"},
+			[][]string{{"Alice", "20"}, {"Bob", "30"}},
+		},
+		{
+			[]*person{{"Alice", 20, "x"}, nil},
+			[]string{"Name", "Age"},
+			[][]string{{"Alice", "20"}, {"", ""}},
+		},
+		{
+			[]map[string]int{{"b": 1}, {"a": 2, "b": 3}},
+			[]string{"b", "a"},
+			[][]string{{"1", ""}, {"3", "2"}},
+		},
+		{
+			[]float64{1.5, 2},
+			[]string{"value"},
+			[][]string{{"1.5"}, {"2"}},
+		},
+		{
+			map[string]bool{"y": false, "x": true},
+			[]string{"key", "value"},
+			[][]string{{"x", "true"}, {"y", "false"}},
+		},
+		{
+			map[int]string{10: "ten", 2: "two", -1: "minus one"},
+			[]string{"key", "value"},
+			[][]string{{"-1", "minus one"}, {"2", "two"}, {"10", "ten"}},
+		},
+		{
+			map[float64]int{10.5: 1, 9: 2},
+			[]string{"key", "value"},
+			[][]string{{"9", "2"}, {"10.5", "1"}},
+		},
+		{
+			map[interface{}]int{"a": 1, 10: 2, 2: 3},
+			[]string{"key", "value"},
+			[][]string{{"2", "3"}, {"10", "2"}, {"a", "1"}},
+		},
+	}
+	for _, tc := range tests {
+		header, rows, err := tableRows(reflect.ValueOf(tc.v))
+		if err != nil {
+			t.Errorf("tableRows(%v) failed: %v", tc.v, err)
+			continue
+		}
+		if !reflect.DeepEqual(header, tc.header) || !reflect.DeepEqual(rows, tc.rows) {
+			t.Errorf("tableRows(%v) = %q, %q; want %q, %q", tc.v, header, rows, tc.header, tc.rows)
+		}
+	}
+	if _, err := NewTable(10); err == nil {
+		t.Error("NewTable(10) succeeded unexpectedly")
+	}
+}
+
+func TestTablePages(t *testing.T) {
+	var values []string
+	for i := 0; i < 5; i++ {
+		values = append(values, string(rune('a'+i)))
+	}
+	values[4] = "<e>"
+	d := runWithDisplay(t, func() {
+		tbl, err := NewTable(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl.PageSize = 2
+		if n := tbl.NumPages(); n != 3 {
+			t.Errorf("Got %d; want 3", n)
+		}
+		if err := tbl.Show(); err != nil {
+			t.Error(err)
+		}
+		if err := tbl.Page(2); err != nil {
+			t.Error(err)
+		}
+		if err := tbl.Next(); err == nil {
+			t.Error("Next on the last page succeeded unexpectedly")
+		}
+	})
+	if len(d.records) != 2 {
+		t.Fatalf("Unexpected records: %v", d.records)
+	}
+	// The second page is displayed to the same output.
+	if d.records[0].id == "" || d.records[0].id != d.records[1].id {
+		t.Errorf("Display IDs are not reused: %q, %q", d.records[0].id, d.records[1].id)
+	}
+	first := d.records[0].value.(string)
+	if !strings.Contains(first, "<td>a</td>") || strings.Contains(first, "<td>c</td>") {
+		t.Errorf("Unexpected first page: %s", first)
+	}
+	last := d.records[1].value.(string)
+	if !strings.Contains(last, "<td>&lt;e&gt;</td>") || !strings.Contains(last, "page 3/3") {
+		t.Errorf("Unexpected last page: %s", last)
+	}
+}
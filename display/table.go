@@ -0,0 +1,251 @@
+package display
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"reflect"
+	"sort"
+)
+
+// DefaultPageSize is the default number of rows in a page of Table.
+const DefaultPageSize = 20
+
+// Table displays a slice (or an array) of structs, maps or other values, or a map as an HTML table.
+// Rows are split into pages and one page is displayed at once.
+// Table updates its output in place when the page is changed.
+type Table struct {
+	// PageSize is the number of rows in a page.
+	PageSize int
+
+	header []string
+	rows   [][]string
+	page   int
+	id     string
+}
+
+// NewTable returns a new Table of v.
+//
+// Each element of a slice is a row. Exported fields of structs and keys of maps are columns.
+// Other values are shown in a single column. A map is shown as a table with key and value columns.
+func NewTable(v interface{}) (*Table, error) {
+	header, rows, err := tableRows(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return &Table{
+		PageSize: DefaultPageSize,
+		header:   header,
+		rows:     rows,
+	}, nil
+}
+
+// DisplayTable displays the first page of v as an HTML table.
+func DisplayTable(v interface{}) (*Table, error) {
+	t, err := NewTable(v)
+	if err != nil {
+		return nil, err
+	}
+	return t, t.Show()
+}
+
+// NumPages returns the number of pages.
+func (t *Table) NumPages() int {
+	size := t.pageSize()
+	if len(t.rows) == 0 {
+		return 1
+	}
+	return (len(t.rows) + size - 1) / size
+}
+
+func (t *Table) pageSize() int {
+	if t.PageSize <= 0 {
+		return DefaultPageSize
+	}
+	return t.PageSize
+}
+
+// Show displays the current page of the table. From the second call, the output is overwritten.
+func (t *Table) Show() error {
+	d, err := displayer()
+	if err != nil {
+		return err
+	}
+	return d.Raw("text/html", t.HTML(), &t.id)
+}
+
+// Page shows the i-th page (0-origin).
+func (t *Table) Page(i int) error {
+	if i < 0 || i >= t.NumPages() {
+		return fmt.Errorf("page %d is out of range [0, %d)", i, t.NumPages())
+	}
+	t.page = i
+	return t.Show()
+}
+
+// Next shows the next page.
+func (t *Table) Next() error {
+	return t.Page(t.page + 1)
+}
+
+// Prev shows the previous page.
+func (t *Table) Prev() error {
+	return t.Page(t.page - 1)
+}
+
+// HTML returns the HTML of the current page.
+func (t *Table) HTML() string {
+	var buf bytes.Buffer
+	buf.WriteString("<table>\n<thead><tr><th></th>")
+	for _, h := range t.header {
+		fmt.Fprintf(&buf, "<th>%s</th>", html.EscapeString(h))
+	}
+	buf.WriteString("</tr></thead>\n<tbody>\n")
+	size := t.pageSize()
+	start := t.page * size
+	end := start + size
+	if end > len(t.rows) {
+		end = len(t.rows)
+	}
+	for i := start; i < end; i++ {
+		fmt.Fprintf(&buf, "<tr><th>%d</th>", i)
+		for _, cell := range t.rows[i] {
+			fmt.Fprintf(&buf, "<td>%s</td>", html.EscapeString(cell))
+		}
+		buf.WriteString("</tr>\n")
+	}
+	buf.WriteString("</tbody>\n</table>\n")
+	if t.NumPages() > 1 {
+		fmt.Fprintf(&buf, "<p>Rows %d-%d of %d (page %d/%d)</p>\n", start, end-1, len(t.rows), t.page+1, t.NumPages())
+	}
+	return buf.String()
+}
+
+// tableRows converts v to the header and rows of a table.
+func tableRows(v reflect.Value) (header []string, rows [][]string, err error) {
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		return sliceRows(v)
+	case reflect.Map:
+		keys := sortedKeys(v)
+		for _, k := range keys {
+			rows = append(rows, []string{fmt.Sprint(k.Interface()), fmt.Sprint(v.MapIndex(k).Interface())})
+		}
+		return []string{"key", "value"}, rows, nil
+	case reflect.Invalid:
+		return nil, nil, errors.New("can not display nil as a table")
+	}
+	return nil, nil, fmt.Errorf("can not display %s as a table", v.Type())
+}
+
+func sliceRows(v reflect.Value) (header []string, rows [][]string, err error) {
+	elemType := v.Type().Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	switch elemType.Kind() {
+	case reflect.Struct:
+		var fields []int
+		for i := 0; i < elemType.NumField(); i++ {
+			if f := elemType.Field(i); f.PkgPath == "" {
+				fields = append(fields, i)
+				header = append(header, f.Name)
+			}
+		}
+		for i := 0; i < v.Len(); i++ {
+			e := indirect(v.Index(i))
+			row := make([]string, len(fields))
+			if e.IsValid() {
+				for j, f := range fields {
+					row[j] = fmt.Sprint(e.Field(f).Interface())
+				}
+			}
+			rows = append(rows, row)
+		}
+	case reflect.Map:
+		// Columns are the union of keys of all maps.
+		colIdx := make(map[interface{}]int)
+		var cols []reflect.Value
+		for i := 0; i < v.Len(); i++ {
+			e := indirect(v.Index(i))
+			if !e.IsValid() {
+				continue
+			}
+			for _, k := range sortedKeys(e) {
+				if _, ok := colIdx[k.Interface()]; !ok {
+					colIdx[k.Interface()] = len(cols)
+					cols = append(cols, k)
+				}
+			}
+		}
+		for _, k := range cols {
+			header = append(header, fmt.Sprint(k.Interface()))
+		}
+		for i := 0; i < v.Len(); i++ {
+			e := indirect(v.Index(i))
+			row := make([]string, len(cols))
+			if e.IsValid() {
+				for j, k := range cols {
+					if val := e.MapIndex(k); val.IsValid() {
+						row[j] = fmt.Sprint(val.Interface())
+					}
+				}
+			}
+			rows = append(rows, row)
+		}
+	default:
+		header = []string{"value"}
+		for i := 0; i < v.Len(); i++ {
+			rows = append(rows, []string{fmt.Sprint(v.Index(i).Interface())})
+		}
+	}
+	return header, rows, nil
+}
+
+// indirect dereferences pointers and interfaces. It returns the zero Value for nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// lessKey compares map keys by their values (e.g. 2 < 10 for numbers).
+// Keys of different kinds (e.g. in map[interface{}]T) are ordered by their kinds.
+func lessKey(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface && !a.IsNil() {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface && !b.IsNil() {
+		b = b.Elem()
+	}
+	if a.Kind() != b.Kind() {
+		return a.Kind() < b.Kind()
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	}
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
+
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return keys
+}
@@ -32,7 +32,18 @@ func (*handlers) HandleExecuteRequest(
 	ctx context.Context,
 	r *scaffold.ExecuteRequest,
 	stream func(string, string),
-	displayData func(data *scaffold.DisplayData, update bool)) *scaffold.ExecuteResult {
+	displayData func(data *scaffold.DisplayData, update bool),
+	readInput func(ctx context.Context, prompt string, password bool) (string, error),
+	writeExecuteResult func(count int, data *scaffold.DisplayData)) *scaffold.ExecuteResult {
+	if r.Code == "input" {
+		name, err := readInput(ctx, "Your name: ", false)
+		if err != nil {
+			stream("stderr", err.Error())
+			return &scaffold.ExecuteResult{Status: "error"}
+		}
+		stream("stdout", fmt.Sprintf("Hello, %s!", name))
+		return &scaffold.ExecuteResult{Status: "ok"}
+	}
 	var i int
 	tick := time.Tick(time.Second)
 	cancelled := false
@@ -69,6 +80,11 @@ loop:
 	} else {
 		res.Status = "ok"
 		stream("stdout", "Done!")
+		writeExecuteResult(i, &scaffold.DisplayData{
+			Data: map[string]interface{}{
+				"text/plain": fmt.Sprintf("%d loops", i),
+			},
+		})
 	}
 	return res
 }
@@ -104,6 +120,10 @@ func (*handlers) HandleGoFmt(req *scaffold.GoFmtRequest) (*scaffold.GoFmtReply,
 	return nil, errors.New("not implemented")
 }
 
+func (*handlers) HandleVariables(ctx context.Context, req *scaffold.VariablesRequest) (*scaffold.VariablesReply, error) {
+	return nil, errors.New("not implemented")
+}
+
 func main() {
 	flag.Parse()
 	fmt.Printf("os.Args == %+v\n", os.Args)
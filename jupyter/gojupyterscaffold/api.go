@@ -3,22 +3,31 @@ package gojupyterscaffold
 import "context"
 
 // RequestHandlers is the interface to define handlers to handle Jupyter messages.
-// Except for HandleGoFmt, all mesages are defined in
+// Except for HandleGoFmt and HandleVariables, all mesages are defined in
 // http://jupyter-client.readthedocs.io/en/latest/messaging.html
 type RequestHandlers interface {
 	HandleKernelInfo() KernelInfo
 	// HandleExecuteRequest handles execute_request.
 	// writeStream sends stdout/stderr texts and writeDisplayData sends display_data
 	// (or update_display_data if update is true) to the client.
+	// readInput sends input_request to the client and returns the value of input_reply.
+	// readInput returns ErrStdinNotAllowed if req.AllowStdin is false.
+	// readInput returns an error without sending input_request once its ctx or ctx of the execution is done.
+	// writeExecuteResult sends execute_result, which is shown as Out[count] in the client.
 	HandleExecuteRequest(ctx context.Context,
 		req *ExecuteRequest,
 		writeStream func(name, text string),
-		writeDisplayData func(data *DisplayData, update bool)) *ExecuteResult
+		writeDisplayData func(data *DisplayData, update bool),
+		readInput func(ctx context.Context, prompt string, password bool) (string, error),
+		writeExecuteResult func(count int, data *DisplayData)) *ExecuteResult
 	HandleComplete(req *CompleteRequest) *CompleteReply
 	HandleInspect(req *InspectRequest) *InspectReply
 	// http://jupyter-client.readthedocs.io/en/latest/messaging.html#code-completeness
 	HandleIsComplete(req *IsCompleteRequest) *IsCompleteReply
 	HandleGoFmt(req *GoFmtRequest) (*GoFmtReply, error)
+	// HandleVariables handles variables_request, which frontends send to show variables in the kernel.
+	// variables_request is handled sequentially with execute_requests.
+	HandleVariables(ctx context.Context, req *VariablesRequest) (*VariablesReply, error)
 }
 
 // KernelInfo is a reply to kernel_info_request.
@@ -54,6 +63,13 @@ type errorReply struct {
 	Evalue string `json:"evalue,omitempty"`
 }
 
+// executeErrorReply is execute_reply with Status "error".
+// Traceback overrides ExecuteResult.Traceback because traceback is required in error replies.
+type executeErrorReply struct {
+	*ExecuteResult
+	Traceback []string `json:"traceback"`
+}
+
 // InspectRequest represents inspect_request.
 // See http://jupyter-client.readthedocs.io/en/latest/messaging.html#introspection
 type InspectRequest struct {
@@ -113,6 +129,13 @@ type ExecuteResult struct {
 	Status         string `json:"status"`
 	ExecutionCount int    `json:"execution_count,omitempty"`
 	// data and metadata are omitted because they are covered by DisplayData.
+
+	// Ename, Evalue and Traceback describe the error if Status is "error".
+	// They are also published as an error message on iopub so that clients show the error as an output.
+	// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#execution-errors
+	Ename     string   `json:"ename,omitempty"`
+	Evalue    string   `json:"evalue,omitempty"`
+	Traceback []string `json:"traceback,omitempty"`
 }
 
 // DisplayData represents display_data defined in http://jupyter-client.readthedocs.io/en/latest/messaging.html#display-data
@@ -152,6 +175,22 @@ type IsCompleteReply struct {
 	Indent string `json:"indent"`
 }
 
+// InputRequest represents input_request.
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#messages-on-the-stdin-router-dealer-sockets
+type InputRequest struct {
+	// the text to show at the prompt
+	Prompt string `json:"prompt"`
+	// Is the request for a password?
+	// If so, the frontend shouldn't echo input.
+	Password bool `json:"password"`
+}
+
+// InputReply represents input_reply.
+type InputReply struct {
+	// the value entered by users
+	Value string `json:"value"`
+}
+
 // GoFmtRequest is the struct to represent "go fmt" request.
 type GoFmtRequest struct {
 	Code string `json:"code"`
@@ -162,3 +201,22 @@ type GoFmtReply struct {
 	Status string `json:"status"`
 	Code   string `json:"code"`
 }
+
+// VariablesRequest is the struct to represent "variables" request.
+type VariablesRequest struct{}
+
+// Variable is the summary of a variable in VariablesReply.
+type Variable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Size is the approximate size of memory held by the variable in bytes.
+	Size int64 `json:"size"`
+	// Value is the truncated string representation of the value.
+	Value string `json:"value"`
+}
+
+// VariablesReply is the struct to represent "variables" reply.
+type VariablesReply struct {
+	Status    string     `json:"status"`
+	Variables []Variable `json:"variables"`
+}
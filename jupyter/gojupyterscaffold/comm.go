@@ -0,0 +1,315 @@
+package gojupyterscaffold
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Comms are custom messages between kernels and frontends.
+// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#custom-messages
+//
+// Binary buffers in comm messages are not supported.
+
+// commOpenContent represents the content of comm_open.
+type commOpenContent struct {
+	CommID     string                 `json:"comm_id"`
+	TargetName string                 `json:"target_name"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// commMsgContent represents the content of comm_msg and comm_close.
+type commMsgContent struct {
+	CommID string                 `json:"comm_id"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+type commInfoRequest struct {
+	TargetName string `json:"target_name,omitempty"`
+}
+
+type commInfo struct {
+	TargetName string `json:"target_name"`
+}
+
+type commInfoReply struct {
+	Status string              `json:"status"`
+	Comms  map[string]commInfo `json:"comms"`
+}
+
+// A Comm is a channel of custom messages between the kernel and the frontend.
+// Methods of Comm are goroutine-safe.
+type Comm struct {
+	id         string
+	targetName string
+	manager    *commManager
+
+	mu      sync.Mutex
+	closed  bool
+	onMsg   func(data map[string]interface{})
+	onClose func(data map[string]interface{})
+}
+
+// ID returns the ID of the comm.
+func (c *Comm) ID() string {
+	return c.id
+}
+
+// TargetName returns the target name of the comm.
+func (c *Comm) TargetName() string {
+	return c.targetName
+}
+
+// Send sends data to the frontend with comm_msg. data must be encodable to a JSON object.
+func (c *Comm) Send(data interface{}) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return errors.New("comm is closed")
+	}
+	return c.manager.publish("comm_msg", &struct {
+		CommID string      `json:"comm_id"`
+		Data   interface{} `json:"data"`
+	}{c.id, data}, nil)
+}
+
+// Close closes the comm and notifies it to the frontend with comm_close.
+func (c *Comm) Close(data interface{}) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.manager.remove(c.id)
+	return c.manager.publish("comm_close", &struct {
+		CommID string      `json:"comm_id"`
+		Data   interface{} `json:"data"`
+	}{c.id, data}, nil)
+}
+
+// OnMsg sets a function which is called when the frontend sends comm_msg to the comm.
+func (c *Comm) OnMsg(f func(data map[string]interface{})) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onMsg = f
+}
+
+// OnClose sets a function which is called when the frontend closes the comm.
+func (c *Comm) OnClose(f func(data map[string]interface{})) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onClose = f
+}
+
+func (c *Comm) handleMsg(data map[string]interface{}) {
+	c.mu.Lock()
+	f := c.onMsg
+	c.mu.Unlock()
+	if f != nil {
+		f(data)
+	}
+}
+
+func (c *Comm) handleClose(data map[string]interface{}) {
+	c.mu.Lock()
+	c.closed = true
+	f := c.onClose
+	c.mu.Unlock()
+	if f != nil {
+		f(data)
+	}
+}
+
+// commManager manages comms in the kernel.
+type commManager struct {
+	iopub *iopubSocket
+	// parent returns the message which is being handled now.
+	// Messages of comms are sent with the message as the parent.
+	parent func() *message
+
+	mu      sync.Mutex
+	comms   map[string]*Comm
+	targets map[string]func(c *Comm, data map[string]interface{})
+}
+
+func newCommManager(iopub *iopubSocket, parent func() *message) *commManager {
+	return &commManager{
+		iopub:   iopub,
+		parent:  parent,
+		comms:   make(map[string]*Comm),
+		targets: make(map[string]func(c *Comm, data map[string]interface{})),
+	}
+}
+
+func (m *commManager) publish(msgType string, content interface{}, metadata map[string]interface{}) error {
+	parent := m.parent()
+	if parent == nil {
+		parent = &message{}
+	}
+	var msg message
+	msg.Identity = [][]byte{[]byte(msgType)}
+	msg.Header.MsgType = msgType
+	msg.Header.Version = "5.2"
+	msg.Header.Username = "username"
+	msg.Header.Session = parent.Header.Session
+	msg.Header.MsgID = genMsgID()
+	msg.ParentHeader = parent.Header
+	if metadata != nil {
+		msg.Metadata = metadata
+	}
+	msg.Content = content
+	if err := m.iopub.sendMessage(&msg); err != nil {
+		return fmt.Errorf("Failed to send %s: %v", msgType, err)
+	}
+	return nil
+}
+
+func (m *commManager) add(c *Comm) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.comms[c.id] = c
+}
+
+func (m *commManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.comms, id)
+}
+
+func (m *commManager) get(id string) *Comm {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.comms[id]
+}
+
+func (m *commManager) open(targetName string, data interface{}, metadata map[string]interface{}) (*Comm, error) {
+	c := &Comm{
+		id:         genMsgID(),
+		targetName: targetName,
+		manager:    m,
+	}
+	m.add(c)
+	if err := m.publish("comm_open", &struct {
+		CommID     string      `json:"comm_id"`
+		TargetName string      `json:"target_name"`
+		Data       interface{} `json:"data"`
+	}{c.id, targetName, data}, metadata); err != nil {
+		m.remove(c.id)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *commManager) registerTarget(targetName string, onOpen func(c *Comm, data map[string]interface{})) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets[targetName] = onOpen
+}
+
+func (m *commManager) info(targetName string) map[string]commInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	comms := make(map[string]commInfo)
+	for id, c := range m.comms {
+		if targetName == "" || targetName == c.targetName {
+			comms[id] = commInfo{TargetName: c.targetName}
+		}
+	}
+	return comms
+}
+
+// handleMessage handles comm_open, comm_msg and comm_close from the frontend.
+func (m *commManager) handleMessage(msg *message) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("Panic in a handler of %s: %v", msg.Header.MsgType, p)
+		}
+	}()
+	switch msg.Header.MsgType {
+	case "comm_open":
+		content := msg.Content.(*commOpenContent)
+		m.mu.Lock()
+		onOpen := m.targets[content.TargetName]
+		m.mu.Unlock()
+		if onOpen == nil {
+			logger.Errorf("No comm target is registered for %q", content.TargetName)
+			// Notify the frontend that the comm is not available.
+			c := &Comm{id: content.CommID, targetName: content.TargetName, manager: m}
+			c.Close(nil)
+			return
+		}
+		c := &Comm{id: content.CommID, targetName: content.TargetName, manager: m}
+		m.add(c)
+		onOpen(c, content.Data)
+	case "comm_msg":
+		content := msg.Content.(*commMsgContent)
+		if c := m.get(content.CommID); c != nil {
+			c.handleMsg(content.Data)
+		} else {
+			logger.Warningf("comm_msg for an unknown comm: %s", content.CommID)
+		}
+	case "comm_close":
+		content := msg.Content.(*commMsgContent)
+		if c := m.get(content.CommID); c != nil {
+			m.remove(content.CommID)
+			c.handleClose(content.Data)
+		}
+	}
+}
+
+// OpenComm opens a new comm with targetName in the frontend.
+// data and metadata are sent to the frontend with comm_open. metadata can be nil.
+func (s *Server) OpenComm(targetName string, data interface{}, metadata map[string]interface{}) (*Comm, error) {
+	return s.comms.open(targetName, data, metadata)
+}
+
+// RegisterCommTarget registers a function which is called when the frontend opens a comm with targetName.
+func (s *Server) RegisterCommTarget(targetName string, onOpen func(c *Comm, data map[string]interface{})) {
+	s.comms.registerTarget(targetName, onOpen)
+}
+
+// commParent returns the comm message which is being handled now or nil.
+func (s *Server) commParent(msgType string) *message {
+	parent := s.comms.parent()
+	if parent == nil || !strings.HasPrefix(parent.Header.MsgType, "comm_") {
+		logger.Warningf("%s is sent while no comm message is handled", msgType)
+		return nil
+	}
+	return parent
+}
+
+// CommStream sends text to the frontend as the output of a callback of a comm. name is "stdout" or "stderr".
+// Outputs of callbacks are sent with the comm message being handled now as the parent.
+// Thus, the frontend shows them in the output area which captures the message (e.g. an Output widget)
+// or in the log console. Outputs sent while no comm message is handled are dropped.
+func (s *Server) CommStream(name, text string) {
+	if parent := s.commParent("stream"); parent != nil {
+		s.iopub.sendStream(name, text, parent)
+	}
+}
+
+// CommDisplayData sends data to the frontend as the output of a callback of a comm. See CommStream.
+func (s *Server) CommDisplayData(data *DisplayData, update bool) {
+	if parent := s.commParent("display_data"); parent != nil {
+		s.iopub.sendDisplayData(data, parent, update)
+	}
+}
+
+// CommError sends an error of a callback of a comm to the frontend. See CommStream.
+func (s *Server) CommError(ename, evalue string, traceback []string) {
+	if parent := s.commParent("error"); parent != nil {
+		s.iopub.sendError(ename, evalue, traceback, parent)
+	}
+}
+
+// pushComm pushes a comm message from the frontend to the queue so that comm messages are handled
+// sequentially with execute_requests.
+func (q *executeQueue) pushComm(msg *message, comms *commManager) {
+	q.pushFunc(msg, func() {
+		comms.handleMessage(msg)
+	})
+}
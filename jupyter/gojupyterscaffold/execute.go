@@ -11,6 +11,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 const executeQueueSize = 1 << 8
@@ -18,27 +19,67 @@ const executeQueueSize = 1 << 8
 type executeQueueItem struct {
 	req  *message
 	sock *shellSocket
+	// run is set if the item is not an execute_request (e.g. comm_msg).
+	run func()
 }
 
 type executeQueue struct {
 	serverCtx  context.Context
 	queue      chan *executeQueueItem
 	iopub      *iopubSocket
+	stdin      *stdinSocket
 	handlers   RequestHandlers
 	currentCtx *contextAndCancel
+
+	// current is the message which is being handled in the queue.
+	current   *message
+	currentMu sync.Mutex
 }
 
-func newExecuteQueue(ctx context.Context, iopub *iopubSocket, handlers RequestHandlers) *executeQueue {
+func newExecuteQueue(ctx context.Context, iopub *iopubSocket, stdin *stdinSocket, handlers RequestHandlers) *executeQueue {
 	return &executeQueue{
 		serverCtx: ctx,
 		queue:     make(chan *executeQueueItem, executeQueueSize),
 		iopub:     iopub,
+		stdin:     stdin,
 		handlers:  handlers,
 	}
 }
 
 func (q *executeQueue) push(req *message, sock *shellSocket) {
-	q.queue <- &executeQueueItem{req, sock}
+	q.queue <- &executeQueueItem{req: req, sock: sock}
+}
+
+// pushFunc pushes f, which handles req, to the queue so that f runs sequentially with execute_requests.
+func (q *executeQueue) pushFunc(req *message, f func()) {
+	q.queue <- &executeQueueItem{req: req, run: f}
+}
+
+func (q *executeQueue) setCurrentMessage(msg *message) {
+	q.currentMu.Lock()
+	defer q.currentMu.Unlock()
+	q.current = msg
+}
+
+// currentMessage returns the message which is being handled in the queue or nil.
+// This method is goroutine-safe.
+func (q *executeQueue) currentMessage() *message {
+	q.currentMu.Lock()
+	defer q.currentMu.Unlock()
+	return q.current
+}
+
+// runFunc runs a non-execute_request item.
+func (q *executeQueue) runFunc(item *executeQueueItem) {
+	err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
+		q.setCurrentMessage(item.req)
+		defer q.setCurrentMessage(nil)
+		item.run()
+		return nil
+	}, item.req)
+	if err != nil {
+		logger.Errorf("Failed to handle %s: %v", item.req.Header.MsgType, err)
+	}
 }
 
 // abortQueue aborts requests in the queue.
@@ -52,6 +93,11 @@ loop:
 		default:
 			break loop
 		}
+		if item.run != nil {
+			// Messages other than execute_request are not aborted.
+			q.runFunc(item)
+			continue
+		}
 		err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
 			res := newMessageWithParent(item.req)
 			res.Header.MsgType = "execute_reply"
@@ -87,14 +133,20 @@ loop:
 		case <-q.serverCtx.Done():
 			break loop
 		}
+		if item.run != nil {
+			q.runFunc(item)
+			continue
+		}
 
 		exReq := item.req.Content.(*ExecuteRequest)
 		err := q.iopub.WithOngoingContext(func(ctx context.Context) error {
 			cur, cancel := context.WithCancel(ctx)
 			q.currentCtx = &contextAndCancel{cur, cancel}
+			q.setCurrentMessage(item.req)
 			defer func() {
 				cancel()
 				q.currentCtx = nil
+				q.setCurrentMessage(nil)
 			}()
 			result := q.handlers.HandleExecuteRequest(
 				cur,
@@ -103,10 +155,38 @@ loop:
 					q.iopub.sendStream(name, text, item.req)
 				}, func(data *DisplayData, update bool) {
 					q.iopub.sendDisplayData(data, item.req, update)
+				}, func(ctx context.Context, prompt string, password bool) (string, error) {
+					if !exReq.AllowStdin {
+						return "", ErrStdinNotAllowed
+					}
+					// Stop waiting for input_reply when either ctx or the execution is done.
+					ctx, cancel := context.WithCancel(ctx)
+					defer cancel()
+					stop := make(chan struct{})
+					defer close(stop)
+					go func() {
+						select {
+						case <-cur.Done():
+							cancel()
+						case <-stop:
+						}
+					}()
+					return q.stdin.readInput(ctx, item.req, prompt, password)
+				}, func(count int, data *DisplayData) {
+					q.iopub.sendExecuteResult(count, data, item.req)
 				})
 			res := newMessageWithParent(item.req)
 			res.Header.MsgType = "execute_reply"
 			res.Content = &result
+			if result.Status == "error" {
+				reply := &executeErrorReply{ExecuteResult: result, Traceback: result.Traceback}
+				if reply.Traceback == nil {
+					reply.Traceback = []string{}
+				}
+				// Publish the error before execute_reply as ipykernel does.
+				q.iopub.sendError(reply.Ename, reply.Evalue, reply.Traceback, item.req)
+				res.Content = reply
+			}
 			if err := item.sock.pushResult(res); err != nil {
 				logger.Errorf("Failed to send execute_reply: %v", err)
 			}
@@ -65,13 +65,14 @@ type Server struct {
 	shell   *shellSocket
 	control *shellSocket
 	iopub   *iopubSocket
-	stdin   *zmq.Socket
+	stdin   *stdinSocket
 	hb      *zmq.Socket
 
 	// Attribute
 	connInfo *connectionInfo
 
 	execQueue *executeQueue
+	comms     *commManager
 }
 
 // NewServer returns a new jupyter kernel server.
@@ -98,23 +99,22 @@ func NewServer(bgCtx context.Context, connectionFile string, handlers RequestHan
 		return nil, fmt.Errorf("Failed to create iopub socket: %v", err)
 	}
 
-	execQueue := newExecuteQueue(serverCtx, iopub, handlers)
-	shell, err := newShellSocket(serverCtx, ctx, "shell", cinfo, iopub, handlers, cancelCtx, execQueue)
+	stdin, err := newStdinSocket(ctx, cinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	execQueue := newExecuteQueue(serverCtx, iopub, stdin, handlers)
+	comms := newCommManager(iopub, execQueue.currentMessage)
+	shell, err := newShellSocket(serverCtx, ctx, "shell", cinfo, iopub, handlers, cancelCtx, execQueue, comms)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create shell socket: %v", err)
 	}
-	control, err := newShellSocket(serverCtx, ctx, "control", cinfo, iopub, handlers, cancelCtx, execQueue)
+	control, err := newShellSocket(serverCtx, ctx, "control", cinfo, iopub, handlers, cancelCtx, execQueue, comms)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create control socket: %v", err)
 	}
 
-	stdin, err := ctx.NewSocket(zmq.ROUTER)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to open stdin socket: %v", err)
-	}
-	if err := stdin.Bind(cinfo.getAddr(cinfo.StdinPort)); err != nil {
-		return nil, fmt.Errorf("Failed to bind shell socket: %v", err)
-	}
 	// Ref: Python version of HeartBeat
 	// https://github.com/ipython/ipykernel/blob/master/ipykernel/heartbeat.py
 	hb, err := ctx.NewSocket(zmq.REP)
@@ -135,6 +135,7 @@ func NewServer(bgCtx context.Context, connectionFile string, handlers RequestHan
 		hb:        hb,
 		connInfo:  cinfo,
 		execQueue: execQueue,
+		comms:     comms,
 	}, nil
 }
 
@@ -220,6 +221,7 @@ func (s *Server) Loop() {
 	if err := s.control.close(); err != nil {
 		logger.Errorf("Failed to close control socket: %v", err)
 	}
-
-	// TODO: Support stdin.
+	if err := s.stdin.close(); err != nil {
+		logger.Errorf("Failed to close stdin socket: %v", err)
+	}
 }
@@ -47,3 +47,123 @@ func TestMessageHeader(t *testing.T) {
 		t.Errorf("Unexpected header: %#v", header)
 	}
 }
+
+func TestInputReplyRoundTrip(t *testing.T) {
+	key := []byte("37485811-fb40116f79cb23af4056c7a8")
+	msg := message{Identity: [][]byte{[]byte("client")}}
+	msg.Header.MsgType = "input_reply"
+	msg.Content = &InputReply{Value: "hello"}
+	bs, err := msg.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got message
+	if err := got.Unmarshal(bs, key); err != nil {
+		t.Fatal(err)
+	}
+	reply, ok := got.Content.(*InputReply)
+	if !ok {
+		t.Fatalf("Unexpected content type: %T", got.Content)
+	}
+	if reply.Value != "hello" {
+		t.Errorf("Got %q; want %q", reply.Value, "hello")
+	}
+}
+
+func TestCommMessages(t *testing.T) {
+	key := []byte("37485811-fb40116f79cb23af4056c7a8")
+	roundTrip := func(msgType string, content interface{}) *message {
+		msg := message{Identity: [][]byte{[]byte("client")}}
+		msg.Header.MsgType = msgType
+		msg.Content = content
+		bs, err := msg.Marshal(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got message
+		if err := got.Unmarshal(bs, key); err != nil {
+			t.Fatal(err)
+		}
+		return &got
+	}
+	m := newCommManager(nil, func() *message { return nil })
+	c := &Comm{id: "comm0", targetName: "target", manager: m}
+	m.add(c)
+	var received []interface{}
+	c.OnMsg(func(data map[string]interface{}) {
+		received = append(received, data["value"])
+	})
+	closed := false
+	c.OnClose(func(data map[string]interface{}) {
+		closed = true
+	})
+
+	m.handleMessage(roundTrip("comm_msg", map[string]interface{}{
+		"comm_id": "comm0",
+		"data":    map[string]interface{}{"value": "hello"},
+	}))
+	// Messages to unknown comms are ignored.
+	m.handleMessage(roundTrip("comm_msg", map[string]interface{}{
+		"comm_id": "unknown",
+		"data":    map[string]interface{}{"value": "ignored"},
+	}))
+	if len(received) != 1 || received[0] != "hello" {
+		t.Errorf("Unexpected messages: %v", received)
+	}
+	if info := m.info(""); len(info) != 1 || info["comm0"].TargetName != "target" {
+		t.Errorf("Unexpected comm info: %v", info)
+	}
+	if info := m.info("other"); len(info) != 0 {
+		t.Errorf("Unexpected comm info: %v", info)
+	}
+
+	m.handleMessage(roundTrip("comm_close", map[string]interface{}{
+		"comm_id": "comm0",
+	}))
+	if !closed {
+		t.Error("OnClose was not called")
+	}
+	if info := m.info(""); len(info) != 0 {
+		t.Errorf("The closed comm remains: %v", info)
+	}
+	if err := c.Send(nil); err == nil {
+		t.Error("Send to a closed comm succeeded unexpectedly")
+	}
+}
+
+func TestExecuteErrorReply(t *testing.T) {
+	ok, err := json.Marshal(&ExecuteResult{Status: "ok", ExecutionCount: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"status":"ok","execution_count":3}`; string(ok) != want {
+		t.Errorf("Got %s; want %s", ok, want)
+	}
+	// traceback is always set in error replies.
+	b, err := json.Marshal(&executeErrorReply{ExecuteResult: &ExecuteResult{
+		Status: "error", ExecutionCount: 4, Ename: "CompileError", Evalue: "1:1: undeclared name: x",
+	}, Traceback: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"status":"error","execution_count":4,"ename":"CompileError","evalue":"1:1: undeclared name: x","traceback":[]}`; string(b) != want {
+		t.Errorf("Got %s; want %s", b, want)
+	}
+}
+
+func TestCommParent(t *testing.T) {
+	var current *message
+	s := &Server{comms: newCommManager(nil, func() *message { return current })}
+	if p := s.commParent("stream"); p != nil {
+		t.Errorf("Got %v; want nil", p)
+	}
+	current = &message{}
+	current.Header.MsgType = "execute_request"
+	if p := s.commParent("stream"); p != nil {
+		t.Errorf("Outputs of comms are sent to %s", p.Header.MsgType)
+	}
+	current.Header.MsgType = "comm_msg"
+	if p := s.commParent("stream"); p != current {
+		t.Errorf("Got %v; want %v", p, current)
+	}
+}
@@ -47,6 +47,16 @@ func newContentForMsgType(header *messageHeader) interface{} {
 		return &IsCompleteRequest{}
 	case "gofmt_request":
 		return &GoFmtRequest{}
+	case "variables_request":
+		return &VariablesRequest{}
+	case "input_reply":
+		return &InputReply{}
+	case "comm_open":
+		return &commOpenContent{}
+	case "comm_msg", "comm_close":
+		return &commMsgContent{}
+	case "comm_info_request":
+		return &commInfoRequest{}
 	}
 	return nil
 }
@@ -161,6 +161,58 @@ func (s *iopubSocket) sendDisplayData(data *DisplayData, parent *message, update
 	}
 }
 
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#id7
+func (s *iopubSocket) sendExecuteResult(count int, data *DisplayData, parent *message) {
+	metadata := data.Metadata
+	if metadata == nil {
+		metadata = emptyMetadata
+	}
+	var msg message
+	msg.Identity = [][]byte{[]byte("execute_result")}
+	msg.Header.MsgType = "execute_result"
+	msg.Header.Version = "5.2"
+	msg.Header.Username = "username"
+	msg.Header.MsgID = genMsgID()
+	msg.ParentHeader = parent.Header
+	msg.Content = &struct {
+		ExecutionCount int                    `json:"execution_count"`
+		Data           map[string]interface{} `json:"data"`
+		Metadata       map[string]interface{} `json:"metadata"`
+		Transient      map[string]interface{} `json:"transient,omitempty"`
+	}{
+		ExecutionCount: count,
+		Data:           data.Data,
+		Metadata:       metadata,
+		Transient:      data.Transient,
+	}
+	if err := s.sendMessage(&msg); err != nil {
+		logger.Errorf("Failed to send execute_result: %v", err)
+	}
+}
+
+// http://jupyter-client.readthedocs.io/en/latest/messaging.html#execution-errors
+func (s *iopubSocket) sendError(ename, evalue string, traceback []string, parent *message) {
+	var msg message
+	msg.Identity = [][]byte{[]byte("error")}
+	msg.Header.MsgType = "error"
+	msg.Header.Version = "5.2"
+	msg.Header.Username = "username"
+	msg.Header.MsgID = genMsgID()
+	msg.ParentHeader = parent.Header
+	msg.Content = &struct {
+		Ename     string   `json:"ename"`
+		Evalue    string   `json:"evalue"`
+		Traceback []string `json:"traceback"`
+	}{
+		Ename:     ename,
+		Evalue:    evalue,
+		Traceback: traceback,
+	}
+	if err := s.sendMessage(&msg); err != nil {
+		logger.Errorf("Failed to send error: %v", err)
+	}
+}
+
 type shellSocket struct {
 	name          string
 	hmacKey       []byte
@@ -175,9 +227,10 @@ type shellSocket struct {
 	cancelCtx func()
 
 	execQueue *executeQueue
+	comms     *commManager
 }
 
-func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string, cinfo *connectionInfo, iopub *iopubSocket, handlers RequestHandlers, cancelCtx func(), execQueue *executeQueue) (*shellSocket, error) {
+func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string, cinfo *connectionInfo, iopub *iopubSocket, handlers RequestHandlers, cancelCtx func(), execQueue *executeQueue, comms *commManager) (*shellSocket, error) {
 	var routerAddr string
 	if name == "shell" {
 		routerAddr = cinfo.getAddr(cinfo.ShellPort)
@@ -220,6 +273,7 @@ func newShellSocket(serverCtx context.Context, zmqCtx *zmq.Context, name string,
 		ctx:        serverCtx,
 		cancelCtx:  cancelCtx,
 		execQueue:  execQueue,
+		comms:      comms,
 	}, nil
 }
 
@@ -330,6 +384,19 @@ func (s *shellSocket) handleMessages() error {
 		// TODO: Send shutdown_reply
 	case "execute_request":
 		s.execQueue.push(&msg, s)
+	case "comm_open", "comm_msg", "comm_close":
+		s.execQueue.pushComm(&msg, s.comms)
+	case "comm_info_request":
+		go func() {
+			req := msg.Content.(*commInfoRequest)
+			res := newMessageWithParent(&msg)
+			res.Header.MsgType = "comm_info_reply"
+			res.Content = &commInfoReply{
+				Status: "ok",
+				Comms:  s.comms.info(req.TargetName),
+			}
+			s.pushResult(res)
+		}()
 	case "complete_request":
 		go func() {
 			reply := s.handlers.HandleComplete(msg.Content.(*CompleteRequest))
@@ -389,6 +456,26 @@ func (s *shellSocket) handleMessages() error {
 			}
 			s.pushResult(res)
 		}()
+	case "variables_request":
+		// Variables must not be read while cells are running.
+		s.execQueue.pushFunc(&msg, func() {
+			res := newMessageWithParent(&msg)
+			res.Header.MsgType = "variables_reply"
+			reply, err := s.handlers.HandleVariables(s.execQueue.serverCtx, msg.Content.(*VariablesRequest))
+			if err != nil {
+				res.Content = &errorReply{
+					Status: "error",
+					Ename:  "error",
+					Evalue: err.Error(),
+				}
+			} else {
+				if reply.Variables == nil {
+					reply.Variables = make([]Variable, 0)
+				}
+				res.Content = reply
+			}
+			s.pushResult(res)
+		})
 	default:
 		logger.Warningf("Unsupported MsgType in %s: %q", s.name, typ)
 	}
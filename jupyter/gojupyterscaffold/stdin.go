@@ -0,0 +1,104 @@
+package gojupyterscaffold
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+// How often stdinSocket checks the cancellation of the execution while it waits for input_reply.
+const stdinPollInterval = 100 * time.Millisecond
+
+// ErrStdinNotAllowed is returned when kernels read inputs for execute_request with allow_stdin=false.
+var ErrStdinNotAllowed = errors.New("stdin is not allowed by the frontend")
+
+// stdinSocket sends input_request to the frontend and receives input_reply on the stdin ROUTER socket.
+// See http://jupyter-client.readthedocs.io/en/latest/messaging.html#messages-on-the-stdin-router-dealer-sockets
+type stdinSocket struct {
+	socket  *zmq.Socket
+	hmacKey []byte
+	// mutex serializes input requests because zmq sockets are not goroutine-safe.
+	mutex sync.Mutex
+}
+
+func newStdinSocket(zmqCtx *zmq.Context, cinfo *connectionInfo) (*stdinSocket, error) {
+	stdin, err := zmqCtx.NewSocket(zmq.ROUTER)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open stdin socket: %v", err)
+	}
+	if err := stdin.Bind(cinfo.getAddr(cinfo.StdinPort)); err != nil {
+		return nil, fmt.Errorf("Failed to bind stdin socket: %v", err)
+	}
+	return &stdinSocket{
+		socket:  stdin,
+		hmacKey: []byte(cinfo.Key),
+	}, nil
+}
+
+func (s *stdinSocket) close() error {
+	return s.socket.Close()
+}
+
+// readInput sends input_request to the frontend which sent parent and waits for input_reply.
+// readInput returns ctx.Err() if ctx is cancelled before input_reply arrives.
+// input_request is not sent if ctx is already cancelled.
+func (s *stdinSocket) readInput(ctx context.Context, parent *message, prompt string, password bool) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	// Discard stale replies to the requests cancelled before.
+	for {
+		if _, err := s.socket.RecvMessageBytes(zmq.DONTWAIT); err != nil {
+			break
+		}
+	}
+	// The identities of parent (a message on shell socket) are used to route input_request to the frontend.
+	req := newMessageWithParent(parent)
+	req.Header.MsgType = "input_request"
+	req.Content = &InputRequest{
+		Prompt:   prompt,
+		Password: password,
+	}
+	if err := req.Send(s.socket, s.hmacKey); err != nil {
+		return "", fmt.Errorf("Failed to send input_request: %v", err)
+	}
+	poller := zmq.NewPoller()
+	poller.Add(s.socket, zmq.POLLIN)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		default:
+		}
+		polled, err := poller.Poll(stdinPollInterval)
+		if isEINTR(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("Poll on stdin socket failed: %v", err)
+		}
+		if len(polled) == 0 {
+			continue
+		}
+		msgs, err := s.socket.RecvMessageBytes(0)
+		if err != nil {
+			return "", fmt.Errorf("Failed to receive data from stdin: %v", err)
+		}
+		var msg message
+		if err := msg.Unmarshal(msgs, s.hmacKey); err != nil {
+			return "", fmt.Errorf("Failed to unmarshal messages from stdin: %v", err)
+		}
+		reply, ok := msg.Content.(*InputReply)
+		if !ok {
+			logger.Warningf("Unsupported MsgType in stdin: %q", msg.Header.MsgType)
+			continue
+		}
+		return reply.Value, nil
+	}
+}
@@ -0,0 +1,103 @@
+// Package magic parses magic commands in lgo cells.
+//
+// Like IPython, a cell which starts with %name is a line magic and a cell which starts with %%name is a cell magic.
+// The arguments of a line magic are the rest of the cell. The arguments of a cell magic are the rest of the first line
+// and the body of a cell magic is the rest of the cell (e.g. the source of a package in %%package).
+// Magic commands are not valid Go code because % is a binary operator. Thus, they do not conflict with lgo code.
+package magic
+
+import (
+	"strings"
+)
+
+// Prefix is the prefix of line magics. Cell magics start with Prefix twice.
+const Prefix = "%"
+
+// A Command is a parsed magic command.
+type Command struct {
+	// Name is the name of the command without Prefix (e.g. "time" for %time).
+	Name string
+	// Cell is true if the command is a cell magic.
+	Cell bool
+	// Args are the arguments of the command with surrounding spaces removed.
+	Args string
+	// Body is the body of a cell magic. It is empty for line magics.
+	Body string
+	// ArgsOffset is the offset of Args in the source of the cell.
+	ArgsOffset int
+}
+
+// String returns the name of c with prefixes (e.g. %time or %%package).
+func (c *Command) String() string {
+	if c.Cell {
+		return Prefix + Prefix + c.Name
+	}
+	return Prefix + c.Name
+}
+
+func isNameChar(c byte, first bool) bool {
+	if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' {
+		return true
+	}
+	return !first && '0' <= c && c <= '9'
+}
+
+// nameEnd returns the end of the name which starts at s[0]. It returns 0 if s does not start with a name.
+func nameEnd(s string) int {
+	i := 0
+	for i < len(s) && isNameChar(s[i], i == 0) {
+		i++
+	}
+	return i
+}
+
+// IsMagic returns true if src starts with a magic command. Leading spaces and empty lines are ignored.
+func IsMagic(src string) bool {
+	return strings.HasPrefix(strings.TrimLeft(src, " \t\r\n"), Prefix)
+}
+
+// Parse parses a magic command in src. It returns false if src is not a magic command.
+// Parse succeeds even if the name is empty (e.g. while the name is being typed) to support completion.
+func Parse(src string) (*Command, bool) {
+	trimmed := strings.TrimLeft(src, " \t\r\n")
+	if !strings.HasPrefix(trimmed, Prefix) {
+		return nil, false
+	}
+	pos := len(src) - len(trimmed) + len(Prefix)
+	c := &Command{}
+	if strings.HasPrefix(src[pos:], Prefix) {
+		c.Cell = true
+		pos += len(Prefix)
+	}
+	end := pos + nameEnd(src[pos:])
+	c.Name = src[pos:end]
+	if end < len(src) && !strings.ContainsRune(" \t\r\n", rune(src[end])) {
+		// e.g. %foo-bar
+		return nil, false
+	}
+	rest := src[end:]
+	if c.Cell {
+		if i := strings.Index(rest, "\n"); i >= 0 {
+			rest, c.Body = rest[:i], rest[i+1:]
+		}
+	}
+	c.Args = strings.TrimSpace(rest)
+	c.ArgsOffset = end + len(rest) - len(strings.TrimLeft(rest, " \t\r\n"))
+	return c, true
+}
+
+// NameRange returns the range of the name of the magic command in src including prefixes.
+// It is used to complete names of commands.
+func NameRange(src string) (start, end int, ok bool) {
+	trimmed := strings.TrimLeft(src, " \t\r\n")
+	if !strings.HasPrefix(trimmed, Prefix) {
+		return 0, 0, false
+	}
+	start = len(src) - len(trimmed)
+	end = start + len(Prefix)
+	if strings.HasPrefix(src[end:], Prefix) {
+		end += len(Prefix)
+	}
+	end += nameEnd(src[end:])
+	return start, end, true
+}
@@ -0,0 +1,59 @@
+package magic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want *Command
+	}{
+		{"%lsmagic", &Command{Name: "lsmagic", ArgsOffset: 8}},
+		{"\n  %save_session  /tmp/s \n", &Command{Name: "save_session", Args: "/tmp/s", ArgsOffset: 18}},
+		{"%time for i := 0; i < 10; i++ {\n}", &Command{Name: "time", Args: "for i := 0; i < 10; i++ {\n}", ArgsOffset: 6}},
+		{"%%package mylib/geom\npackage geom\n", &Command{Name: "package", Cell: true, Args: "mylib/geom", Body: "package geom\n", ArgsOffset: 10}},
+		{"%%package", &Command{Name: "package", Cell: true, ArgsOffset: 9}},
+		{"%", &Command{ArgsOffset: 1}},
+		{"%%", &Command{Cell: true, ArgsOffset: 2}},
+		{"x % y", nil},
+		{"// comment\n%time", nil},
+		{"%foo-bar", nil},
+		{"%%%foo", nil},
+	}
+	for _, tc := range tests {
+		got, ok := Parse(tc.src)
+		if ok != (tc.want != nil) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) = %#v, %v; want %#v", tc.src, got, ok, tc.want)
+		}
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	if s := (&Command{Name: "time"}).String(); s != "%time" {
+		t.Errorf("got %q; want %%time", s)
+	}
+	if s := (&Command{Name: "package", Cell: true}).String(); s != "%%package" {
+		t.Errorf("got %q; want %%%%package", s)
+	}
+}
+
+func TestNameRange(t *testing.T) {
+	tests := []struct {
+		src        string
+		start, end int
+		ok         bool
+	}{
+		{"%sav", 0, 4, true},
+		{" %%pack x\nbody", 1, 7, true},
+		{"%", 0, 1, true},
+		{"x", 0, 0, false},
+	}
+	for _, tc := range tests {
+		start, end, ok := NameRange(tc.src)
+		if start != tc.start || end != tc.end || ok != tc.ok {
+			t.Errorf("NameRange(%q) = %d, %d, %v; want %d, %d, %v", tc.src, start, end, ok, tc.start, tc.end, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package widgets provides interactive widgets of Jupyter Notebook (ipywidgets) for lgo.
+//
+// Widgets are implemented on the comm protocol of Jupyter[1] and the widget message protocol of ipywidgets[2].
+// The frontend must have ipywidgets (jupyter-js-widgets) 7.x installed.
+//
+// References:
+// [1] http://jupyter-client.readthedocs.io/en/latest/messaging.html#custom-messages
+// [2] https://github.com/jupyter-widgets/ipywidgets/blob/master/packages/schema/messages.md
+package widgets
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/yunabe/lgo/core"
+)
+
+const (
+	// targetName is the comm target of ipywidgets.
+	targetName = "jupyter.widget"
+	// protocolVersion is the version of the widget message protocol.
+	protocolVersion = "2.0.0"
+
+	controlsModule        = "@jupyter-widgets/controls"
+	controlsModuleVersion = "1.5.0"
+
+	// widgetViewMIMEType is the content type to display widgets.
+	widgetViewMIMEType = "application/vnd.jupyter.widget-view+json"
+)
+
+// ErrCommNotSupported is returned when widgets are created in an environment without comms (e.g. lgo run).
+var ErrCommNotSupported = errors.New("comms are not supported in this environment")
+
+// widget is the common part of widgets. It keeps the state of the widget model synced with the frontend.
+type widget struct {
+	comm core.Comm
+
+	mu    sync.Mutex
+	state map[string]interface{}
+	// onUpdate is called after the state is updated by the frontend.
+	onUpdate func(state map[string]interface{})
+	// onCustom is called when the frontend sends a custom message.
+	onCustom func(content map[string]interface{})
+}
+
+func newWidget(comms core.CommManager, state map[string]interface{}) (*widget, error) {
+	if comms == nil {
+		return nil, ErrCommNotSupported
+	}
+	w := &widget{state: state}
+	c, err := comms.Open(targetName, map[string]interface{}{
+		"state":        state,
+		"buffer_paths": []interface{}{},
+	}, map[string]interface{}{
+		"version": protocolVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.comm = c
+	c.OnMsg(w.handleMsg)
+	return w, nil
+}
+
+func (w *widget) handleMsg(data map[string]interface{}) {
+	switch data["method"] {
+	case "update":
+		state, _ := data["state"].(map[string]interface{})
+		w.mu.Lock()
+		for k, v := range state {
+			w.state[k] = v
+		}
+		f := w.onUpdate
+		w.mu.Unlock()
+		if f != nil {
+			f(state)
+		}
+	case "request_state":
+		w.mu.Lock()
+		state := make(map[string]interface{})
+		for k, v := range w.state {
+			state[k] = v
+		}
+		w.mu.Unlock()
+		w.sendState(state)
+	case "custom":
+		content, _ := data["content"].(map[string]interface{})
+		w.mu.Lock()
+		f := w.onCustom
+		w.mu.Unlock()
+		if f != nil {
+			f(content)
+		}
+	}
+}
+
+func (w *widget) sendState(state map[string]interface{}) error {
+	return w.comm.Send(map[string]interface{}{
+		"method":       "update",
+		"state":        state,
+		"buffer_paths": []interface{}{},
+	})
+}
+
+// get returns the value of key in the state.
+func (w *widget) get(key string) interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state[key]
+}
+
+// set updates key in the state and sends the update to the frontend.
+func (w *widget) set(key string, value interface{}) error {
+	w.mu.Lock()
+	w.state[key] = value
+	w.mu.Unlock()
+	return w.sendState(map[string]interface{}{key: value})
+}
+
+// ModelID returns the ID of the widget model.
+func (w *widget) ModelID() string {
+	return w.comm.ID()
+}
+
+// Display displays the widget in the output of the current execution.
+func (w *widget) Display() error {
+	d := core.GetExecContext().Display
+	if d == nil {
+		return errors.New("display is not supported in this environment")
+	}
+	return d.Raw(widgetViewMIMEType, map[string]interface{}{
+		"model_id":      w.comm.ID(),
+		"version_major": 2,
+		"version_minor": 0,
+	}, nil)
+}
+
+// Close closes the widget. The widget is removed from the frontend.
+func (w *widget) Close() error {
+	return w.comm.Close(nil)
+}
+
+func controlState(model, view string) map[string]interface{} {
+	return map[string]interface{}{
+		"_model_name":           model,
+		"_model_module":         controlsModule,
+		"_model_module_version": controlsModuleVersion,
+		"_view_name":            view,
+		"_view_module":          controlsModule,
+		"_view_module_version":  controlsModuleVersion,
+		"description":           "",
+	}
+}
+
+// toInt converts a number decoded from JSON to int.
+func toInt(v interface{}) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// IntSlider is a slider to select an integer.
+type IntSlider struct {
+	*widget
+}
+
+// NewIntSlider creates a new IntSlider in the frontend.
+// Call Display to show the slider.
+func NewIntSlider(description string, min, max, value int) (*IntSlider, error) {
+	state := controlState("IntSliderModel", "IntSliderView")
+	state["description"] = description
+	state["min"] = min
+	state["max"] = max
+	state["step"] = 1
+	state["value"] = value
+	state["orientation"] = "horizontal"
+	state["continuous_update"] = true
+	state["readout"] = true
+	w, err := newWidget(core.GetExecContext().Comm, state)
+	if err != nil {
+		return nil, err
+	}
+	return &IntSlider{w}, nil
+}
+
+// Value returns the current value of the slider.
+func (s *IntSlider) Value() int {
+	return toInt(s.get("value"))
+}
+
+// SetValue sets the value of the slider.
+func (s *IntSlider) SetValue(v int) error {
+	return s.set("value", v)
+}
+
+// OnChange sets a function which is called when users change the value of the slider.
+func (s *IntSlider) OnChange(f func(value int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f == nil {
+		s.onUpdate = nil
+		return
+	}
+	s.onUpdate = func(state map[string]interface{}) {
+		if v, ok := state["value"]; ok {
+			f(toInt(v))
+		}
+	}
+}
+
+// Button is a clickable button.
+type Button struct {
+	*widget
+}
+
+// NewButton creates a new Button in the frontend.
+// Call Display to show the button.
+func NewButton(description string) (*Button, error) {
+	state := controlState("ButtonModel", "ButtonView")
+	state["description"] = description
+	state["button_style"] = ""
+	state["tooltip"] = ""
+	state["disabled"] = false
+	w, err := newWidget(core.GetExecContext().Comm, state)
+	if err != nil {
+		return nil, err
+	}
+	return &Button{w}, nil
+}
+
+// SetDescription sets the label of the button.
+func (b *Button) SetDescription(description string) error {
+	return b.set("description", description)
+}
+
+// OnClick sets a function which is called when users click the button.
+func (b *Button) OnClick(f func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f == nil {
+		b.onCustom = nil
+		return
+	}
+	b.onCustom = func(content map[string]interface{}) {
+		if content["event"] == "click" {
+			f()
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package widgets
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/yunabe/lgo/core"
+)
+
+type fakeComm struct {
+	id      string
+	sent    []interface{}
+	closed  bool
+	onMsg   func(data map[string]interface{})
+	onClose func(data map[string]interface{})
+}
+
+func (c *fakeComm) ID() string         { return c.id }
+func (c *fakeComm) TargetName() string { return targetName }
+func (c *fakeComm) Send(data interface{}) error {
+	c.sent = append(c.sent, data)
+	return nil
+}
+func (c *fakeComm) Close(data interface{}) error {
+	c.closed = true
+	return nil
+}
+func (c *fakeComm) OnMsg(f func(data map[string]interface{}))   { c.onMsg = f }
+func (c *fakeComm) OnClose(f func(data map[string]interface{})) { c.onClose = f }
+
+// receive simulates a comm_msg from the frontend. data is decoded from JSON as the kernel does.
+func (c *fakeComm) receive(t *testing.T, data string) {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatal(err)
+	}
+	c.onMsg(m)
+}
+
+type fakeCommManager struct {
+	comms    []*fakeComm
+	opens    []map[string]interface{}
+	metadata []map[string]interface{}
+}
+
+func (m *fakeCommManager) Open(target string, data interface{}, metadata map[string]interface{}) (core.Comm, error) {
+	c := &fakeComm{id: "comm" + strconv.Itoa(len(m.comms))}
+	m.comms = append(m.comms, c)
+	m.opens = append(m.opens, data.(map[string]interface{}))
+	m.metadata = append(m.metadata, metadata)
+	return c, nil
+}
+
+func (m *fakeCommManager) RegisterTarget(target string, onOpen func(c core.Comm, data map[string]interface{})) {
+}
+
+func runWithComm(t *testing.T, comms core.CommManager, f func()) {
+	if err := core.ExecLgoEntryPoint(core.LgoContext{Context: context.Background(), Comm: comms}, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntSlider(t *testing.T) {
+	comms := &fakeCommManager{}
+	var s *IntSlider
+	runWithComm(t, comms, func() {
+		var err error
+		s, err = NewIntSlider("x", 0, 10, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(comms.comms) != 1 {
+		t.Fatalf("Unexpected number of comms: %d", len(comms.comms))
+	}
+	state := comms.opens[0]["state"].(map[string]interface{})
+	if state["_model_name"] != "IntSliderModel" || state["value"] != 3 || state["max"] != 10 {
+		t.Errorf("Unexpected state: %v", state)
+	}
+	if comms.metadata[0]["version"] != protocolVersion {
+		t.Errorf("Unexpected metadata: %v", comms.metadata[0])
+	}
+
+	var changed []int
+	s.OnChange(func(v int) {
+		changed = append(changed, v)
+	})
+	c := comms.comms[0]
+	c.receive(t, `{"method": "update", "state": {"value": 7}, "buffer_paths": []}`)
+	c.receive(t, `{"method": "update", "state": {"description": "y"}, "buffer_paths": []}`)
+	if v := s.Value(); v != 7 {
+		t.Errorf("Got %d; want 7", v)
+	}
+	if !reflect.DeepEqual(changed, []int{7}) {
+		t.Errorf("Unexpected OnChange calls: %v", changed)
+	}
+
+	if err := s.SetValue(5); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"method":       "update",
+		"state":        map[string]interface{}{"value": 5},
+		"buffer_paths": []interface{}{},
+	}
+	if len(c.sent) != 1 || !reflect.DeepEqual(c.sent[0], want) {
+		t.Errorf("Unexpected messages: %v", c.sent)
+	}
+
+	c.receive(t, `{"method": "request_state"}`)
+	if len(c.sent) != 2 {
+		t.Fatalf("The state was not sent: %v", c.sent)
+	}
+	sent := c.sent[1].(map[string]interface{})["state"].(map[string]interface{})
+	if sent["value"] != 5 || sent["description"] != "y" {
+		t.Errorf("Unexpected state: %v", sent)
+	}
+}
+
+func TestButton(t *testing.T) {
+	comms := &fakeCommManager{}
+	var b *Button
+	runWithComm(t, comms, func() {
+		var err error
+		b, err = NewButton("click me")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	clicked := 0
+	b.OnClick(func() {
+		clicked++
+	})
+	c := comms.comms[0]
+	c.receive(t, `{"method": "custom", "content": {"event": "click"}}`)
+	c.receive(t, `{"method": "custom", "content": {"event": "unknown"}}`)
+	if clicked != 1 {
+		t.Errorf("Got %d; want 1", clicked)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.closed {
+		t.Error("The comm was not closed")
+	}
+}
+
+func TestNoComm(t *testing.T) {
+	runWithComm(t, nil, func() {
+		if _, err := NewButton("b"); err != ErrCommNotSupported {
+			t.Errorf("Got %v; want %v", err, ErrCommNotSupported)
+		}
+	})
+}